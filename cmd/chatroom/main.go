@@ -0,0 +1,287 @@
+// Command chatroom is a non-interactive client for scripts and CI jobs:
+//
+//	chatroom send -server host:9000 -user ci-bot "build passed"
+//	chatroom sendfile -user ci-bot -to alice report.pdf
+//	chatroom tail -json
+//
+// Flags go before the positional arguments. The exit status tells what went
+// wrong; see the exit* constants.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chatroom/pkg/chatclient"
+	"chatroom/pkg/logger"
+)
+
+const (
+	exitOK          = 0
+	exitError       = 1 // anything not covered below
+	exitUsage       = 2
+	exitAuth        = 3 // the server rejected the username
+	exitUnknownUser = 4 // the recipient is not online
+	exitTimeout     = 5 // connecting or sending took longer than -timeout
+)
+
+const usage = `Usage: chatroom <command> [flags] [arguments]
+
+Commands:
+  send [-to user] message...   send a public message, or a private one with -to
+  sendfile [-to user] path     share a file with the room, or send it to one user
+  tail [-json]                 print incoming messages until interrupted
+
+Common flags:
+  -server host:port   chat server address (default localhost:9000)
+  -user name          username to log in as (default chatroom-cli)
+  -timeout duration   how long to wait for the server (default 10s)
+  -v                  print client diagnostics to stderr
+
+Exit status: 0 success, 1 error, 2 usage, 3 authentication failed,
+4 unknown user, 5 timeout.
+`
+
+type options struct {
+	server  string
+	user    string
+	timeout time.Duration
+	verbose bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "send":
+		return runSend(args[1:])
+	case "sendfile":
+		return runSendFile(args[1:])
+	case "tail":
+		return runTail(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "chatroom: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	opts := &options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	fs.StringVar(&opts.server, "server", "localhost:9000", "chat server address")
+	fs.StringVar(&opts.user, "user", "chatroom-cli", "username to log in as")
+	fs.DurationVar(&opts.timeout, "timeout", 10*time.Second, "how long to wait for the server")
+	fs.BoolVar(&opts.verbose, "v", false, "print client diagnostics to stderr")
+	return fs, opts
+}
+
+func runSend(args []string) int {
+	fs, opts := newFlagSet("send")
+	to := fs.String("to", "", "send a private message to this user")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	text := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if text == "" {
+		fmt.Fprintln(os.Stderr, "chatroom send: missing message")
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	c, err := dial(ctx, opts)
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer c.Close()
+
+	if *to == "" {
+		err = c.Send(text)
+	} else {
+		err = c.SendPrivate(*to, text)
+	}
+	if err != nil {
+		return fail("send", err)
+	}
+	// A private message waits in the outbox for the recipient's public key,
+	// and anything an earlier run left there goes first; do not hang up
+	// before it is all sent.
+	return fail("send", c.Flush(ctx))
+}
+
+func runSendFile(args []string) int {
+	fs, opts := newFlagSet("sendfile")
+	to := fs.String("to", "", "send the file to this user only")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "chatroom sendfile: expected exactly one file")
+		return exitUsage
+	}
+	path := fs.Arg(0)
+	if _, err := os.Stat(path); err != nil {
+		return fail("sendfile", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	c, err := dial(ctx, opts)
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer c.Close()
+
+	events := c.Subscribe(ctx)
+	if *to == "" {
+		err = c.SendFile(path)
+	} else {
+		err = c.SendPrivateFile(*to, path)
+	}
+	if err != nil {
+		return fail("sendfile", err)
+	}
+
+	// The server confirms uploads with an info message that quotes the
+	// filename, and reports failures the same way.
+	quoted := "'" + filepath.Base(path) + "'"
+	return fail("sendfile", waitFor(ctx, events, func(ev chatclient.Event) (bool, error) {
+		switch ev := ev.(type) {
+		case chatclient.InfoEvent:
+			return strings.Contains(ev.Text, quoted), nil
+		case chatclient.ErrorEvent:
+			return false, errors.New(ev.Text)
+		}
+		return false, nil
+	}))
+}
+
+// jsonMessage is one line of "tail -json" output.
+type jsonMessage struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"` // "public" or "private"
+	From      string    `json:"from"`
+	To        string    `json:"to,omitempty"`
+	Content   string    `json:"content"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func runTail(args []string) int {
+	fs, opts := newFlagSet("tail")
+	asJSON := fs.Bool("json", false, "print one JSON object per message")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	dialCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	c, err := dial(dialCtx, opts)
+	cancel()
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer c.Close()
+
+	out := json.NewEncoder(os.Stdout)
+	for ev := range c.Subscribe(ctx) {
+		var msg *chatclient.Message
+		switch ev := ev.(type) {
+		case chatclient.PublicMessageEvent:
+			msg = ev.Message
+		case chatclient.PrivateMessageEvent:
+			msg = ev.Message
+		default:
+			continue
+		}
+
+		if !*asJSON {
+			fmt.Fprintln(os.Stdout, msg.String())
+			continue
+		}
+		kind := "public"
+		if msg.Private {
+			kind = "private"
+		}
+		out.Encode(jsonMessage{
+			ID:        msg.ID,
+			Type:      kind,
+			From:      msg.From,
+			To:        msg.To,
+			Content:   msg.Content,
+			ParentID:  msg.ParentID,
+			Timestamp: msg.Timestamp,
+		})
+	}
+	return exitOK
+}
+
+// dial connects to the server. The client library logs to stderr; unless
+// -v was given those diagnostics are dropped.
+func dial(ctx context.Context, opts *options) (*chatclient.Client, error) {
+	if !opts.verbose {
+		logger.SetOutput(io.Discard)
+	}
+	return chatclient.Dial(ctx, opts.server, opts.user)
+}
+
+// waitFor reads events until done reports true or an error, or ctx ends.
+func waitFor(ctx context.Context, events <-chan chatclient.Event, done func(chatclient.Event) (bool, error)) error {
+	for ev := range events {
+		ok, err := done(ev)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection closed")
+}
+
+// fail prints err and maps it to an exit status. A nil err means success.
+func fail(action string, err error) int {
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "chatroom: %s: %v\n", action, err)
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, chatclient.ErrAuthFailed):
+		return exitAuth
+	case errors.Is(err, chatclient.ErrUnknownUser):
+		return exitUnknownUser
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return exitTimeout
+	}
+	return exitError
+}
@@ -1,18 +1,19 @@
 package main
 
 import (
-	"log"
-
 	"chatroom/internal/client"
 	"chatroom/internal/client/gui"
+	"chatroom/pkg/logger"
 )
 
+var log = logger.New("main")
+
 func main() {
 	client := client.New()
 	app := gui.NewApp(client)
 
 	if err := app.Run(); err != nil {
-		log.Fatal("Application error:", err)
+		log.Fatal("Application error", "err", err)
 	}
 
 	defer client.Disconnect()
@@ -0,0 +1,57 @@
+// Command echobot is an example bot built on pkg/chatclient. It repeats
+// public messages back to the room and answers private messages privately.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"time"
+
+	"chatroom/pkg/chatclient"
+	"chatroom/pkg/logger"
+)
+
+var log = logger.New("echobot")
+
+func main() {
+	server := flag.String("server", "localhost:9000", "chat server address")
+	user := flag.String("user", "echo-bot", "username of the bot")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	c, err := chatclient.Dial(dialCtx, *server, *user)
+	cancel()
+	if err != nil {
+		log.Fatal("Failed to connect", "server", *server, "err", err)
+	}
+	defer c.Close()
+
+	log.Info("Connected", "server", *server, "user", c.Username())
+
+	for ev := range c.Subscribe(ctx) {
+		switch ev := ev.(type) {
+		case chatclient.PublicMessageEvent:
+			if ev.Message.Outgoing {
+				continue
+			}
+			if err := c.Send(ev.Message.From + " said: " + ev.Message.Content); err != nil {
+				log.Warn("Failed to echo", "err", err)
+			}
+		case chatclient.PrivateMessageEvent:
+			if ev.Message.Outgoing {
+				continue
+			}
+			if err := c.SendPrivate(ev.Message.From, "You said: "+ev.Message.Content); err != nil {
+				log.Warn("Failed to echo", "to", ev.Message.From, "err", err)
+			}
+		case chatclient.ErrorEvent:
+			log.Warn("Server error", "error", ev.Text)
+		}
+	}
+	log.Info("Shutting down")
+}
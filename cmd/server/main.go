@@ -2,46 +2,131 @@ package main
 
 import (
 	"chatroom/internal/server"
+	"chatroom/internal/server/audit"
+	"chatroom/internal/server/plugin"
+	"chatroom/internal/server/webhook"
+	"chatroom/pkg/logger"
 	"context"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
+var log = logger.New("main")
+
 func main() {
 	newKey := flag.Bool("n", false, "Generate a new room key (delete existing savestate)")
 	oldKey := flag.Bool("o", false, "Use existing room key if available")
+	admins := flag.String("admins", "", "Comma-separated list of admin usernames")
+	webhooks := flag.String("webhooks", "", "JSON file with outgoing webhook endpoints")
+	wsAddr := flag.String("ws", "", "Also accept WebSocket clients on this address, e.g. :9001 (path /ws)")
+	ircAddr := flag.String("irc", "", "Also accept IRC clients on this address, e.g. :6667 (decrypts their messages server-side)")
+	adminAPI := flag.String("admin-api", "", "Serve the admin API and dashboard on this localhost address, e.g. 127.0.0.1:9090 (token in $CHATROOM_ADMIN_TOKEN)")
+	metricsAddr := flag.String("metrics", "", "Serve Prometheus metrics on this address, e.g. 127.0.0.1:9100 (path /metrics)")
+	incoming := flag.String("incoming", "", "JSON file enabling the incoming webhook endpoint")
+	logLevel := flag.String("log-level", "", "Log level: debug, info, warn or error (default $CHATROOM_LOG_LEVEL or info)")
+	logFormat := flag.String("log-format", "", "Log format: logfmt or json (default $CHATROOM_LOG_FORMAT or logfmt)")
+	verifyAudit := flag.String("verify-audit", "", "Check the hash chain of this audit log (e.g. audit.log) and exit")
+	plugins := flag.String("plugins", "greeter,uptime", "Comma-separated list of built-in plugins to enable ("+strings.Join(plugin.BuiltinNames(), ", ")+")")
 	flag.Parse()
 
+	if *logLevel != "" {
+		level, err := logger.ParseLevel(*logLevel)
+		if err != nil {
+			log.Fatal("Invalid -log-level", "err", err)
+		}
+		logger.SetLevel(level)
+	}
+	if *logFormat != "" {
+		format, err := logger.ParseFormat(*logFormat)
+		if err != nil {
+			log.Fatal("Invalid -log-format", "err", err)
+		}
+		logger.SetFormat(format)
+	}
+
+	if *verifyAudit != "" {
+		os.Exit(verifyAuditLog(*verifyAudit))
+	}
+
 	if *newKey && *oldKey {
-		log.Fatal("You cannot use both -n and -o at the same time.")
+		log.Fatal("You cannot use both -n and -o at the same time")
 	}
 
 	const saveFile1 = "room.key"
 	const saveFile2 = "server_state.json"
+	const saveFile3 = "history.json"
 	if *newKey {
-		fmt.Println("[INFO] Starting server with a NEW room key...")
+		log.Info("Starting server with a NEW room key")
 		if err := os.Remove(saveFile1); err != nil && !os.IsNotExist(err) {
-			log.Fatalf("Failed to remove old savestate: %v", err)
+			log.Fatal("Failed to remove old savestate", "err", err)
 		}
 		if err := os.Remove(saveFile2); err != nil && !os.IsNotExist(err) {
-			log.Fatalf("Failed to remove old savestate: %v", err)
+			log.Fatal("Failed to remove old savestate", "err", err)
+		}
+		if err := os.Remove(saveFile3); err != nil && !os.IsNotExist(err) {
+			log.Fatal("Failed to remove old message history", "err", err)
 		}
 	} else if *oldKey {
-		fmt.Println("[INFO] Starting server with EXISTING room key...")
+		log.Info("Starting server with EXISTING room key")
 	} else {
-		fmt.Println("[INFO] Starting server (default behavior — use existing room key if any)")
+		log.Info("Starting server (default behavior — use existing room key if any)")
 	}
 
 	srv := server.New(":9000")
+	if *admins != "" {
+		srv.SetAdmins(strings.Split(*admins, ","))
+	}
+	for _, name := range strings.Split(*plugins, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		newPlugin, ok := plugin.Builtins[name]
+		if !ok {
+			log.Fatal("Unknown plugin", "plugin", name, "available", strings.Join(plugin.BuiltinNames(), ", "))
+		}
+		srv.RegisterPlugin(newPlugin())
+	}
+	if *webhooks != "" {
+		cfg, err := webhook.LoadConfig(*webhooks)
+		if err != nil {
+			log.Fatal("Failed to load webhooks", "err", err)
+		}
+		if err := srv.EnableWebhooks(cfg); err != nil {
+			log.Fatal("Failed to enable webhooks", "err", err)
+		}
+	}
+	if *wsAddr != "" {
+		srv.EnableWebSocket(*wsAddr)
+	}
+	if *ircAddr != "" {
+		log.Warn("IRC bridge enabled: messages to and from IRC users are decrypted on the server", "addr", *ircAddr)
+		srv.EnableIRC(*ircAddr)
+	}
+	if *adminAPI != "" {
+		if err := srv.EnableAdminAPI(*adminAPI, os.Getenv("CHATROOM_ADMIN_TOKEN")); err != nil {
+			log.Fatal("Failed to enable admin API", "err", err)
+		}
+	}
+	if *metricsAddr != "" {
+		srv.EnableMetrics(*metricsAddr)
+	}
+	if *incoming != "" {
+		cfg, err := server.LoadIncomingConfig(*incoming)
+		if err != nil {
+			log.Fatal("Failed to load incoming webhooks", "err", err)
+		}
+		srv.EnableIncomingWebhooks(cfg)
+	}
 
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("[PANIC] Recovered, saving state before exit...")
+			log.Error("Panic, saving state before exit", "panic", fmt.Sprint(r))
 			srv.SaveState()
 			panic(r)
 		}
@@ -52,21 +137,33 @@ func main() {
 
 	go func() {
 		if err := srv.Start(); err != nil {
-			log.Fatalf("Server error: %v", err)
+			log.Fatal("Server error", "err", err)
 		}
 	}()
 
-	log.Println("Server started on :9000")
+	log.Info("Server started", "addr", ":9000")
 
 	<-stop
-	log.Println("[INFO] Interrupt signal received. Shutting down...")
+	log.Info("Interrupt signal received, shutting down")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("[ERROR] Server forced to shutdown: %v", err)
+		log.Fatal("Server forced to shutdown", "err", err)
 	}
 
-	log.Println("[INFO] Server shutdown complete.")
+	log.Info("Server shutdown complete")
+}
+
+// verifyAuditLog checks an audit log and returns the exit status: 0 if the
+// chain is intact, 1 if not.
+func verifyAuditLog(path string) int {
+	count, last, err := audit.VerifyFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v (%d entries verified before that)\n", path, err, count)
+		return 1
+	}
+	fmt.Printf("%s: OK, %d entries, last hash %s\n", path, count, last)
+	return 0
 }
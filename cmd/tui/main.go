@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"chatroom/internal/client"
+	"chatroom/internal/client/tui"
+	"chatroom/pkg/logger"
+)
+
+var log = logger.New("tui")
+
+func main() {
+	server := flag.String("server", "localhost:9000", "chat server address")
+	user := flag.String("user", "", "username (asked for when empty)")
+	logFile := flag.String("log", "", "write client logs to this file instead of discarding them")
+	flag.Parse()
+
+	username := strings.TrimSpace(*user)
+	if username == "" {
+		fmt.Print("Username: ")
+		name, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			log.Fatal("Failed to read username", "err", err)
+		}
+		username = strings.TrimSpace(name)
+	}
+
+	// Client logs go to stderr; keep them off the screen we draw on.
+	logPath := *logFile
+	if logPath == "" {
+		logPath = os.DevNull
+	}
+	logOut, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal("Failed to open log file", "err", err)
+	}
+	defer logOut.Close()
+	logger.SetOutput(logOut)
+
+	c := client.New()
+	if err := c.Login(username); err != nil {
+		fmt.Fprintln(os.Stderr, "Login failed:", err)
+		os.Exit(1)
+	}
+	// The app takes the client's events from here on, so none of them are
+	// printed before it draws the screen.
+	app := tui.NewApp(c, os.Stdin, os.Stdout, fmt.Sprintf(" Talkie Messenger - %s @ %s", c.GetUsername(), *server))
+	if err := c.Connect(*server); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to connect:", err)
+		os.Exit(1)
+	}
+
+	if err := app.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	c.Disconnect()
+}
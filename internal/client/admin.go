@@ -0,0 +1,38 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+// Kick asks the server to disconnect username. Only admins may do this.
+func (c *Client) Kick(username, reason string) error {
+	if !c.IsAdmin() {
+		return fmt.Errorf("only admins can kick users")
+	}
+	msg := &shared.Message{
+		Type:      shared.TypeKick,
+		From:      c.username,
+		To:        strings.TrimSpace(username),
+		Content:   strings.TrimSpace(reason),
+		Timestamp: time.Now(),
+	}
+	return c.connection().Send(msg)
+}
+
+// Announce sends a notice from an admin to everyone in the room.
+func (c *Client) Announce(text string) error {
+	if !c.IsAdmin() {
+		return fmt.Errorf("only admins can make announcements")
+	}
+	msg := &shared.Message{
+		Type:      shared.TypeAnnounce,
+		From:      c.username,
+		Content:   strings.TrimSpace(text),
+		Timestamp: time.Now(),
+	}
+	return c.connection().Send(msg)
+}
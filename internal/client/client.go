@@ -3,8 +3,11 @@ package client
 import (
 	"chatroom/internal/client/networking"
 	"chatroom/internal/shared"
+	"chatroom/internal/transport"
+	"chatroom/pkg/logger"
+	"context"
 	"crypto/rsa"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -14,43 +17,93 @@ import (
 	"time"
 )
 
+var log = logger.New("client")
+
+// ErrAuthFailed is returned by Connect when the server rejects the login.
+var ErrAuthFailed = errors.New("authentication failed")
+
 type Client struct {
-	conn                *networking.Connection
-	username            string
-	activeUsers         []string
-	onMessage           func(msg string)
-	privateKey          *rsa.PrivateKey
-	publicKey           *rsa.PublicKey
-	roomKey             []byte
-	PublicKeyCache      *PublicKeyCache
-	PendingPrivateMsg   map[string][]string
-	PendingPrivateFiles []shared.PendingFileTransfer
-	mu                  sync.Mutex
-	autoReconnect       bool
+	transport      transport.Transport
+	conn           *networking.Connection
+	address        string
+	username       string
+	activeUsers    []string
+	privateKey     *rsa.PrivateKey
+	publicKey      *rsa.PublicKey
+	roomKey        []byte
+	PublicKeyCache *PublicKeyCache
+	mu             sync.Mutex
+	reconnect      reconnector
+	outbox         outbox // messages and files waiting for the connection
+	session        string // token for resuming the login after a reconnect
+	lastSeen       string // ID of the last chat message received
+	presence       map[string]shared.Presence
+	lastTypingSent map[string]time.Time
+	readReceipts   bool
+	readSent       map[string]bool
+	isAdmin        bool
+	messages       map[string]*ChatMessage // chat messages by ID
+	mentions       []*ChatMessage          // recent messages mentioning us, oldest first
+	onEvent        func(ev Event)
+	roomKeyReady   chan struct{} // closed once the first room key arrived
+	roomKeyOnce    sync.Once
+	files          []FileOffer // files offered to us this session, oldest first
+	commands       *Commands
 }
 
 func New() *Client {
 	return &Client{
-		conn:                networking.NewConnection(),
-		PublicKeyCache:      NewPublicKeyCache(),
-		PendingPrivateMsg:   make(map[string][]string),
-		PendingPrivateFiles: make([]shared.PendingFileTransfer, 0),
+		transport:      transport.TCP,
+		conn:           networking.NewConnection(transport.TCP),
+		PublicKeyCache: NewPublicKeyCache(),
+		presence:       make(map[string]shared.Presence),
+		lastTypingSent: make(map[string]time.Time),
+		readReceipts:   true,
+		readSent:       make(map[string]bool),
+		messages:       make(map[string]*ChatMessage),
+		roomKeyReady:   make(chan struct{}),
+		commands:       NewCommands(),
+		reconnect:      reconnector{policy: DefaultReconnectPolicy},
 	}
 }
 
-func (c *Client) SetMessageHandler(handler func(msg string)) {
-	c.onMessage = handler
+// SetTransport replaces TCP with another transport, such as
+// transport.NewMemory in tests. Call it before Connect.
+func (c *Client) SetTransport(t transport.Transport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transport = t
+	c.conn = networking.NewConnection(t)
+}
+
+// connection returns the current connection, which a reconnect replaces.
+func (c *Client) connection() *networking.Connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
 }
 
 func (c *Client) GetUsername() string {
-	c.username = strings.TrimSpace(c.username)
-	return c.username
+	return strings.TrimSpace(c.username)
 }
 
 func (c *Client) Connect(address string) error {
-	if err := c.conn.Connect(address); err != nil {
+	return c.ConnectContext(context.Background(), address)
+}
+
+// ConnectContext connects and logs in like Connect, giving up when ctx is
+// done before the server accepted the login.
+func (c *Client) ConnectContext(ctx context.Context, address string) (err error) {
+	conn := c.connection()
+	if err := conn.ConnectContext(ctx, address); err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	c.address = address
 
 	// Send authentication message
 	authMsg := &shared.Message{
@@ -58,21 +111,31 @@ func (c *Client) Connect(address string) error {
 		From:    c.username,
 		Content: "auth",
 	}
-	if err := c.conn.Send(authMsg); err != nil {
+	if err := conn.Send(authMsg); err != nil {
 		return fmt.Errorf("auth failed: %v", err)
 	}
 
-	authResp := <-c.conn.Incoming()
+	var authResp *shared.Message
+	select {
+	case msg, ok := <-conn.Incoming():
+		if !ok {
+			return fmt.Errorf("connection closed while waiting auth response")
+		}
+		authResp = msg
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	if authResp.Type != shared.TypeAuthResponse {
 		return fmt.Errorf("unexpected response type: %s", authResp.Type)
 	}
 	if !authResp.Success {
-		return fmt.Errorf("authentication failed: %s", authResp.Error)
+		return fmt.Errorf("%w: %s", ErrAuthFailed, authResp.Error)
 	}
+	c.applyAuthResponse(authResp)
 
 	priv, pub, err := shared.GenerateRSAKeyPair(2048)
 	if err != nil {
-		fmt.Printf("failed to generate RSA keys: %v", err)
+		log.Error("Failed to generate RSA keys", "err", err)
 		return err
 	}
 	c.privateKey = priv
@@ -84,27 +147,63 @@ func (c *Client) Connect(address string) error {
 		From:    c.username,
 		Content: string(pemPub),
 	}
-	c.conn.Send(msg)
+	conn.Send(msg)
+
+	c.loadOutbox()
 
 	// Start message listener
-	fmt.Print("Public key of client: \n", string(pemPub), "\n")
-	c.autoReconnect = true
+	c.reconnect.mu.Lock()
+	c.reconnect.auto = true
+	c.reconnect.state = StateConnected
+	c.reconnect.mu.Unlock()
 	go c.handleMessages()
 
 	return nil
 }
 
+// applyAuthResponse adopts the username as normalized by the server, the
+// admin flag granted to it and the session token.
+func (c *Client) applyAuthResponse(resp *shared.Message) {
+	// The username is read without the lock; after the first login a
+	// reconnect gets the same one back and leaves it alone.
+	if resp.To != "" && resp.To != c.username {
+		c.username = resp.To
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isAdmin = resp.Admin
+	if resp.Session != "" {
+		c.session = resp.Session
+	}
+}
+
+func (c *Client) IsAdmin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isAdmin
+}
+
 func (c *Client) Login(username string) error {
 	c.username = username
 	return nil
 }
 
 func (c *Client) SendMessage(content string) error {
-	c.displayMessage(fmt.Sprintf("(Global) (You) (%s): %s",
-		time.Now().Format("15:04:05"), content))
-	fmt.Print("Room key: ", c.roomKey)
+	return c.sendPublic(content, "")
+}
+
+func (c *Client) sendPublic(content, parentID string) error {
+	return c.submit(&outboxItem{
+		ID:       shared.GenerateID(),
+		Kind:     outboxPublic,
+		ParentID: parentID,
+		Text:     content,
+		Queued:   time.Now(),
+	})
+}
 
-	_, encDataB64, err := shared.EncryptWithRoomKey(content, c.roomKey)
+func (c *Client) transmitPublic(it *outboxItem) error {
+	_, encDataB64, err := shared.EncryptWithRoomKey(it.Text, c.getRoomKey())
 	if encDataB64 == "" {
 		return fmt.Errorf("encryption failed: empty ciphertext")
 	}
@@ -113,12 +212,14 @@ func (c *Client) SendMessage(content string) error {
 	}
 
 	msg := &shared.Message{
+		ID:            it.ID,
+		ParentID:      it.ParentID,
 		Type:          shared.TypePublic,
 		From:          c.username,
 		EncryptedData: encDataB64,
 		Timestamp:     time.Now(),
 	}
-	return c.conn.Send(msg)
+	return c.send(msg)
 }
 
 func (c *Client) SendPrivateMessage(target, content string) error {
@@ -128,61 +229,71 @@ func (c *Client) SendPrivateMessage(target, content string) error {
 		return fmt.Errorf("cannot send private message to yourself")
 	}
 
-	targetPubKey, exists := c.PublicKeyCache.Get(target)
-	if !exists {
-
-		c.mu.Lock()
-		c.PendingPrivateMsg[target] = append(c.PendingPrivateMsg[target], content)
-		c.mu.Unlock()
-
-		req := &shared.Message{
-			Type: shared.TypePublicKeyRequest,
-			From: c.username,
-			To:   target,
-		}
-		return c.conn.Send(req)
-	}
+	return c.submit(&outboxItem{
+		ID:     shared.GenerateID(),
+		Kind:   outboxPrivate,
+		To:     target,
+		Text:   content,
+		Queued: time.Now(),
+	})
+}
 
-	encKeyB64, encDataB64, err := shared.Encrypt(content, targetPubKey)
+func (c *Client) transmitPrivate(it *outboxItem, targetPubKey *rsa.PublicKey) error {
+	encKeyB64, encDataB64, err := shared.Encrypt(it.Text, targetPubKey)
 	if err != nil {
 		return err
 	}
 
 	msg := &shared.Message{
+		ID:           it.ID,
 		Type:         shared.TypePrivate,
 		From:         c.username,
-		To:           target,
+		To:           it.To,
 		EncryptedKey: encKeyB64,
 		Content:      encDataB64,
 		Timestamp:    time.Now(),
 	}
-	c.displayMessage(fmt.Sprintf("(Private to %s) (You) (%s): %s",
-		target, time.Now().Format("15:04:05"), content))
-
-	return c.conn.Send(msg)
+	return c.send(msg)
 }
 
+// Disconnect logs out and closes the connection. It also stops reconnecting,
+// so it ends the client even while offline.
 func (c *Client) Disconnect() error {
+	online := c.ConnectionState() == StateConnected
+	c.setAutoReconnect(false)
+	if c.stopReconnect() {
+		c.setConnState(ConnectionEvent{State: StateOffline})
+	}
+
+	conn := c.connection()
 	msg := &shared.Message{
 		Type:      shared.TypeLeave,
 		From:      c.username,
 		Timestamp: time.Now(),
 	}
-	if err := c.conn.Send(msg); err != nil {
+	sendErr := conn.Send(msg)
+	if err := conn.Close(); err != nil {
 		return err
 	}
-	c.autoReconnect = false
-	return c.conn.Close()
+	if !online {
+		// Nobody to tell; the server notices the dropped connection by
+		// itself.
+		return nil
+	}
+	return sendErr
 }
 
 func (c *Client) GetActiveUsers() []string {
-	return c.activeUsers
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.activeUsers...)
 }
 
 func (c *Client) handleMessages() {
-	for msg := range c.conn.Incoming() {
+	var firstDelay time.Duration // set when the server announced a shutdown
+	for msg := range c.connection().Incoming() {
 
-		if msg.From == c.username {
+		if msg.From == c.username && msg.Type != shared.TypeJoin {
 			continue
 		}
 
@@ -190,53 +301,80 @@ func (c *Client) handleMessages() {
 		case shared.TypeRoomKey:
 			c.handleRoomKey(msg)
 		case shared.TypePublic:
+			if !c.markSeen(msg.ID) {
+				continue
+			}
 			c.formatAndDisplayMessage(msg)
 		case shared.TypePrivate:
+			if !c.markSeen(msg.ID) {
+				continue
+			}
 			msg := c.DecryptPrivateMessage(msg)
 			c.formatAndDisplayPrivateMessage(msg)
 		case shared.TypeUserList:
+			c.mu.Lock()
 			c.activeUsers = msg.Users
-			c.notifyUserListUpdate()
-		case shared.TypeJoin, shared.TypeLeave:
-			c.displaySystemMessage(msg)
+			c.mu.Unlock()
+			c.updatePresence(msg.Presence)
+			c.emit(UserListEvent{Users: append([]string(nil), msg.Users...)})
+			c.flushOutbox()
+		case shared.TypeEdit, shared.TypeDelete:
+			c.handleMessageUpdate(msg)
+		case shared.TypeReactions:
+			c.handleReactions(msg)
+		case shared.TypeThread:
+			c.handleThread(msg)
+		case shared.TypeThreadUpdate:
+			c.applyUpdate(msg.RefID, func(m *ChatMessage) {
+				m.ReplyCount = msg.ReplyCount
+			})
+		case shared.TypeDeliveredReceipt, shared.TypeReadReceipt:
+			c.handleReceipt(msg)
+		case shared.TypePresence:
+			c.updatePresence(msg.Presence)
+		case shared.TypeTyping:
+			c.emit(TypingEvent{From: msg.From, To: msg.To})
+		case shared.TypeJoin:
+			c.emit(UserJoinedEvent{Username: msg.From, Time: msg.Timestamp})
+		case shared.TypeLeave:
+			c.emit(UserLeftEvent{Username: msg.From, Time: msg.Timestamp})
 		case shared.TypeError:
-			c.displayErrorMessage(msg)
+			c.emit(ErrorEvent{Text: msg.Content})
 		case shared.TypePublicKeyResponse:
 			c.handlePublicKeyResponse(msg)
 		case shared.TypeFileDownload:
 			c.saveReceivedFile(msg)
 		case shared.TypeInfo:
-			c.displaySystemMessage(msg)
+			c.emit(InfoEvent{Text: msg.Content, Time: msg.Timestamp})
 		case shared.TypeFileAvailable:
-			c.displaySystemMessage(msg)
+			c.addFileOffer(msg.From, msg.Filename, false)
+			c.emit(FileAvailableEvent{From: msg.From, Filename: msg.Filename, Time: msg.Timestamp})
 		case shared.TypeFileTransfer:
 			c.SendFile(msg.Filename)
 		case shared.TypePrivateFileTransferAvailable:
-			c.displaySystemMessage(msg)
+			c.addFileOffer(msg.From, msg.Filename, true)
+			c.emit(FileAvailableEvent{From: msg.From, Filename: msg.Filename, Private: true, Time: msg.Timestamp})
 		case shared.TypePrivateFileTransfer:
 			c.SendPrivateFile(msg.Filename, msg.To)
 		case shared.TypePrivateFileDownload:
 			c.SaveReceivedPrivateFile(msg)
+		case shared.TypeKick:
+			c.setAutoReconnect(false)
+			c.mu.Lock()
+			c.session = ""
+			c.mu.Unlock()
+			c.emit(KickedEvent{By: msg.From, Reason: msg.Content})
+		case shared.TypeShutdown:
+			firstDelay = time.Duration(msg.RetryAfter) * time.Second
+			c.emit(ServerShutdownEvent{Text: msg.Content, RetryAfter: firstDelay})
 
 		default:
-			fmt.Println("Unknown message type:", msg.Type)
+			log.Warn("Unknown message type", "type", msg.Type)
 		}
 	}
-	if c.autoReconnect {
-		c.displayMessage("Disconnected from server. Attempting reconnect...")
-		go func() {
-			for {
-				if err := c.ReconnectAndHandshake("127.0.0.1:9000"); err != nil {
-					fmt.Println("Reconnect failed:", err)
-					time.Sleep(5 * time.Second)
-					continue
-				} else {
-					fmt.Println("Reconnect+handshake success")
-					c.displayMessage("Reconnected to server.")
-					return
-				}
-			}
-		}()
+	// After a shutdown notice, give the server time to come back.
+	if !c.startReconnect(firstDelay) {
+		c.setConnState(ConnectionEvent{State: StateOffline})
 	}
 }
 
@@ -247,16 +385,18 @@ func (c *Client) formatAndDisplayMessage(msg *shared.Message) {
 	if strings.TrimSpace(msg.From) == strings.TrimSpace(c.username) {
 		return
 	}
-	msgContent, err := shared.DecryptWithRoomKey(msg.EncryptedData, c.roomKey)
+	msgContent, err := shared.DecryptWithRoomKey(msg.EncryptedData, c.getRoomKey())
 	if err != nil {
-		fmt.Println("Failed to decrypt message:", err)
+		log.Warn("Failed to decrypt message", "id", msg.ID, "from", msg.From, "err", err)
 		return
 	}
-	formatted := fmt.Sprintf("(Global) (%s) %s: %s",
-		msg.Timestamp.Format("15:04:05"),
-		msg.From,
-		msgContent)
-	c.displayMessage(formatted)
+	c.displayChat(&ChatMessage{
+		ID:        msg.ID,
+		ParentID:  msg.ParentID,
+		From:      msg.From,
+		Content:   string(msgContent),
+		Timestamp: msg.Timestamp,
+	})
 }
 
 func (c *Client) formatAndDisplayPrivateMessage(msg *shared.Message) {
@@ -267,58 +407,51 @@ func (c *Client) formatAndDisplayPrivateMessage(msg *shared.Message) {
 	if strings.TrimSpace(msg.From) == strings.TrimSpace(c.username) {
 		return
 	}
-	formatted := fmt.Sprintf("(Private) (%s) %s: %s",
-		msg.Timestamp.Format("15:04:05"),
-		msg.From,
-		msg.Content)
-	c.displayMessage(formatted)
+	c.sendDeliveredReceipt(msg)
+	c.displayChat(&ChatMessage{
+		ID:        msg.ID,
+		From:      msg.From,
+		To:        msg.To,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp,
+		Private:   true,
+	})
 }
 
-func (c *Client) displaySystemMessage(msg *shared.Message) {
-	formatted := fmt.Sprintf("(System) (%s) %s",
-		msg.Timestamp.Format("15:04:05"),
-		msg.Content)
-	c.displayMessage(formatted)
-}
+func (c *Client) handleRoomKey(msg *shared.Message) {
+	key := shared.DecryptRoomKey(msg.EncryptedKey, c.privateKey)
+	if key == nil {
+		log.Error("Failed to obtain room key")
+		return
+	}
+	c.mu.Lock()
+	c.roomKey = key
+	c.mu.Unlock()
+	log.Info("Received room key")
+	c.roomKeyOnce.Do(func() { close(c.roomKeyReady) })
 
-func (c *Client) displayErrorMessage(msg *shared.Message) {
-	formatted := fmt.Sprintf("(Error) %s", msg.Content)
-	c.displayMessage(formatted)
+	// Here you would typically store the room key for later use
 }
 
-func (c *Client) notifyUserListUpdate() {
-	formatted := fmt.Sprintf("Active users: %s",
-		strings.Join(c.activeUsers, ", "))
-	c.displayMessage(formatted)
+func (c *Client) getRoomKey() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.roomKey
 }
 
-func (c *Client) displayMessage(msg string) {
-	if c.onMessage != nil {
-		c.onMessage(msg)
-	} else {
-		fmt.Println(msg)
-	}
+// RoomKeyReady is closed once the room key has been received, after which
+// public messages and files can be sent.
+func (c *Client) RoomKeyReady() <-chan struct{} {
+	return c.roomKeyReady
 }
 
-func (c *Client) handleRoomKey(msg *shared.Message) {
-	c.roomKey = shared.DecryptRoomKey(msg.EncryptedKey, c.privateKey)
-	fmt.Print("User ", c.username, " received room key.\n")
-	fmt.Printf("Decrypt using roomKey: %s", base64.StdEncoding.EncodeToString(c.roomKey))
-	if c.roomKey == nil {
-		fmt.Println("Failed to obtain room key")
-		return
-	}
-	fmt.Print("Decrypted room key: ", c.roomKey, "\n")
-
-	// Here you would typically store the room key for later use
-}
 func (c *Client) DecryptPrivateMessage(msg *shared.Message) *shared.Message {
 	if strings.TrimSpace(msg.From) == strings.TrimSpace(c.username) {
 		return nil
 	}
 	plainBytes, err := shared.Decrypt(msg.EncryptedKey, msg.Content, c.privateKey)
 	if err != nil {
-		fmt.Println("Failed to decrypt private message:", err)
+		log.Warn("Failed to decrypt private message", "id", msg.ID, "from", msg.From, "err", err)
 		return nil
 	}
 	msg.Content = string(plainBytes)
@@ -327,67 +460,83 @@ func (c *Client) DecryptPrivateMessage(msg *shared.Message) *shared.Message {
 func (c *Client) handlePublicKeyResponse(msg *shared.Message) {
 	plainPubPEM, err := shared.Decrypt(msg.EncryptedKey, msg.Content, c.privateKey)
 	if err != nil {
-		fmt.Println("Failed to decode received public key:", err)
+		log.Warn("Failed to decrypt received public key", "from", msg.From, "err", err)
 		return
 	}
 
 	pub, err := shared.ParsePublicKeyFromPEM([]byte(plainPubPEM))
 	if err != nil {
-		fmt.Println("Failed to parse public key:", err)
+		log.Warn("Failed to parse public key", "from", msg.From, "err", err)
 		return
 	}
 
 	c.PublicKeyCache.Store(msg.From, pub)
-	fmt.Printf("Stored public key for %s\n", msg.From)
-
-	c.mu.Lock()
-	if pending, ok := c.PendingPrivateMsg[msg.From]; ok {
-		for _, content := range pending {
-			_ = c.SendPrivateMessage(msg.From, content)
-		}
-		delete(c.PendingPrivateMsg, msg.From)
-	}
-
-	// Process pending file transfers
-	var remainingFiles []shared.PendingFileTransfer
-	for _, pendingFile := range c.PendingPrivateFiles {
-		if pendingFile.Target == msg.From {
-			_ = c.SendPrivateFile(pendingFile.Filename, pendingFile.Target)
-		} else {
-			remainingFiles = append(remainingFiles, pendingFile)
-		}
-	}
-	c.PendingPrivateFiles = remainingFiles
-	c.mu.Unlock()
+	log.Debug("Stored public key", "user", msg.From)
 }
 
+// ReconnectAndHandshake connects to address again and resumes the session.
+// The client does this by itself when the connection drops; see
+// ReconnectPolicy.
 func (c *Client) ReconnectAndHandshake(address string) error {
-	if c.conn != nil {
-		_ = c.conn.Close()
+	if err := c.handshake(context.Background(), address); err != nil {
+		return err
 	}
+	c.setConnState(ConnectionEvent{State: StateConnected, Connected: true})
+	go c.handleMessages()
+	return nil
+}
 
-	c.conn = networking.NewConnection()
+// handshake replaces the connection with a new one to address and logs in
+// on it, giving up when ctx is done. The caller starts handleMessages.
+func (c *Client) handshake(ctx context.Context, address string) (err error) {
+	// Senders see the new connection as inactive until it is up.
+	conn := networking.NewConnection(c.transport)
+	c.mu.Lock()
+	old := c.conn
+	c.conn = conn
+	c.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
 
-	if err := c.conn.Connect(address); err != nil {
+	if err := conn.ConnectContext(ctx, address); err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
 
+	// The session token lets the server take us back even if it has not
+	// noticed yet that the old connection is gone.
+	c.mu.Lock()
+	session := c.session
+	c.mu.Unlock()
 	authMsg := &shared.Message{
 		Type:    shared.TypeAuth,
 		From:    c.username,
 		Content: "auth",
+		Session: session,
 	}
-	if err := c.conn.Send(authMsg); err != nil {
+	if err := conn.Send(authMsg); err != nil {
 		return fmt.Errorf("auth send failed: %w", err)
 	}
 
-	authResp, ok := <-c.conn.Incoming()
-	if !ok {
-		return fmt.Errorf("connection closed while waiting auth response")
+	var authResp *shared.Message
+	select {
+	case msg, ok := <-conn.Incoming():
+		if !ok {
+			return fmt.Errorf("connection closed while waiting auth response")
+		}
+		authResp = msg
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 	if authResp.Type != shared.TypeAuthResponse || !authResp.Success {
-		return fmt.Errorf("authentication failed: %s", authResp.Error)
+		return fmt.Errorf("%w: %s", ErrAuthFailed, authResp.Error)
 	}
+	c.applyAuthResponse(authResp)
 
 	pemPub, _ := shared.PublicKeyToPEM(c.publicKey)
 	pubMsg := &shared.Message{
@@ -395,29 +544,43 @@ func (c *Client) ReconnectAndHandshake(address string) error {
 		From:    c.username,
 		Content: string(pemPub),
 	}
-	_ = c.conn.Send(pubMsg)
+	_ = conn.Send(pubMsg)
 
-	go c.handleMessages()
-
-	_ = c.conn.Send(&shared.Message{Type: shared.TypeReconnect, From: c.username})
+	// RefID asks the server to replay what we missed after that message.
+	c.mu.Lock()
+	lastSeen := c.lastSeen
+	c.mu.Unlock()
+	_ = conn.Send(&shared.Message{Type: shared.TypeReconnect, From: c.username, RefID: lastSeen})
 
 	return nil
 }
 
 func (c *Client) SendFile(filePath string) error {
-	file, err := os.Open(filePath)
+	if _, err := os.Stat(filePath); err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	return c.submit(&outboxItem{
+		ID:     shared.GenerateID(),
+		Kind:   outboxFile,
+		Path:   filePath,
+		Queued: time.Now(),
+	})
+}
+
+func (c *Client) transmitFile(it *outboxItem) error {
+	file, err := os.Open(it.Path)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
-	filename := filepath.Base(filePath)
+	filename := filepath.Base(it.Path)
 	fileBytes, err := io.ReadAll(file)
 	if err != nil {
 		return fmt.Errorf("failed to read file: %v", err)
 	}
 
-	_, encoded, _ := shared.EncryptWithRoomKey(string(fileBytes), c.roomKey)
+	_, encoded, _ := shared.EncryptWithRoomKey(string(fileBytes), c.getRoomKey())
 
 	msg := &shared.Message{
 		Type:      shared.TypeFileTransfer,
@@ -426,7 +589,7 @@ func (c *Client) SendFile(filePath string) error {
 		Content:   encoded,
 		Timestamp: time.Now(),
 	}
-	return c.conn.Send(msg)
+	return c.send(msg)
 }
 
 func (c *Client) RequestFile(filename string) error {
@@ -435,58 +598,55 @@ func (c *Client) RequestFile(filename string) error {
 		From:     c.username,
 		Filename: filename,
 	}
-	return c.conn.Send(msg)
+	return c.connection().Send(msg)
 }
 
 func (c *Client) saveReceivedFile(msg *shared.Message) {
-	data, err := shared.DecryptWithRoomKey(msg.Content, c.roomKey)
+	data, err := shared.DecryptWithRoomKey(msg.Content, c.getRoomKey())
 	if err != nil {
-		fmt.Println("Failed to decode file:", err)
+		log.Warn("Failed to decrypt file", "file", msg.Filename, "err", err)
 		return
 	}
 
 	downloadDir := "downloads"
 	if err := os.MkdirAll(downloadDir, 0755); err != nil {
-		fmt.Println("Failed to create Downloads directory:", err)
+		log.Error("Failed to create downloads directory", "err", err)
 		return
 	}
 
 	savePath := filepath.Join(downloadDir, msg.Filename)
 	if err := os.WriteFile(savePath, data, 0644); err != nil {
-		fmt.Println("Failed to save file:", err)
+		log.Error("Failed to save file", "file", savePath, "err", err)
 		return
 	}
 
-	fmt.Printf("[File] Saved file to %s\n", savePath)
+	log.Info("Saved file", "file", savePath)
+	c.emit(FileReceivedEvent{Filename: msg.Filename, Path: savePath})
 }
 
 func (c *Client) SendPrivateFile(filename string, target string) error {
-	file, err := os.Open(filename)
-	if err != nil {
+	if _, err := os.Stat(filename); err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
-	defer file.Close()
 
-	targetPubKey, exists := c.PublicKeyCache.Get(target)
-	if !exists {
-		// Store the file details in pending queue
-		c.mu.Lock()
-		c.PendingPrivateFiles = append(c.PendingPrivateFiles, shared.PendingFileTransfer{
-			Filename: filename,
-			Target:   target,
-		})
-		c.mu.Unlock()
-
-		// Request the public key
-		req := &shared.Message{
-			Type: shared.TypePublicKeyRequest,
-			From: c.username,
-			To:   target,
-		}
-		return c.conn.Send(req)
+	return c.submit(&outboxItem{
+		ID:     shared.GenerateID(),
+		Kind:   outboxPrivateFile,
+		To:     target,
+		Path:   filename,
+		Queued: time.Now(),
+	})
+}
+
+func (c *Client) transmitPrivateFile(it *outboxItem, targetPubKey *rsa.PublicKey) error {
+	file, err := os.Open(it.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
 	}
+	defer file.Close()
 
-	fileName := filepath.Base(filename)
+	target := it.To
+	fileName := filepath.Base(it.Path)
 	fileBytes, err := io.ReadAll(file)
 	if err != nil {
 		return fmt.Errorf("failed to read file: %v", err)
@@ -507,8 +667,8 @@ func (c *Client) SendPrivateFile(filename string, target string) error {
 		Content:      encDataB64,
 		Timestamp:    time.Now(),
 	}
-	if err := c.conn.Send(msg); err != nil {
-		return fmt.Errorf("failed to send file message: %v", err)
+	if err := c.send(msg); err != nil {
+		return fmt.Errorf("failed to send file message: %w", err)
 	}
 
 	// Then send the file availability message that will trigger the download button
@@ -520,11 +680,11 @@ func (c *Client) SendPrivateFile(filename string, target string) error {
 		Filename:  fileName,
 		Timestamp: time.Now(),
 	}
-	if err := c.conn.Send(availableMsg); err != nil {
-		fmt.Printf("[Warning] Failed to broadcast file availability: %v\n", err)
+	if err := c.connection().Send(availableMsg); err != nil {
+		log.Warn("Failed to announce file", "err", err)
 	}
 
-	fmt.Printf("[File] Sent file %s to %s\n", fileName, target)
+	log.Info("Sent file", "file", fileName, "to", target)
 
 	return nil
 }
@@ -536,33 +696,36 @@ func (c *Client) RequestPrivateFile(filename, target string) error {
 		To:       target,
 		Filename: filename,
 	}
-	return c.conn.Send(msg)
+	return c.connection().Send(msg)
 }
 
 func (c *Client) SaveReceivedPrivateFile(msg *shared.Message) error {
 	data, err := shared.Decrypt(msg.EncryptedKey, msg.Content, c.privateKey)
 	if err != nil {
-		fmt.Println("Failed to decode private file:", err)
+		log.Warn("Failed to decrypt private file", "file", msg.Filename, "err", err)
 		return err
 	}
 
 	downloadDir := "downloads"
 	if err := os.MkdirAll(downloadDir, 0755); err != nil {
-		fmt.Println("Failed to create Downloads directory:", err)
+		log.Error("Failed to create downloads directory", "err", err)
 		return err
 	}
 
 	savePath := filepath.Join(downloadDir, msg.Filename)
 	if err := os.WriteFile(savePath, data, 0644); err != nil {
-		fmt.Println("Failed to save file:", err)
+		log.Error("Failed to save file", "file", savePath, "err", err)
 		return err
 	}
 
-	fmt.Printf("[File] Saved private file to %s\n", savePath)
+	log.Info("Saved private file", "file", savePath)
+	c.emit(FileReceivedEvent{Filename: msg.Filename, Path: savePath, Private: true})
 	return nil
 }
 
 func (c *Client) UserExists(target string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for _, u := range c.activeUsers {
 		if u == target {
 			return true
@@ -0,0 +1,377 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"chatroom/internal/shared"
+)
+
+// CommandUI is what a command needs from the front end running it.
+type CommandUI interface {
+	// Print shows text, which may span several lines, in the chat view.
+	Print(text string)
+	// Clear empties the chat view.
+	Clear()
+}
+
+// Command is a slash command such as "/msg bob hi".
+type Command struct {
+	Name    string
+	Aliases []string
+	// Args names the arguments for the usage line. The last one takes the
+	// rest of the input, spaces included; the others may be quoted.
+	Args []string
+	// MinArgs is how many arguments are required. The rest are optional.
+	MinArgs int
+	Help    string
+	Admin   bool // only shown to and run for admins
+	Run     func(c *Client, ui CommandUI, args []string) error
+}
+
+// Usage returns the usage line, e.g. "/kick <user> [reason]".
+func (cmd *Command) Usage() string {
+	parts := []string{"/" + cmd.Name}
+	for i, arg := range cmd.Args {
+		if i < cmd.MinArgs {
+			parts = append(parts, "<"+arg+">")
+		} else {
+			parts = append(parts, "["+arg+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Commands is a registry of slash commands. It is safe for concurrent use.
+type Commands struct {
+	mu     sync.Mutex
+	byName map[string]*Command // names and aliases
+	list   []*Command          // in registration order
+}
+
+// NewCommands returns a registry holding the built-in commands.
+func NewCommands() *Commands {
+	r := &Commands{byName: make(map[string]*Command)}
+	for _, cmd := range builtinCommands() {
+		r.Register(cmd)
+	}
+	return r
+}
+
+// Commands returns the registry used by this client. Front ends register
+// their own commands, such as /quit, on it.
+func (c *Client) Commands() *Commands {
+	return c.commands
+}
+
+// Register adds cmd, replacing any command with the same name or alias.
+func (r *Commands) Register(cmd *Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, old := range r.list {
+		if old.Name == cmd.Name {
+			r.list = append(r.list[:i], r.list[i+1:]...)
+			break
+		}
+	}
+	r.list = append(r.list, cmd)
+	r.byName[cmd.Name] = cmd
+	for _, alias := range cmd.Aliases {
+		r.byName[alias] = cmd
+	}
+}
+
+// Lookup finds a command by name or alias, without the leading slash.
+func (r *Commands) Lookup(name string) (*Command, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cmd, ok := r.byName[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// List returns the commands available to a user, sorted by name.
+func (r *Commands) List(admin bool) []*Command {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var list []*Command
+	for _, cmd := range r.list {
+		if !cmd.Admin || admin {
+			list = append(list, cmd)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// IsCommand reports whether input should be run as a command rather than
+// sent as a message.
+func IsCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), "/")
+}
+
+// Execute parses and runs one command line such as "/msg bob hello".
+func (r *Commands) Execute(c *Client, ui CommandUI, input string) error {
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, "/") {
+		return fmt.Errorf("not a command: %s", input)
+	}
+	name, rest, _ := strings.Cut(input[1:], " ")
+
+	cmd, ok := r.Lookup(name)
+	if !ok || (cmd.Admin && !c.IsAdmin()) {
+		return fmt.Errorf("unknown command /%s, see /help", name)
+	}
+
+	args, err := splitArgs(rest, len(cmd.Args))
+	if err != nil || len(args) < cmd.MinArgs {
+		return fmt.Errorf("usage: %s", cmd.Usage())
+	}
+	return cmd.Run(c, ui, args)
+}
+
+// splitArgs splits s into at most n arguments. All but the last may be
+// wrapped in double quotes to include spaces; the last one is the rest of s.
+func splitArgs(s string, n int) ([]string, error) {
+	var args []string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		if len(args) == n-1 {
+			return append(args, s), nil
+		}
+		if len(args) == n {
+			return nil, fmt.Errorf("too many arguments")
+		}
+
+		var arg string
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			arg, s = s[1:end+1], s[end+2:]
+		} else {
+			arg, s, _ = strings.Cut(s, " ")
+		}
+		args = append(args, arg)
+		s = strings.TrimSpace(s)
+	}
+	return args, nil
+}
+
+// Complete completes the last word of input for the tab key: command names
+// at the start of a command, usernames anywhere else (with or without a
+// leading @). It returns the input extended as far as the matches agree,
+// with a trailing space once a single match remains, and the matching words
+// when there is more than one.
+func (r *Commands) Complete(c *Client, input string) (string, []string) {
+	start := strings.LastIndexAny(input, " \t\n") + 1
+	word := input[start:]
+
+	var prefix string
+	var candidates []string
+	switch {
+	case start == 0 && strings.HasPrefix(word, "/"):
+		prefix, word = "/", word[1:]
+		for _, cmd := range r.List(c.IsAdmin()) {
+			candidates = append(candidates, cmd.Name)
+		}
+	default:
+		if strings.HasPrefix(word, "@") {
+			prefix, word = "@", word[1:]
+		}
+		for _, u := range c.GetActiveUsers() {
+			if u != c.GetUsername() {
+				candidates = append(candidates, u)
+			}
+		}
+	}
+
+	var matches []string
+	for _, cand := range candidates {
+		if strings.HasPrefix(strings.ToLower(cand), strings.ToLower(word)) {
+			matches = append(matches, prefix+cand)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return input, nil
+	case 1:
+		return input[:start] + matches[0] + " ", nil
+	}
+
+	common := matches[0]
+	for _, m := range matches[1:] {
+		common = commonPrefix(common, m)
+	}
+	return input[:start] + common, matches
+}
+
+// commonPrefix returns the longest prefix of a that b shares, ignoring case.
+// It compares whole runes so the result never ends inside a character.
+func commonPrefix(a, b string) string {
+	rb := []rune(b)
+	j := 0
+	for i, r := range a {
+		if j == len(rb) || !strings.EqualFold(string(r), string(rb[j])) {
+			return a[:i]
+		}
+		j++
+	}
+	return a
+}
+
+func builtinCommands() []*Command {
+	return []*Command{
+		{
+			Name: "help",
+			Args: []string{"command"},
+			Help: "list the commands, or explain one",
+			Run:  runHelp,
+		},
+		{
+			Name:    "me",
+			Args:    []string{"action"},
+			MinArgs: 1,
+			Help:    "describe what you are doing, e.g. /me waves",
+			Run: func(c *Client, ui CommandUI, args []string) error {
+				return c.SendMessage(fmt.Sprintf("* %s %s", c.GetUsername(), args[0]))
+			},
+		},
+		{
+			Name:    "msg",
+			Aliases: []string{"w"},
+			Args:    []string{"user", "message"},
+			MinArgs: 2,
+			Help:    "send a private message",
+			Run: func(c *Client, ui CommandUI, args []string) error {
+				if !c.UserExists(args[0]) {
+					return fmt.Errorf("user %s is not online", args[0])
+				}
+				return c.SendPrivateMessage(args[0], args[1])
+			},
+		},
+		{
+			Name: "nick",
+			Args: []string{"nickname"},
+			Help: "set the nickname shown next to your username, or clear it",
+			Run: func(c *Client, ui CommandUI, args []string) error {
+				nick := ""
+				if len(args) > 0 {
+					nick = args[0]
+				}
+				return c.SetNick(nick)
+			},
+		},
+		{
+			Name:    "status",
+			Args:    []string{"online|away|busy", "text"},
+			MinArgs: 1,
+			Help:    "set your presence and an optional status text",
+			Run: func(c *Client, ui CommandUI, args []string) error {
+				text := ""
+				if len(args) > 1 {
+					text = args[1]
+				}
+				return c.SetStatus(shared.PresenceStatus(strings.ToLower(args[0])), text)
+			},
+		},
+		{
+			Name: "files",
+			Help: "list the files shared with you this session",
+			Run:  runFiles,
+		},
+		{
+			Name:    "download",
+			Args:    []string{"filename"},
+			MinArgs: 1,
+			Help:    "download a shared file into downloads/",
+			Run: func(c *Client, ui CommandUI, args []string) error {
+				return c.DownloadFile(args[0])
+			},
+		},
+		{
+			Name: "clear",
+			Help: "clear the conversation view",
+			Run: func(c *Client, ui CommandUI, args []string) error {
+				ui.Clear()
+				return nil
+			},
+		},
+		{
+			Name:    "kick",
+			Args:    []string{"user", "reason"},
+			MinArgs: 1,
+			Help:    "disconnect a user",
+			Admin:   true,
+			Run: func(c *Client, ui CommandUI, args []string) error {
+				reason := ""
+				if len(args) > 1 {
+					reason = args[1]
+				}
+				return c.Kick(args[0], reason)
+			},
+		},
+		{
+			Name:    "announce",
+			Args:    []string{"text"},
+			MinArgs: 1,
+			Help:    "send a notice to everyone",
+			Admin:   true,
+			Run: func(c *Client, ui CommandUI, args []string) error {
+				return c.Announce(args[0])
+			},
+		},
+	}
+}
+
+func runHelp(c *Client, ui CommandUI, args []string) error {
+	if len(args) > 0 {
+		cmd, ok := c.commands.Lookup(strings.TrimPrefix(args[0], "/"))
+		if !ok || (cmd.Admin && !c.IsAdmin()) {
+			return fmt.Errorf("unknown command %s", args[0])
+		}
+		text := cmd.Usage() + "\n  " + cmd.Help
+		if len(cmd.Aliases) > 0 {
+			text += "\n  also: /" + strings.Join(cmd.Aliases, ", /")
+		}
+		ui.Print(text)
+		return nil
+	}
+
+	list := c.commands.List(c.IsAdmin())
+	width := 0
+	for _, cmd := range list {
+		if n := len(cmd.Usage()); n > width {
+			width = n
+		}
+	}
+	lines := []string{"Commands:"}
+	for _, cmd := range list {
+		lines = append(lines, fmt.Sprintf("  %-*s  %s", width, cmd.Usage(), cmd.Help))
+	}
+	lines = append(lines, "Tab completes commands and usernames.")
+	ui.Print(strings.Join(lines, "\n"))
+	return nil
+}
+
+func runFiles(c *Client, ui CommandUI, args []string) error {
+	files := c.SharedFiles()
+	if len(files) == 0 {
+		ui.Print("No files have been shared yet.")
+		return nil
+	}
+	lines := []string{"Shared files:"}
+	for _, f := range files {
+		if f.Private {
+			lines = append(lines, fmt.Sprintf("  %s (private, from %s)", f.Filename, f.From))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s (from %s)", f.Filename, f.From))
+		}
+	}
+	ui.Print(strings.Join(lines, "\n"))
+	return nil
+}
@@ -0,0 +1,300 @@
+package client
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+// Event is something that happened on the connection. Handlers receive one
+// of the *Event types in this file and switch on the concrete type; String
+// gives a one-line description for plain text clients.
+type Event interface {
+	fmt.Stringer
+	isEvent()
+}
+
+// PublicMessageEvent is a decrypted message to the room, including replies
+// in threads and the messages we send ourselves.
+type PublicMessageEvent struct {
+	Message *ChatMessage
+}
+
+// PrivateMessageEvent is a decrypted private message sent to or by us.
+type PrivateMessageEvent struct {
+	Message *ChatMessage
+}
+
+// MessageUpdatedEvent carries the new state of a message after it was
+// edited, deleted, reacted to or replied to.
+type MessageUpdatedEvent struct {
+	Message *ChatMessage
+}
+
+// ThreadEvent answers FetchThread: the parent message first, followed by its
+// replies.
+type ThreadEvent struct {
+	ParentID string
+	Messages []*ChatMessage
+}
+
+// MentionEvent is sent in addition to the message event when someone else
+// mentions us.
+type MentionEvent struct {
+	Message *ChatMessage
+}
+
+type UserListEvent struct {
+	Users []string
+}
+
+type PresenceEvent struct {
+	Presence []shared.Presence
+}
+
+// TypingEvent is a typing notice. To is empty for the room and set to our
+// username for private conversations.
+type TypingEvent struct {
+	From string
+	To   string
+}
+
+// ReceiptEvent tells that a private message we sent was delivered or read.
+type ReceiptEvent struct {
+	ID     string
+	Status ReceiptStatus
+}
+
+type UserJoinedEvent struct {
+	Username string
+	Time     time.Time
+}
+
+type UserLeftEvent struct {
+	Username string
+	Time     time.Time
+}
+
+// FileAvailableEvent announces a file that can be fetched with RequestFile,
+// or with RequestPrivateFile when Private is set.
+type FileAvailableEvent struct {
+	From     string
+	Filename string
+	Private  bool
+	Time     time.Time
+}
+
+// FileReceivedEvent is sent once a downloaded file was decrypted and saved.
+type FileReceivedEvent struct {
+	Filename string
+	Path     string
+	Private  bool
+}
+
+// InfoEvent is a notice from the server, such as a finished upload.
+type InfoEvent struct {
+	Text string
+	Time time.Time
+}
+
+// ErrorEvent is an error reported by the server or raised while handling an
+// incoming message.
+type ErrorEvent struct {
+	Text string
+}
+
+// KickedEvent tells that an admin removed us from the room. The client does
+// not reconnect afterwards.
+type KickedEvent struct {
+	By     string
+	Reason string
+}
+
+// SendStatusEvent reports an outgoing message or file entering or leaving
+// the outbox: it is pending while the client is offline or waiting for the
+// recipient's public key, then sent or failed. ID is the message ID, which chat rows can be looked up by.
+type SendStatusEvent struct {
+	ID       string
+	To       string // recipient of a private message or file
+	Filename string // set for files
+	Status   SendStatus
+	Err      string // why it failed
+}
+
+func newSendStatusEvent(it *outboxItem, status SendStatus, err error) SendStatusEvent {
+	ev := SendStatusEvent{ID: it.ID, To: it.To, Status: status}
+	if it.Path != "" {
+		ev.Filename = filepath.Base(it.Path)
+	}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	return ev
+}
+
+// ServerShutdownEvent tells that the server is going down. Unless the client
+// was kicked, it reconnects after RetryAfter.
+type ServerShutdownEvent struct {
+	Text       string
+	RetryAfter time.Duration
+}
+
+// ConnectionEvent reports a change of the connection state: the connection
+// dropped or came back, a reconnect attempt is about to be made, or the
+// client stopped trying.
+type ConnectionEvent struct {
+	Connected bool // State == StateConnected
+	State     ConnState
+	Attempt   int           // reconnect attempt about to be made, from 1
+	Retry     time.Duration // wait before that attempt
+}
+
+func (PublicMessageEvent) isEvent()  {}
+func (PrivateMessageEvent) isEvent() {}
+func (MessageUpdatedEvent) isEvent() {}
+func (ThreadEvent) isEvent()         {}
+func (MentionEvent) isEvent()        {}
+func (UserListEvent) isEvent()       {}
+func (PresenceEvent) isEvent()       {}
+func (TypingEvent) isEvent()         {}
+func (ReceiptEvent) isEvent()        {}
+func (UserJoinedEvent) isEvent()     {}
+func (UserLeftEvent) isEvent()       {}
+func (FileAvailableEvent) isEvent()  {}
+func (FileReceivedEvent) isEvent()   {}
+func (InfoEvent) isEvent()           {}
+func (ErrorEvent) isEvent()          {}
+func (KickedEvent) isEvent()         {}
+func (SendStatusEvent) isEvent()     {}
+func (ServerShutdownEvent) isEvent() {}
+func (ConnectionEvent) isEvent()     {}
+
+func (e PublicMessageEvent) String() string  { return e.Message.String() }
+func (e PrivateMessageEvent) String() string { return e.Message.String() }
+func (e MessageUpdatedEvent) String() string { return e.Message.String() }
+func (e MentionEvent) String() string        { return e.Message.String() }
+
+func (e ThreadEvent) String() string {
+	lines := make([]string, len(e.Messages))
+	for i, m := range e.Messages {
+		lines[i] = m.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e UserListEvent) String() string {
+	return "Active users: " + strings.Join(e.Users, ", ")
+}
+
+func (e PresenceEvent) String() string {
+	parts := make([]string, len(e.Presence))
+	for i, p := range e.Presence {
+		parts[i] = fmt.Sprintf("%s (%s)", p.Username, p.Status)
+	}
+	return "Presence: " + strings.Join(parts, ", ")
+}
+
+func (e TypingEvent) String() string {
+	if e.To != "" {
+		return e.From + " is typing to you..."
+	}
+	return e.From + " is typing..."
+}
+
+func (e ReceiptEvent) String() string {
+	if e.Status == ReceiptRead {
+		return fmt.Sprintf("Message %s read", e.ID)
+	}
+	return fmt.Sprintf("Message %s delivered", e.ID)
+}
+
+func (e UserJoinedEvent) String() string {
+	return systemLine(e.Time, e.Username+" has joined the chat")
+}
+
+func (e UserLeftEvent) String() string {
+	return systemLine(e.Time, e.Username+" has left the chat")
+}
+
+func (e FileAvailableEvent) String() string {
+	if e.Private {
+		return systemLine(e.Time, fmt.Sprintf("%s sent you the file %s", e.From, e.Filename))
+	}
+	return systemLine(e.Time, fmt.Sprintf("%s shared the file %s", e.From, e.Filename))
+}
+
+func (e FileReceivedEvent) String() string {
+	return fmt.Sprintf("[File] Saved %s to %s", e.Filename, e.Path)
+}
+
+func (e InfoEvent) String() string { return systemLine(e.Time, e.Text) }
+
+func (e ErrorEvent) String() string { return "(Error) " + e.Text }
+
+func (e KickedEvent) String() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("(System) You were removed from the chat by %s", e.By)
+	}
+	return fmt.Sprintf("(System) You were removed from the chat by %s: %s", e.By, e.Reason)
+}
+
+func (e SendStatusEvent) String() string {
+	what := "Message"
+	if e.Filename != "" {
+		what = "File " + e.Filename
+	}
+	if e.To != "" {
+		what += " to " + e.To
+	}
+	switch e.Status {
+	case SendPending:
+		return fmt.Sprintf("(Outbox) %s will be sent when the connection is back.", what)
+	case SendFailed:
+		return fmt.Sprintf("(Outbox) %s could not be sent: %s", what, e.Err)
+	}
+	return fmt.Sprintf("(Outbox) %s sent.", what)
+}
+
+func (e ServerShutdownEvent) String() string {
+	text := e.Text
+	if text == "" {
+		text = "Server is shutting down"
+	}
+	return fmt.Sprintf("(System) %s. Reconnecting in %s...", strings.TrimSuffix(text, "."), e.RetryAfter)
+}
+
+func (e ConnectionEvent) String() string {
+	switch {
+	case e.Connected:
+		return "Reconnected to server."
+	case e.State == StateOffline:
+		return "Disconnected from server."
+	case e.Attempt <= 1 && e.Retry == 0:
+		return "Disconnected from server. Attempting reconnect..."
+	case e.Retry == 0:
+		return fmt.Sprintf("Reconnecting (attempt %d)...", e.Attempt)
+	}
+	return fmt.Sprintf("Reconnecting in %s (attempt %d)...", e.Retry.Round(time.Second), e.Attempt)
+}
+
+func systemLine(t time.Time, text string) string {
+	return fmt.Sprintf("(System) (%s) %s", t.Format("15:04:05"), text)
+}
+
+// SetEventHandler registers the callback that receives every event. It is
+// called from the connection's reader goroutine, so it should not block for
+// long. Without a handler events are printed to stdout.
+func (c *Client) SetEventHandler(handler func(ev Event)) {
+	c.onEvent = handler
+}
+
+func (c *Client) emit(ev Event) {
+	if c.onEvent != nil {
+		c.onEvent(ev)
+	} else {
+		fmt.Println(ev)
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+// FileOffer is a file someone shared with the room or sent to us.
+type FileOffer struct {
+	From     string
+	Filename string
+	Private  bool
+}
+
+func (c *Client) addFileOffer(from, filename string, private bool) {
+	c.mu.Lock()
+	c.files = append(c.files, FileOffer{From: from, Filename: filename, Private: private})
+	c.mu.Unlock()
+}
+
+// SharedFiles returns the files offered to us since we connected, oldest
+// first.
+func (c *Client) SharedFiles() []FileOffer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]FileOffer(nil), c.files...)
+}
+
+// DownloadFile requests a file by name. If the same name was offered more
+// than once the newest offer wins, so a private file shadows an older public
+// one.
+func (c *Client) DownloadFile(filename string) error {
+	c.mu.Lock()
+	var offer *FileOffer
+	for i := len(c.files) - 1; i >= 0; i-- {
+		if c.files[i].Filename == filename {
+			offer = &c.files[i]
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if offer != nil && offer.Private {
+		return c.RequestPrivateFile(filename, offer.From)
+	}
+	return c.RequestFile(filename)
+}
@@ -0,0 +1,308 @@
+package gui
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+
+	"chatroom/internal/client"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+var (
+	receiptGray = color.NRGBA{R: 140, G: 140, B: 140, A: 255}
+	receiptBlue = color.NRGBA{R: 0, G: 120, B: 215, A: 255}
+	deletedGray = color.NRGBA{R: 130, G: 130, B: 130, A: 255}
+	mentionBg   = color.NRGBA{R: 255, G: 243, B: 176, A: 255} // Pale yellow
+	failedRed   = color.NRGBA{R: 200, G: 0, B: 0, A: 255}
+)
+
+// chatRow is a rendered chat message that can be updated in place.
+type chatRow struct {
+	msg       *client.ChatMessage
+	text      *canvas.Text
+	actions   *widget.Button
+	reactions *fyne.Container // reaction bar shown under the message
+	replies   *widget.Button  // opens the thread; nil inside the thread panel
+}
+
+func (a *App) addChatMessage(msg *client.ChatMessage) {
+	if msg.ParentID != "" {
+		a.addThreadReply(msg)
+		return
+	}
+
+	row, obj := a.newChatRow(msg, a.rows)
+	row.replies = widget.NewButton("", func() { a.openThread(row.msg.ID) })
+	row.replies.Importance = widget.LowImportance
+	a.renderReplyCount(row)
+
+	a.messageList.Add(container.NewVBox(obj, row.replies))
+	a.messagesScroll.Refresh()
+	a.messagesScroll.ScrollToBottom()
+
+	if msg.Private && !msg.Outgoing {
+		go a.client.MarkRead(msg)
+	}
+}
+
+// newChatRow renders msg and registers the row in rows so later edits,
+// deletions and reactions can find it.
+func (a *App) newChatRow(msg *client.ChatMessage, rows map[string]*chatRow) (*chatRow, fyne.CanvasObject) {
+	row := &chatRow{
+		msg:  msg,
+		text: a.newMessageText(msg.String()),
+	}
+	line := container.NewHBox(row.text)
+
+	if msg.Private && msg.Outgoing && msg.ID != "" {
+		marks := canvas.NewText("", receiptGray)
+		marks.TextSize = 11
+		setReceiptMarks(marks, client.ReceiptSent)
+
+		a.receiptsMu.Lock()
+		a.receipts[msg.ID] = marks
+		a.receiptsMu.Unlock()
+
+		line.Add(marks)
+	}
+
+	if msg.ID != "" {
+		row.actions = widget.NewButtonWithIcon("", theme.MoreHorizontalIcon(), nil)
+		row.actions.Importance = widget.LowImportance
+		row.actions.OnTapped = func() { a.showMessageMenu(row) }
+		line.Add(row.actions)
+
+		a.rowsMu.Lock()
+		rows[msg.ID] = row
+		a.rowsMu.Unlock()
+	}
+
+	renderSendStatus(row)
+	row.reactions = container.NewHBox()
+	a.renderReactions(row)
+	if msg.Deleted {
+		a.renderDeleted(row)
+	}
+
+	content := container.NewVBox(line, row.reactions)
+	if msg.Mentioned {
+		row.text.TextStyle = fyne.TextStyle{Bold: true}
+		return row, container.NewStack(canvas.NewRectangle(mentionBg), content)
+	}
+	return row, content
+}
+
+// updateChatMessage redraws a message after it was edited, deleted, reacted
+// to or replied to, both in the conversation and in the open thread.
+func (a *App) updateChatMessage(msg *client.ChatMessage) {
+	a.rowsMu.Lock()
+	rows := []*chatRow{a.rows[msg.ID], a.threadRows[msg.ID]}
+	a.rowsMu.Unlock()
+
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		row.msg = msg
+		row.text.Text = msg.String()
+		if msg.Deleted {
+			a.renderDeleted(row)
+		}
+		row.text.Refresh()
+		a.renderReactions(row)
+		a.renderReplyCount(row)
+	}
+	a.messageList.Refresh()
+	if a.threadList != nil {
+		a.threadList.Refresh()
+	}
+}
+
+// updateSendStatus marks a chat message pending, sent or not sent as it
+// goes through the outbox. Files have no row, so their progress goes to the
+// chat log, as do failures.
+func (a *App) updateSendStatus(ev client.SendStatusEvent) {
+	a.rowsMu.Lock()
+	rows := []*chatRow{a.rows[ev.ID], a.threadRows[ev.ID]}
+	a.rowsMu.Unlock()
+
+	found := false
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		found = true
+		row.msg.Send = ev.Status
+		text := a.newMessageText(row.msg.String())
+		row.text.Text, row.text.Color = text.Text, text.Color
+		renderSendStatus(row)
+		row.text.Refresh()
+	}
+	if !found || ev.Status == client.SendFailed {
+		a.addTextMessage(ev.String())
+	}
+}
+
+// renderSendStatus grays out a pending message and turns one that could not
+// be sent red.
+func renderSendStatus(row *chatRow) {
+	switch row.msg.Send {
+	case client.SendPending:
+		row.text.Color = receiptGray
+	case client.SendFailed:
+		row.text.Color = failedRed
+	}
+}
+
+func (a *App) renderDeleted(row *chatRow) {
+	row.text.Color = deletedGray
+	row.text.TextStyle = fyne.TextStyle{Italic: true}
+	if row.actions != nil {
+		row.actions.Hide()
+	}
+}
+
+// renderReactions redraws the reaction bar of a message: one toggle button
+// per emoji with its count, followed by the names of who reacted.
+func (a *App) renderReactions(row *chatRow) {
+	row.reactions.Objects = nil
+	if row.msg.Deleted || len(row.msg.Reactions) == 0 {
+		row.reactions.Hide()
+		row.reactions.Refresh()
+		return
+	}
+
+	emojis := make([]string, 0, len(row.msg.Reactions))
+	for emoji := range row.msg.Reactions {
+		emojis = append(emojis, emoji)
+	}
+	sort.Strings(emojis)
+
+	me := a.client.GetUsername()
+	var who []string
+	for _, emoji := range emojis {
+		users := row.msg.Reactions[emoji]
+		e := emoji
+		btn := widget.NewButton(fmt.Sprintf("%s %d", e, len(users)), func() {
+			a.toggleReaction(row.msg.ID, e)
+		})
+		btn.Importance = widget.LowImportance
+		for _, name := range users {
+			if name == me {
+				btn.Importance = widget.HighImportance
+			}
+		}
+		row.reactions.Add(btn)
+		who = append(who, e+" "+strings.Join(users, ", "))
+	}
+
+	names := canvas.NewText(strings.Join(who, "  ·  "), receiptGray)
+	names.TextSize = 11
+	row.reactions.Add(names)
+	row.reactions.Show()
+	row.reactions.Refresh()
+}
+
+func (a *App) toggleReaction(id, emoji string) {
+	if err := a.client.ToggleReaction(id, emoji); err != nil {
+		dialog.ShowError(fmt.Errorf("failed to react: %v", err), a.mainWindow)
+	}
+}
+
+func (a *App) showReactionPicker(id string) {
+	var d dialog.Dialog
+	tabs := newEmojiTabs(func(emoji string) {
+		d.Hide()
+		a.toggleReaction(id, emoji)
+	})
+	d = dialog.NewCustom("React", "Close", tabs, a.mainWindow)
+	d.Resize(fyne.NewSize(450, 350))
+	d.Show()
+}
+
+func (a *App) showMessageMenu(row *chatRow) {
+	id := row.msg.ID
+	if row.msg.Deleted {
+		return
+	}
+
+	items := []*fyne.MenuItem{
+		fyne.NewMenuItem("React", func() { a.showReactionPicker(id) }),
+	}
+	if !row.msg.Private {
+		items = append(items, fyne.NewMenuItem("Reply in thread", func() { a.openThread(id) }))
+	}
+	items = append(items, fyne.NewMenuItem("Quote", func() { a.quoteMessage(row.msg) }))
+	if a.client.CanModify(id) {
+		items = append(items,
+			fyne.NewMenuItem("Edit", func() { a.showEditDialog(row.msg) }),
+			fyne.NewMenuItem("Delete", func() { a.confirmDelete(id) }),
+		)
+	}
+
+	c := fyne.CurrentApp().Driver().CanvasForObject(row.actions)
+	pos := fyne.CurrentApp().Driver().AbsolutePositionForObject(row.actions)
+	widget.ShowPopUpMenuAtPosition(fyne.NewMenu("", items...), c,
+		pos.Add(fyne.NewPos(0, row.actions.Size().Height)))
+}
+
+func (a *App) showEditDialog(msg *client.ChatMessage) {
+	entry := widget.NewEntry()
+	entry.SetText(msg.Content)
+
+	dialog.ShowCustomConfirm("Edit message", "Save", "Cancel", entry, func(save bool) {
+		if !save || entry.Text == msg.Content {
+			return
+		}
+		if err := a.client.EditMessage(msg.ID, ConvertEmojis(entry.Text)); err != nil {
+			dialog.ShowError(fmt.Errorf("failed to edit message: %v", err), a.mainWindow)
+		}
+	}, a.mainWindow)
+}
+
+func (a *App) confirmDelete(id string) {
+	dialog.ShowConfirm("Delete message", "Delete this message for everyone?", func(ok bool) {
+		if !ok {
+			return
+		}
+		if err := a.client.DeleteMessage(id); err != nil {
+			dialog.ShowError(fmt.Errorf("failed to delete message: %v", err), a.mainWindow)
+		}
+	}, a.mainWindow)
+}
+
+func (a *App) updateReceipt(id string, status client.ReceiptStatus) {
+	a.receiptsMu.Lock()
+	marks, ok := a.receipts[id]
+	a.receiptsMu.Unlock()
+	if !ok {
+		return
+	}
+	setReceiptMarks(marks, status)
+}
+
+// setReceiptMarks shows ✓ (sent), ✓✓ (delivered) or blue ✓✓ (read). A late
+// delivered receipt never downgrades a message that was already read.
+func setReceiptMarks(marks *canvas.Text, status client.ReceiptStatus) {
+	if marks.Color == receiptBlue {
+		return
+	}
+	switch status {
+	case client.ReceiptRead:
+		marks.Text = "✓✓"
+		marks.Color = receiptBlue
+	case client.ReceiptDelivered:
+		marks.Text = "✓✓"
+	default:
+		marks.Text = "✓"
+	}
+	marks.Refresh()
+}
@@ -0,0 +1,58 @@
+package gui
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2/canvas"
+)
+
+// Print implements client.CommandUI.
+func (a *App) Print(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		a.messageList.Add(a.newMessageText(line))
+	}
+	a.messagesScroll.Refresh()
+	a.messagesScroll.ScrollToBottom()
+}
+
+// Clear implements client.CommandUI.
+func (a *App) Clear() {
+	a.closeThread()
+	a.messageList.RemoveAll()
+	a.messagesScroll.Refresh()
+
+	a.rowsMu.Lock()
+	a.rows = make(map[string]*chatRow)
+	a.rowsMu.Unlock()
+	a.receiptsMu.Lock()
+	a.receipts = make(map[string]*canvas.Text)
+	a.receiptsMu.Unlock()
+}
+
+// completeInput handles the tab key in the message entry. The first press
+// completes as far as the matches agree; pressing it again cycles through
+// the matches.
+func (a *App) completeInput() {
+	text := a.input.Text
+	if len(a.tabMatches) > 0 && text == a.tabText {
+		a.tabIndex = (a.tabIndex + 1) % len(a.tabMatches)
+		a.setCompletion(text, a.tabMatches[a.tabIndex])
+		return
+	}
+
+	completed, matches := a.client.Commands().Complete(a.client, text)
+	a.tabMatches = nil
+	if completed != text || len(matches) == 0 {
+		a.input.setTextAtEnd(completed)
+		return
+	}
+	a.tabMatches, a.tabIndex = matches, 0
+	a.setCompletion(text, matches[0])
+}
+
+// setCompletion replaces the last word of text with word.
+func (a *App) setCompletion(text, word string) {
+	start := strings.LastIndexAny(text, " \t\n") + 1
+	a.tabText = text[:start] + word
+	a.input.setTextAtEnd(a.tabText)
+}
@@ -0,0 +1,63 @@
+package gui
+
+import (
+	"image/color"
+
+	"chatroom/internal/client"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+)
+
+func connStateColor(state client.ConnState) color.Color {
+	switch state {
+	case client.StateConnected:
+		return color.NRGBA{R: 0, G: 200, B: 0, A: 255} // Green
+	case client.StateReconnecting:
+		return color.NRGBA{R: 240, G: 180, B: 0, A: 255} // Amber
+	default:
+		return color.NRGBA{R: 200, G: 0, B: 0, A: 255} // Red
+	}
+}
+
+// createConnectionBar builds the status bar at the bottom of the window. It
+// shows the connection state and, while the client is not connected,
+// buttons to retry at once or to stop reconnecting.
+func (a *App) createConnectionBar() fyne.CanvasObject {
+	a.connIcon = canvas.NewCircle(connStateColor(client.StateOffline))
+	a.connLabel = widget.NewLabel("Not connected")
+	a.connLabel.TextStyle = fyne.TextStyle{Italic: true}
+
+	a.retryBtn = widget.NewButton("Retry now", func() { a.client.RetryNow() })
+	a.stopBtn = widget.NewButton("Stop", func() { a.client.StopReconnect() })
+	a.retryBtn.Hide()
+	a.stopBtn.Hide()
+
+	// The circle has no minimum size of its own.
+	icon := container.NewGridWrap(fyne.NewSize(10, 10), a.connIcon)
+	return container.NewHBox(container.NewCenter(icon), a.connLabel, layout.NewSpacer(), a.retryBtn, a.stopBtn)
+}
+
+// showConnectionState updates the status bar for ev.
+func (a *App) showConnectionState(ev client.ConnectionEvent) {
+	a.connIcon.FillColor = connStateColor(ev.State)
+	a.connIcon.Refresh()
+
+	switch ev.State {
+	case client.StateConnected:
+		a.connLabel.SetText("Connected")
+		a.retryBtn.Hide()
+		a.stopBtn.Hide()
+	case client.StateReconnecting:
+		a.connLabel.SetText(ev.String())
+		a.retryBtn.Show()
+		a.stopBtn.Show()
+	default:
+		a.connLabel.SetText("Offline")
+		a.retryBtn.Show()
+		a.stopBtn.Hide()
+	}
+}
@@ -3,13 +3,15 @@ package gui
 import (
 	"fmt"
 	"image/color"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"chatroom/internal/client"
+	"chatroom/internal/shared"
+	"chatroom/pkg/logger"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -20,6 +22,8 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+var log = logger.New("gui")
+
 // Yahoo Messenger inspired colors
 var (
 	yahooYellow    = color.NRGBA{R: 255, G: 204, B: 0, A: 255}   // Yahoo yellow
@@ -38,15 +42,37 @@ type App struct {
 	users          []string
 	connected      bool
 	msgHistory     []string
-	incoming       chan string
+	incoming       chan client.Event
 	messageList    *fyne.Container
 	currentMsg     string
+	typingLabel    *widget.Label
+	typing         map[string]time.Time // "from" or "from (private)" -> last notice
+	typingMu       sync.Mutex
+	receipts       map[string]*canvas.Text // sent private message ID -> check marks
+	receiptsMu     sync.Mutex
+	rows           map[string]*chatRow // chat message ID -> rendered row
+	rowsMu         sync.Mutex
+	threadPanel    *fyne.Container
+	threadList     *fyne.Container
+	threadScroll   *container.Scroll
+	threadInput    *customEntry
+	threadParent   string              // ID of the message whose thread is open
+	threadRows     map[string]*chatRow // rows of the open thread, guarded by rowsMu
+	notifyAll      bool                // desktop notifications for every line, not just mentions and DMs
+	tabMatches     []string            // completions cycled through by repeated tabs
+	tabIndex       int
+	tabText        string // input as last set by completion
+	connIcon       *canvas.Circle
+	connLabel      *widget.Label
+	retryBtn       *widget.Button
+	stopBtn        *widget.Button
 }
 
 // Custom entry widget to handle Enter key properly
 type customEntry struct {
 	widget.Entry
 	onEnterPressed func()
+	onTab          func()
 }
 
 func newCustomEntry() *customEntry {
@@ -62,24 +88,44 @@ func (e *customEntry) TypedKey(key *fyne.KeyEvent) {
 		e.onEnterPressed()
 		return
 	}
+	if key.Name == fyne.KeyTab && e.onTab != nil {
+		e.onTab()
+		return
+	}
 	e.Entry.TypedKey(key)
 }
 
-func NewApp(client *client.Client) *App {
+// AcceptsTab keeps the tab key in the entry so it can complete commands
+// instead of moving the focus.
+func (e *customEntry) AcceptsTab() bool {
+	return e.onTab != nil
+}
+
+// setTextAtEnd replaces the text and moves the cursor behind it.
+func (e *customEntry) setTextAtEnd(text string) {
+	e.SetText(text)
+	e.CursorRow = strings.Count(text, "\n")
+	e.CursorColumn = len([]rune(text[strings.LastIndex(text, "\n")+1:]))
+	e.Refresh()
+}
+
+func NewApp(c *client.Client) *App {
 	a := &App{
-		client:     client,
+		client:     c,
 		msgHistory: make([]string, 0),
 		users:      make([]string, 0),
-		incoming:   make(chan string, 100),
+		incoming:   make(chan client.Event, 100),
 		currentMsg: "",
+		typing:     make(map[string]time.Time),
+		receipts:   make(map[string]*canvas.Text),
+		rows:       make(map[string]*chatRow),
+		threadRows: make(map[string]*chatRow),
 	}
 
-	client.SetMessageHandler(func(msg string) {
-		fmt.Println("[DEBUG] Raw to server:", msg)
-		a.incoming <- msg
-	})
+	c.SetEventHandler(a.queueEvent)
 
 	go a.dispatchMessages()
+	go a.expireTyping()
 
 	return a
 }
@@ -158,37 +204,59 @@ func (a *App) setupUI() {
 			icon := canvas.NewCircle(color.NRGBA{R: 0, G: 200, B: 0, A: 255})
 			icon.Resize(fyne.NewSize(8, 8))
 			label := widget.NewLabel("Template User")
-			return container.NewHBox(icon, label)
+			status := canvas.NewText("", color.NRGBA{R: 100, G: 100, B: 100, A: 255})
+			status.TextSize = 11
+			status.TextStyle = fyne.TextStyle{Italic: true}
+			return container.NewHBox(icon, label, status)
 		},
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
 			box := obj.(*fyne.Container)
 			icon := box.Objects[0].(*canvas.Circle)
 			label := box.Objects[1].(*widget.Label)
+			status := box.Objects[2].(*canvas.Text)
 
 			username := a.users[id]
 
-			icon.FillColor = color.NRGBA{R: 0, G: 200, B: 0, A: 255}
+			presence, ok := a.client.GetPresence(strings.TrimSuffix(username, " (you)"))
+			if !ok {
+				presence.Status = shared.StatusOnline
+			}
+			icon.FillColor = presenceColor(presence.Status)
+			icon.Refresh()
+			status.Text = presenceText(presence)
+			status.Refresh()
 
 			if strings.Contains(username, "(you)") {
 				label.TextStyle = fyne.TextStyle{Bold: true}
 			} else {
 				label.TextStyle = fyne.TextStyle{}
 			}
-			label.SetText(username)
+			if presence.Nick != "" {
+				label.SetText(fmt.Sprintf("%s (%s)", username, presence.Nick))
+			} else {
+				label.SetText(username)
+			}
 		},
 	)
 
 	userScroll := container.NewScroll(a.userList)
-	userContainer := createYahooBox(userScroll, "Buddies Online", userPanelColor)
+	userContainer := createYahooBox(
+		container.NewBorder(nil, a.createStatusBox(), nil, nil, userScroll),
+		"Buddies Online", userPanelColor)
 
 	a.input = newCustomEntry()
 	a.input.SetPlaceHolder("Type a message here...")
+	a.input.OnChanged = a.notifyTyping
+
+	a.typingLabel = widget.NewLabel("")
+	a.typingLabel.TextStyle = fyne.TextStyle{Italic: true}
 
 	a.input.onEnterPressed = func() {
 		content := a.input.Text
 		content = ConvertEmojis(content)
 		a.sendMessage(content)
 	}
+	a.input.onTab = a.completeInput
 
 	emojiBtn := widget.NewButtonWithIcon("", theme.ContentAddIcon(), a.showEmojiPicker)
 	emojiBtn.SetText("")
@@ -205,7 +273,9 @@ func (a *App) setupUI() {
 	})
 	sendBtn.Importance = widget.HighImportance
 
-	leftButtons := container.NewHBox(emojiBtn, fileBtn)
+	mentionsBtn := widget.NewButton("@", a.showMentions)
+
+	leftButtons := container.NewHBox(emojiBtn, fileBtn, mentionsBtn)
 
 	inputBox := container.NewBorder(
 		nil, nil,
@@ -214,11 +284,13 @@ func (a *App) setupUI() {
 		a.input,
 	)
 
-	inputContainer := createYahooBox(inputBox, "", lightGray)
+	inputContainer := createYahooBox(
+		container.NewBorder(a.typingLabel, nil, nil, nil, inputBox),
+		"", lightGray)
 
 	// Main layout
 	chatArea := container.NewBorder(
-		nil, inputContainer, nil, nil,
+		nil, inputContainer, nil, a.createThreadPanel(),
 		messagesContainer,
 	)
 
@@ -230,7 +302,7 @@ func (a *App) setupUI() {
 	topBar.SetMinSize(fyne.NewSize(0, 3))
 
 	mainContent := container.NewBorder(
-		topBar, nil, nil, nil,
+		topBar, container.NewPadded(a.createConnectionBar()), nil, nil,
 		container.NewPadded(split),
 	)
 
@@ -297,6 +369,7 @@ func (a *App) showLoginDialog() {
 		}
 
 		a.connected = true
+		a.showConnectionState(client.ConnectionEvent{State: client.StateConnected, Connected: true})
 	}
 
 	dlg = dialog.NewCustomConfirm("Login", "Connect", "Exit", content, func(connect bool) {
@@ -311,6 +384,16 @@ func (a *App) showLoginDialog() {
 }
 
 func (a *App) showEmojiPicker() {
+	tabs := newEmojiTabs(func(e string) {
+		a.input.SetText(a.input.Text + e)
+	})
+
+	d := dialog.NewCustom("Choose Emoji", "Close", tabs, a.mainWindow)
+	d.Resize(fyne.NewSize(450, 350))
+	d.Show()
+}
+
+func newEmojiTabs(onPick func(emoji string)) *container.AppTabs {
 	tabs := container.NewAppTabs()
 
 	for category, group := range emojiMap {
@@ -318,7 +401,7 @@ func (a *App) showEmojiPicker() {
 		for _, emoji := range group {
 			e := emoji
 			btn := widget.NewButton(e, func() {
-				a.input.SetText(a.input.Text + e)
+				onPick(e)
 			})
 			buttons = append(buttons, btn)
 		}
@@ -326,10 +409,7 @@ func (a *App) showEmojiPicker() {
 		scroll := container.NewVScroll(grid)
 		tabs.Append(container.NewTabItem(category, scroll))
 	}
-
-	d := dialog.NewCustom("Choose Emoji", "Close", tabs, a.mainWindow)
-	d.Resize(fyne.NewSize(450, 350))
-	d.Show()
+	return tabs
 }
 
 func GetEmojiList() []string {
@@ -343,65 +423,74 @@ func GetEmojiList() []string {
 }
 
 func (a *App) dispatchMessages() {
-	for msg := range a.incoming {
-		log.Println("Received message:", msg)
-		fyne.CurrentApp().SendNotification(&fyne.Notification{
-			Title:   "New Message",
-			Content: msg,
-		})
-
-		a.processMessage(msg)
-		log.Println("Processed message in UI thread")
+	for item := range a.incoming {
+		// Events can hold message text, so only their type is logged.
+		log.Debug("Processing event", "event", fmt.Sprintf("%T", item))
+		a.processEvent(item)
 	}
 }
 
-func (a *App) processMessage(msg string) {
-	if strings.HasPrefix(msg, "Active users: ") {
-		users := strings.TrimPrefix(msg, "Active users: ")
-		rawUsers := strings.Split(users, ",")
-		a.users = make([]string, len(rawUsers))
-		for i, u := range rawUsers {
-			u = strings.TrimSpace(u)
-			if u == a.client.GetUsername() {
-				a.users[i] = u + " (you)"
-			} else {
-				a.users[i] = u
-			}
-		}
-		a.userList.Refresh()
-		return
-	}
-
-	// Handle public file transfers
-	if idx := strings.Index(msg, "[FILE]"); idx != -1 {
-		s := strings.TrimSpace(msg[idx+len("[FILE]"):])
-		parts := strings.SplitN(s, ":", 2)
-		if len(parts) == 2 {
-			from := strings.TrimSpace(parts[0])
-			filename := strings.TrimSpace(parts[1])
+func (a *App) queueEvent(ev client.Event) {
+	a.incoming <- ev
+}
 
-			a.addFileMessage(from, filename, false, "")
-			return
+func (a *App) processEvent(ev client.Event) {
+	switch ev := ev.(type) {
+	case client.PublicMessageEvent:
+		a.notifyChat(ev.Message)
+		a.addChatMessage(ev.Message)
+	case client.PrivateMessageEvent:
+		a.notifyChat(ev.Message)
+		a.addChatMessage(ev.Message)
+	case client.MessageUpdatedEvent:
+		a.updateChatMessage(ev.Message)
+	case client.ThreadEvent:
+		a.showThread(ev.ParentID, ev.Messages)
+	case client.MentionEvent:
+		// Mentions are highlighted and notified with their message event.
+	case client.UserListEvent:
+		a.setUsers(ev.Users)
+	case client.PresenceEvent:
+		a.userList.Refresh()
+	case client.TypingEvent:
+		a.handleTyping(ev.From, ev.To)
+	case client.ReceiptEvent:
+		a.updateReceipt(ev.ID, ev.Status)
+	case client.FileAvailableEvent:
+		if a.notifyAll || ev.Private {
+			a.sendNotification("New File", ev.String())
+		}
+		a.addFileMessage(ev.From, ev.Filename, ev.Private, ev.From)
+	case client.SendStatusEvent:
+		a.updateSendStatus(ev)
+	case client.KickedEvent:
+		a.addTextMessage(ev.String())
+		dialog.ShowInformation("Disconnected", ev.String(), a.mainWindow)
+	case client.ConnectionEvent:
+		a.showConnectionState(ev)
+		// Every attempt shows in the status bar; the chat only gets the
+		// first one.
+		if ev.State != client.StateReconnecting || ev.Attempt == 1 {
+			a.addTextMessage(ev.String())
 		}
+	default:
+		if a.notifyAll {
+			a.sendNotification("New Message", ev.String())
+		}
+		a.addTextMessage(ev.String())
 	}
+}
 
-	// Handle private file transfers
-	if idx := strings.Index(msg, "[PRIVATE FILE]"); idx != -1 {
-		s := strings.TrimSpace(msg[idx+len("[PRIVATE FILE]"):])
-		if strings.Contains(s, " sent you: ") {
-			parts := strings.SplitN(s, " sent you: ", 2)
-			if len(parts) == 2 {
-				from := strings.TrimSpace(parts[0])
-				filename := strings.TrimSpace(parts[1])
-
-				a.addFileMessage(from, filename, true, from)
-				return
-			}
+func (a *App) setUsers(users []string) {
+	a.users = make([]string, len(users))
+	for i, u := range users {
+		if u == a.client.GetUsername() {
+			a.users[i] = u + " (you)"
+		} else {
+			a.users[i] = u
 		}
 	}
-
-	// Regular text messages
-	a.addTextMessage(msg)
+	a.userList.Refresh()
 }
 
 func (a *App) addFileMessage(from, filename string, isPrivate bool, sender string) {
@@ -444,6 +533,12 @@ func (a *App) addFileMessage(from, filename string, isPrivate bool, sender strin
 }
 
 func (a *App) addTextMessage(msg string) {
+	a.messageList.Add(a.newMessageText(msg))
+	a.messagesScroll.Refresh()
+	a.messagesScroll.ScrollToBottom()
+}
+
+func (a *App) newMessageText(msg string) *canvas.Text {
 	// Yahoo Messenger style message colors
 	displayMsg := msg
 	prefix := a.client.GetUsername() + ":"
@@ -455,7 +550,7 @@ func (a *App) addTextMessage(msg string) {
 		msgColor = color.NRGBA{R: 0, G: 100, B: 0, A: 255} // Dark green
 	} else if strings.HasPrefix(msg, "(System)") {
 		msgColor = color.NRGBA{R: 150, G: 0, B: 0, A: 255} // Red for system
-	} else if strings.HasPrefix(msg, "(Global)") {
+	} else if strings.HasPrefix(msg, "(Global)") || strings.HasPrefix(msg, "(Thread)") {
 		msgColor = yahooBlue // Yahoo blue
 	} else if strings.HasPrefix(msg, "(Private)") {
 		msgColor = color.NRGBA{R: 150, G: 0, B: 150, A: 255} // Purple
@@ -466,10 +561,7 @@ func (a *App) addTextMessage(msg string) {
 	msgText := canvas.NewText(displayMsg, msgColor)
 	msgText.TextSize = 13
 	msgText.Alignment = fyne.TextAlignLeading
-
-	a.messageList.Add(msgText)
-	a.messagesScroll.Refresh()
-	a.messagesScroll.ScrollToBottom()
+	return msgText
 }
 
 func (a *App) downloadFile(filename string) {
@@ -499,14 +591,8 @@ func (a *App) sendMessage(content string) {
 		return
 	}
 
-	if strings.HasPrefix(raw, "/w ") {
-		text := strings.TrimSpace(raw)
-		parts := strings.SplitN(text, " ", 3)
-		if len(parts) < 3 {
-			dialog.ShowError(fmt.Errorf("invalid whisper format. use: /w username message"), a.mainWindow)
-			return
-		}
-		err = a.client.SendPrivateMessage(parts[1], parts[2])
+	if client.IsCommand(raw) {
+		err = a.client.Commands().Execute(a.client, a, raw)
 	} else {
 		text := strings.TrimSpace(raw)
 		err = a.client.SendMessage(text)
@@ -604,6 +690,10 @@ func (a *App) showFilePicker() {
 						dialog.ShowError(fmt.Errorf("failed to send file: %v", sendErr), a.mainWindow)
 						return
 					}
+					if a.client.ConnectionState() != client.StateConnected {
+						// Queued in the outbox; its status shows in the chat.
+						return
+					}
 
 					fyne.CurrentApp().SendNotification(&fyne.Notification{
 						Title:   "File Sent",
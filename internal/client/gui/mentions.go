@@ -0,0 +1,62 @@
+package gui
+
+import (
+	"chatroom/internal/client"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+func (a *App) sendNotification(title, content string) {
+	fyne.CurrentApp().SendNotification(&fyne.Notification{
+		Title:   title,
+		Content: content,
+	})
+}
+
+// notifyChat raises a desktop notification for messages that mention us and
+// for private messages. Everything else only notifies when the user asked
+// for it.
+func (a *App) notifyChat(msg *client.ChatMessage) {
+	if msg.Outgoing {
+		return
+	}
+	switch {
+	case msg.Mentioned:
+		a.sendNotification(msg.From+" mentioned you", msg.Content)
+	case msg.Private:
+		a.sendNotification("Private message from "+msg.From, msg.Content)
+	case a.notifyAll:
+		a.sendNotification("New Message", msg.String())
+	}
+}
+
+// showMentions lists the recent messages that mentioned us. Selecting a
+// thread reply opens its thread.
+func (a *App) showMentions() {
+	mentions := a.client.Mentions()
+	if len(mentions) == 0 {
+		dialog.ShowInformation("Mentions", "Nobody has mentioned you yet.", a.mainWindow)
+		return
+	}
+
+	var d dialog.Dialog
+	list := widget.NewList(
+		func() int { return len(mentions) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			obj.(*widget.Label).SetText(mentions[id].String())
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		if parentID := mentions[id].ParentID; parentID != "" {
+			d.Hide()
+			a.openThread(parentID)
+		}
+	}
+
+	d = dialog.NewCustom("Mentions", "Close", list, a.mainWindow)
+	d.Resize(fyne.NewSize(500, 350))
+	d.Show()
+}
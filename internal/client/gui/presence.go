@@ -0,0 +1,162 @@
+package gui
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+	"time"
+
+	"chatroom/internal/shared"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// typingTimeout is how long a "typing" line stays up without a new notice.
+const typingTimeout = 4 * time.Second
+
+var statusOptions = map[string]shared.PresenceStatus{
+	"Online": shared.StatusOnline,
+	"Away":   shared.StatusAway,
+	"Busy":   shared.StatusBusy,
+}
+
+func presenceColor(status shared.PresenceStatus) color.Color {
+	switch status {
+	case shared.StatusAway:
+		return color.NRGBA{R: 240, G: 180, B: 0, A: 255} // Amber
+	case shared.StatusBusy:
+		return color.NRGBA{R: 200, G: 0, B: 0, A: 255} // Red
+	default:
+		return color.NRGBA{R: 0, G: 200, B: 0, A: 255} // Green
+	}
+}
+
+func presenceText(p shared.Presence) string {
+	if p.Status == "" || p.Status == shared.StatusOnline {
+		return p.StatusText
+	}
+	if p.StatusText == "" {
+		return string(p.Status)
+	}
+	return fmt.Sprintf("%s - %s", p.Status, p.StatusText)
+}
+
+// createStatusBox builds the status selector shown under the buddy list.
+func (a *App) createStatusBox() fyne.CanvasObject {
+	statusText := widget.NewEntry()
+	statusText.SetPlaceHolder("What's on your mind?")
+
+	statusSelect := widget.NewSelect([]string{"Online", "Away", "Busy"}, nil)
+	statusSelect.SetSelected("Online")
+
+	apply := func() {
+		if !a.connected {
+			return
+		}
+		status := statusOptions[statusSelect.Selected]
+		if err := a.client.SetStatus(status, statusText.Text); err != nil {
+			dialog.ShowError(err, a.mainWindow)
+		}
+	}
+	statusSelect.OnChanged = func(string) { apply() }
+	statusText.OnSubmitted = func(string) { apply() }
+
+	prefs := fyne.CurrentApp().Preferences()
+	a.client.SetReadReceipts(prefs.BoolWithFallback("readReceipts", true))
+	receipts := widget.NewCheck("Send read receipts", func(on bool) {
+		prefs.SetBool("readReceipts", on)
+		a.client.SetReadReceipts(on)
+	})
+	receipts.SetChecked(a.client.ReadReceiptsEnabled())
+
+	a.notifyAll = prefs.BoolWithFallback("notifyAll", false)
+	notifyAll := widget.NewCheck("Notify on every message", func(on bool) {
+		prefs.SetBool("notifyAll", on)
+		a.notifyAll = on
+	})
+	notifyAll.SetChecked(a.notifyAll)
+
+	return container.NewVBox(widget.NewSeparator(), statusSelect, statusText, receipts, notifyAll)
+}
+
+// notifyTyping is hooked to the input box and tells the server we are typing,
+// either in the room or, for "/w user ..." drafts, to that user only.
+func (a *App) notifyTyping(text string) {
+	if !a.connected || strings.TrimSpace(text) == "" {
+		return
+	}
+
+	target := ""
+	if strings.HasPrefix(text, "/w ") {
+		parts := strings.SplitN(strings.TrimSpace(text), " ", 3)
+		if len(parts) < 3 {
+			return
+		}
+		target = parts[1]
+	} else if strings.HasPrefix(text, "/") {
+		return
+	}
+
+	go a.client.SendTyping(target)
+}
+
+func (a *App) handleTyping(from, to string) {
+	key := from
+	if to != "" {
+		key = from + " (private)"
+	}
+
+	a.typingMu.Lock()
+	a.typing[key] = time.Now()
+	a.typingMu.Unlock()
+
+	a.refreshTyping()
+}
+
+func (a *App) expireTyping() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired := false
+		a.typingMu.Lock()
+		for key, last := range a.typing {
+			if time.Since(last) > typingTimeout {
+				delete(a.typing, key)
+				expired = true
+			}
+		}
+		a.typingMu.Unlock()
+
+		if expired {
+			a.refreshTyping()
+		}
+	}
+}
+
+func (a *App) refreshTyping() {
+	if a.typingLabel == nil {
+		return
+	}
+
+	a.typingMu.Lock()
+	names := make([]string, 0, len(a.typing))
+	for key := range a.typing {
+		names = append(names, key)
+	}
+	a.typingMu.Unlock()
+	sort.Strings(names)
+
+	switch len(names) {
+	case 0:
+		a.typingLabel.SetText("")
+	case 1:
+		a.typingLabel.SetText(names[0] + " is typing…")
+	default:
+		a.typingLabel.SetText(strings.Join(names, ", ") + " are typing…")
+	}
+}
@@ -0,0 +1,151 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+
+	"chatroom/internal/client"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// createThreadPanel builds the side panel that shows one thread next to the
+// conversation. It stays hidden until a thread is opened.
+func (a *App) createThreadPanel() fyne.CanvasObject {
+	a.threadList = container.NewVBox()
+	a.threadScroll = container.NewVScroll(a.threadList)
+
+	a.threadInput = newCustomEntry()
+	a.threadInput.SetPlaceHolder("Reply in thread...")
+	a.threadInput.onEnterPressed = a.sendThreadReply
+
+	replyBtn := widget.NewButton("Reply", a.sendThreadReply)
+	replyBtn.Importance = widget.HighImportance
+
+	closeBtn := widget.NewButtonWithIcon("", theme.CancelIcon(), a.closeThread)
+	closeBtn.Importance = widget.LowImportance
+
+	header := container.NewBorder(nil, nil, nil, closeBtn, widget.NewLabelWithStyle("Thread", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	inputBox := container.NewBorder(nil, nil, nil, replyBtn, a.threadInput)
+
+	width := canvas.NewRectangle(lightGray)
+	width.SetMinSize(fyne.NewSize(260, 0))
+
+	a.threadPanel = container.NewStack(
+		width,
+		createYahooBox(container.NewBorder(header, inputBox, nil, nil, a.threadScroll), "", lightGray),
+	)
+	a.threadPanel.Hide()
+	return a.threadPanel
+}
+
+// openThread shows the thread panel for parentID and asks the server for the
+// replies.
+func (a *App) openThread(parentID string) {
+	a.rowsMu.Lock()
+	a.threadParent = parentID
+	a.threadRows = make(map[string]*chatRow)
+	a.rowsMu.Unlock()
+
+	a.threadList.Objects = nil
+	a.threadList.Add(widget.NewLabel("Loading..."))
+	a.threadPanel.Show()
+	a.threadPanel.Refresh()
+
+	if err := a.client.FetchThread(parentID); err != nil {
+		dialog.ShowError(fmt.Errorf("failed to load thread: %v", err), a.mainWindow)
+	}
+}
+
+func (a *App) closeThread() {
+	a.rowsMu.Lock()
+	a.threadParent = ""
+	a.threadRows = make(map[string]*chatRow)
+	a.rowsMu.Unlock()
+
+	a.threadList.Objects = nil
+	a.threadPanel.Hide()
+}
+
+// showThread fills the panel with a thread the server sent. Threads that are
+// no longer open are ignored.
+func (a *App) showThread(parentID string, msgs []*client.ChatMessage) {
+	a.rowsMu.Lock()
+	open := a.threadParent == parentID
+	a.rowsMu.Unlock()
+	if !open {
+		return
+	}
+
+	a.threadList.Objects = nil
+	for i, msg := range msgs {
+		_, obj := a.newChatRow(msg, a.threadRows)
+		a.threadList.Add(obj)
+		if i == 0 {
+			a.threadList.Add(widget.NewSeparator())
+		}
+	}
+	a.threadList.Refresh()
+	a.threadScroll.ScrollToBottom()
+}
+
+// addThreadReply appends a new reply to the open thread. Replies to other
+// threads only update the reply count of their parent.
+func (a *App) addThreadReply(msg *client.ChatMessage) {
+	a.rowsMu.Lock()
+	open := a.threadParent == msg.ParentID
+	_, seen := a.threadRows[msg.ID]
+	a.rowsMu.Unlock()
+	if !open || seen {
+		return
+	}
+
+	_, obj := a.newChatRow(msg, a.threadRows)
+	a.threadList.Add(obj)
+	a.threadScroll.ScrollToBottom()
+}
+
+func (a *App) sendThreadReply() {
+	a.rowsMu.Lock()
+	parentID := a.threadParent
+	a.rowsMu.Unlock()
+
+	content := strings.TrimSpace(ConvertEmojis(a.threadInput.Text))
+	if parentID == "" || content == "" {
+		return
+	}
+	if err := a.client.SendReply(parentID, content); err != nil {
+		dialog.ShowError(err, a.mainWindow)
+		return
+	}
+	a.threadInput.SetText("")
+}
+
+// renderReplyCount updates the "replies" link under a conversation message.
+func (a *App) renderReplyCount(row *chatRow) {
+	if row.replies == nil {
+		return
+	}
+	if row.msg.ReplyCount == 0 {
+		row.replies.Hide()
+		return
+	}
+	if row.msg.ReplyCount == 1 {
+		row.replies.SetText("💬 1 reply")
+	} else {
+		row.replies.SetText(fmt.Sprintf("💬 %d replies", row.msg.ReplyCount))
+	}
+	row.replies.Show()
+}
+
+// quoteMessage puts a quoted copy of msg in front of whatever is typed in
+// the message box.
+func (a *App) quoteMessage(msg *client.ChatMessage) {
+	a.input.SetText(client.Quote(msg) + a.input.Text)
+	a.mainWindow.Canvas().Focus(a.input)
+}
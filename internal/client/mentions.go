@@ -0,0 +1,73 @@
+package client
+
+import (
+	"regexp"
+	"strings"
+)
+
+// mentionInboxSize is how many recent mentions are kept for the inbox.
+const mentionInboxSize = 50
+
+// mentionPattern matches "@name" at the start of the text or after a
+// character that cannot be part of a word or e-mail address.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w][\w.\-]*)`)
+
+// ParseMentions returns the lowercased usernames mentioned in content, in
+// order of first appearance. Messages are end-to-end encrypted, so this
+// always runs on the decrypted text.
+func ParseMentions(content string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(strings.TrimRight(m[1], ".-"))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// Mentions returns the recent messages that mentioned us, newest first.
+func (c *Client) Mentions() []*ChatMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := make([]*ChatMessage, 0, len(c.mentions))
+	for i := len(c.mentions) - 1; i >= 0; i-- {
+		cp := *c.mentions[i]
+		list = append(list, &cp)
+	}
+	return list
+}
+
+// tagMentions fills in the mentions of msg and reports whether we are one of
+// them. Our own messages never count as mentioning us.
+func (c *Client) tagMentions(msg *ChatMessage) bool {
+	msg.Mentions = ParseMentions(msg.Content)
+	msg.Mentioned = false
+	if msg.Outgoing || msg.Deleted {
+		return false
+	}
+	me := strings.ToLower(c.username)
+	for _, name := range msg.Mentions {
+		if name == me {
+			msg.Mentioned = true
+			break
+		}
+	}
+	return msg.Mentioned
+}
+
+func (c *Client) addMention(msg *ChatMessage) {
+	c.mu.Lock()
+	cp := *msg
+	c.mentions = append(c.mentions, &cp)
+	if len(c.mentions) > mentionInboxSize {
+		c.mentions = append([]*ChatMessage(nil), c.mentions[len(c.mentions)-mentionInboxSize:]...)
+	}
+	c.mu.Unlock()
+
+	c.emit(MentionEvent{Message: &cp})
+}
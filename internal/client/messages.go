@@ -0,0 +1,289 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+// ChatMessage is a decrypted public or private chat line.
+type ChatMessage struct {
+	ID         string
+	From       string
+	To         string // recipient of a private message
+	Content    string
+	Timestamp  time.Time
+	Private    bool
+	Outgoing   bool // sent by us
+	Edited     bool
+	Deleted    bool
+	Reactions  map[string][]string // emoji -> usernames
+	ParentID   string              // thread parent of a reply
+	ReplyCount int
+	Mentions   []string   // lowercased usernames mentioned in Content
+	Mentioned  bool       // Content mentions us
+	Send       SendStatus // of an outgoing message
+}
+
+// String formats the message the way it is shown in the chat log.
+func (m *ChatMessage) String() string {
+	ts := m.Timestamp.Format("15:04:05")
+	content := m.Content
+	if m.Deleted {
+		content = "message deleted"
+	} else if m.Edited {
+		content += " (edited)"
+	}
+	switch m.Send {
+	case SendPending:
+		content += " (pending)"
+	case SendFailed:
+		content += " (not sent)"
+	}
+
+	switch {
+	case m.Private && m.Outgoing:
+		return fmt.Sprintf("(Private to %s) (You) (%s): %s", m.To, ts, content)
+	case m.Private:
+		return fmt.Sprintf("(Private) (%s) %s: %s", ts, m.From, content)
+	case m.Outgoing:
+		return fmt.Sprintf("%s (You) (%s): %s", m.channel(), ts, content)
+	default:
+		return fmt.Sprintf("%s (%s) %s: %s", m.channel(), ts, m.From, content)
+	}
+}
+
+func (m *ChatMessage) channel() string {
+	if m.ParentID != "" {
+		return "(Thread)"
+	}
+	return "(Global)"
+}
+
+// markSeen records id as the last chat message received, from which the
+// server replays missed messages after a reconnect. It reports false if the
+// message was shown already, as a replay may repeat messages.
+func (c *Client) markSeen(id string) bool {
+	if id == "" {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, shown := c.messages[id]; shown {
+		return false
+	}
+	c.lastSeen = id
+	return true
+}
+
+func (c *Client) displayChat(msg *ChatMessage) {
+	if c.tagMentions(msg) {
+		c.addMention(msg)
+	}
+
+	if msg.ID != "" {
+		c.mu.Lock()
+		stored := *msg
+		c.messages[msg.ID] = &stored
+		c.mu.Unlock()
+	}
+
+	if msg.Private {
+		c.emit(PrivateMessageEvent{Message: msg})
+	} else {
+		c.emit(PublicMessageEvent{Message: msg})
+	}
+}
+
+func (c *Client) GetChatMessage(id string) (*ChatMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg, ok := c.messages[id]
+	if !ok {
+		return nil, false
+	}
+	cp := *msg
+	return &cp, true
+}
+
+// CanModify reports whether we may edit or delete the message: our own
+// messages always, other public messages only as an admin.
+func (c *Client) CanModify(id string) bool {
+	msg, ok := c.GetChatMessage(id)
+	if !ok || msg.Deleted {
+		return false
+	}
+	return msg.Outgoing || (!msg.Private && c.IsAdmin())
+}
+
+// EditMessage replaces the content of an earlier message. The new content is
+// encrypted the same way as the original: with the room key for public
+// messages and with the recipient's key for private ones.
+func (c *Client) EditMessage(id, content string) error {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return fmt.Errorf("message cannot be empty")
+	}
+	original, ok := c.GetChatMessage(id)
+	if !ok {
+		return fmt.Errorf("unknown message %s", id)
+	}
+	if !c.CanModify(id) {
+		return fmt.Errorf("you cannot edit this message")
+	}
+
+	msg := &shared.Message{
+		Type:      shared.TypeEdit,
+		From:      c.username,
+		RefID:     id,
+		Timestamp: time.Now(),
+	}
+
+	if original.Private {
+		pub, exists := c.PublicKeyCache.Get(original.To)
+		if !exists {
+			return fmt.Errorf("no public key for %s", original.To)
+		}
+		encKeyB64, encDataB64, err := shared.Encrypt(content, pub)
+		if err != nil {
+			return err
+		}
+		msg.To = original.To
+		msg.EncryptedKey = encKeyB64
+		msg.Content = encDataB64
+	} else {
+		_, encDataB64, err := shared.EncryptWithRoomKey(content, c.getRoomKey())
+		if err != nil {
+			return err
+		}
+		msg.EncryptedData = encDataB64
+	}
+
+	if err := c.connection().Send(msg); err != nil {
+		return err
+	}
+
+	c.applyUpdate(id, func(m *ChatMessage) {
+		m.Content = content
+		m.Edited = true
+		c.tagMentions(m)
+	})
+	return nil
+}
+
+func (c *Client) DeleteMessage(id string) error {
+	if !c.CanModify(id) {
+		return fmt.Errorf("you cannot delete this message")
+	}
+
+	msg := &shared.Message{
+		Type:      shared.TypeDelete,
+		From:      c.username,
+		RefID:     id,
+		Timestamp: time.Now(),
+	}
+	if err := c.connection().Send(msg); err != nil {
+		return err
+	}
+
+	c.applyUpdate(id, func(m *ChatMessage) {
+		m.Content = ""
+		m.Deleted = true
+	})
+	return nil
+}
+
+// ToggleReaction adds our reaction to a message, or removes it if we had
+// already reacted with the same emoji.
+func (c *Client) ToggleReaction(id, emoji string) error {
+	msg, ok := c.GetChatMessage(id)
+	if !ok {
+		return fmt.Errorf("unknown message %s", id)
+	}
+	for _, name := range msg.Reactions[emoji] {
+		if name == c.username {
+			return c.sendReaction(shared.TypeReactionRemove, id, emoji)
+		}
+	}
+	return c.sendReaction(shared.TypeReactionAdd, id, emoji)
+}
+
+func (c *Client) AddReaction(id, emoji string) error {
+	return c.sendReaction(shared.TypeReactionAdd, id, emoji)
+}
+
+func (c *Client) RemoveReaction(id, emoji string) error {
+	return c.sendReaction(shared.TypeReactionRemove, id, emoji)
+}
+
+func (c *Client) sendReaction(kind shared.MessageType, id, emoji string) error {
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" {
+		return fmt.Errorf("reaction cannot be empty")
+	}
+	msg := &shared.Message{
+		Type:      kind,
+		From:      c.username,
+		RefID:     id,
+		Content:   emoji,
+		Timestamp: time.Now(),
+	}
+	return c.connection().Send(msg)
+}
+
+func (c *Client) handleReactions(msg *shared.Message) {
+	c.applyUpdate(msg.RefID, func(m *ChatMessage) {
+		m.Reactions = msg.Reactions
+	})
+}
+
+func (c *Client) handleMessageUpdate(msg *shared.Message) {
+	if msg.Type == shared.TypeDelete {
+		c.applyUpdate(msg.RefID, func(m *ChatMessage) {
+			m.Content = ""
+			m.Deleted = true
+		})
+		return
+	}
+	if msg.EncryptedData == "" && msg.Content == "" {
+		// Our own private edit coming back; we already have the new text.
+		c.applyUpdate(msg.RefID, func(m *ChatMessage) {
+			m.Edited = true
+		})
+		return
+	}
+
+	var plain []byte
+	var err error
+	if msg.EncryptedData != "" {
+		plain, err = shared.DecryptWithRoomKey(msg.EncryptedData, c.getRoomKey())
+	} else {
+		plain, err = shared.Decrypt(msg.EncryptedKey, msg.Content, c.privateKey)
+	}
+	if err != nil {
+		log.Warn("Failed to decrypt edited message", "id", msg.RefID, "err", err)
+		return
+	}
+
+	c.applyUpdate(msg.RefID, func(m *ChatMessage) {
+		m.Content = string(plain)
+		m.Edited = true
+		c.tagMentions(m)
+	})
+}
+
+func (c *Client) applyUpdate(id string, fn func(m *ChatMessage)) {
+	c.mu.Lock()
+	stored, ok := c.messages[id]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	fn(stored)
+	updated := *stored
+	c.mu.Unlock()
+
+	c.emit(MessageUpdatedEvent{Message: &updated})
+}
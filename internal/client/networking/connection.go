@@ -2,53 +2,75 @@ package networking
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
-	"net"
-	"time"
+	"sync"
 
 	"chatroom/internal/shared"
+	"chatroom/internal/transport"
+	"chatroom/pkg/logger"
 )
 
+var log = logger.New("network")
+
 type Connection struct {
-	address  string
-	conn     net.Conn
-	incoming chan *shared.Message
-	isClosed bool
+	transport transport.Transport
+	address   string
+	conn      transport.Conn
+	incoming  chan *shared.Message
+	isClosed  bool
+	mu        sync.Mutex // guards conn and isClosed
+	writeMu   sync.Mutex // keeps concurrent Sends from interleaving
 }
 
-func NewConnection() *Connection {
+// NewConnection returns an unconnected Connection that dials through t.
+func NewConnection(t transport.Transport) *Connection {
 	return &Connection{
-		incoming: make(chan *shared.Message, 100),
+		transport: t,
+		incoming:  make(chan *shared.Message, 100),
 	}
 }
 
 func (c *Connection) Connect(address string) error {
-	conn, err := net.Dial("tcp", address)
+	return c.ConnectContext(context.Background(), address)
+}
+
+// ConnectContext dials address, giving up when ctx is done.
+func (c *Connection) ConnectContext(ctx context.Context, address string) error {
+	conn, err := c.transport.Dial(ctx, address)
 	if err != nil {
 		return err
 	}
+	c.mu.Lock()
 	c.conn = conn
 	c.address = address
 	c.isClosed = false
+	c.mu.Unlock()
 
-	go c.listen()
+	go c.listen(conn)
 	return nil
 }
 
-func (c *Connection) listen() {
-	reader := bufio.NewReader(c.conn)
+func (c *Connection) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.isClosed
+}
+
+func (c *Connection) listen(conn transport.Conn) {
+	reader := bufio.NewReader(conn)
 	for {
-		if c.isClosed {
+		if c.closed() {
 			return
 		}
 
 		msg, err := shared.ReadMessage(reader)
 		if err != nil {
 			if err == io.EOF {
-				fmt.Println("[INFO] Server closed connection")
+				log.Info("Server closed connection", "addr", c.address)
 			} else {
-				fmt.Println("[WARN] Read error:", err)
+				log.Warn("Read error", "addr", c.address, "err", err)
 			}
 
 			close(c.incoming)
@@ -58,16 +80,21 @@ func (c *Connection) listen() {
 		select {
 		case c.incoming <- msg:
 		default:
-			fmt.Println("[WARN] Incoming channel full, dropping message")
+			log.Warn("Incoming channel full, dropping message", "type", msg.Type)
 		}
 	}
 }
 
 func (c *Connection) Send(msg *shared.Message) error {
-	if c.conn == nil {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
 		return fmt.Errorf("connection inactive")
 	}
-	return shared.WriteMessage(c.conn, msg)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return shared.WriteMessage(conn, msg)
 }
 
 func (c *Connection) Incoming() <-chan *shared.Message {
@@ -75,35 +102,13 @@ func (c *Connection) Incoming() <-chan *shared.Message {
 }
 
 func (c *Connection) Close() error {
+	c.mu.Lock()
+	conn := c.conn
 	c.isClosed = true
-	if c.conn != nil {
-		err := c.conn.Close()
-		c.conn = nil
-		return err
+	c.conn = nil
+	c.mu.Unlock()
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
-
-func (c *Connection) Reconnect() error {
-	if c.address == "" {
-		return fmt.Errorf("no known address to reconnect")
-	}
-
-	for {
-		fmt.Println("[INFO] Attempting to reconnect to", c.address)
-		conn, err := net.Dial("tcp", c.address)
-		if err == nil {
-			fmt.Println("[INFO] Reconnected successfully")
-
-			c.conn = conn
-			c.isClosed = false
-			c.incoming = make(chan *shared.Message, 100)
-
-			go c.listen()
-			return nil
-		}
-
-		fmt.Println("[ERROR] Reconnect failed:", err)
-		time.Sleep(5 * time.Second)
-	}
-}
@@ -0,0 +1,403 @@
+package client
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+// outboxDir holds one file per user with the messages and files waiting to
+// be sent. The files hold unsent text in the clear, so they are only
+// readable by their owner and are removed once empty.
+const outboxDir = "outbox"
+
+// keyWait is how long a queued private message or file waits for the
+// recipient's public key before it fails.
+const keyWait = 10 * time.Second
+
+// errOffline marks a send that failed for want of a connection; the item
+// stays in the outbox.
+var errOffline = errors.New("not connected")
+
+// SendStatus tells whether an outgoing message or file has left the client.
+type SendStatus int
+
+const (
+	SendSent    SendStatus = iota
+	SendPending            // queued in the outbox until the connection is back
+	SendFailed             // dropped from the outbox; see SendStatusEvent.Err
+)
+
+type outboxKind string
+
+const (
+	outboxPublic      outboxKind = "public"
+	outboxPrivate     outboxKind = "private"
+	outboxFile        outboxKind = "file"
+	outboxPrivateFile outboxKind = "private_file"
+)
+
+// outboxItem is one message or file waiting to be sent.
+type outboxItem struct {
+	ID       string     `json:"id"`
+	Kind     outboxKind `json:"kind"`
+	To       string     `json:"to,omitempty"`
+	ParentID string     `json:"parent_id,omitempty"`
+	Text     string     `json:"text,omitempty"`
+	Path     string     `json:"path,omitempty"` // file to send
+	Queued   time.Time  `json:"queued"`
+
+	// memOnly marks an item queued while online, only waiting for a
+	// public key or for older items. It is not written to disk unless the
+	// connection drops before it is sent.
+	memOnly bool
+}
+
+func (it *outboxItem) chatMessage(from string, status SendStatus) *ChatMessage {
+	return &ChatMessage{
+		ID:        it.ID,
+		ParentID:  it.ParentID,
+		From:      from,
+		To:        it.To,
+		Content:   it.Text,
+		Timestamp: it.Queued,
+		Private:   it.Kind == outboxPrivate,
+		Outgoing:  true,
+		Send:      status,
+	}
+}
+
+// outbox keeps the items waiting to be sent, oldest first, and saves those
+// queued while offline after every change.
+type outbox struct {
+	mu       sync.Mutex
+	path     string // empty until the user is known
+	items    []*outboxItem
+	flushing bool
+}
+
+// queue adds it if force is set or older items are still waiting, so that
+// nothing overtakes them. It reports whether it did.
+func (o *outbox) queue(it *outboxItem, force bool) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !force && len(o.items) == 0 {
+		return false
+	}
+	o.items = append(o.items, it)
+	o.saveLocked()
+	return true
+}
+
+// startFlush reports whether the caller should start flushing: there is
+// something to send and nobody else is sending it.
+func (o *outbox) startFlush() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.flushing || len(o.items) == 0 {
+		return false
+	}
+	o.flushing = true
+	return true
+}
+
+// next returns the oldest item, or nil when the outbox is empty, which ends
+// the flush.
+func (o *outbox) next() *outboxItem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.items) == 0 {
+		o.flushing = false
+		return nil
+	}
+	return o.items[0]
+}
+
+func (o *outbox) stopFlush() {
+	o.mu.Lock()
+	o.flushing = false
+	o.mu.Unlock()
+}
+
+func (o *outbox) remove(it *outboxItem) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, queued := range o.items {
+		if queued == it {
+			o.items = append(o.items[:i], o.items[i+1:]...)
+			break
+		}
+	}
+	o.saveLocked()
+}
+
+// persist writes the items kept in memory to disk as well, for when the
+// connection dropped while they were waiting.
+func (o *outbox) persist() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, it := range o.items {
+		it.memOnly = false
+	}
+	o.saveLocked()
+}
+
+// load switches the outbox to path, keeping what was queued so far, and
+// returns the items saved there by an earlier run.
+func (o *outbox) load(path string) ([]*outboxItem, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var saved []*outboxItem
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("outbox %s: %v", path, err)
+		}
+	}
+	o.path = path
+	o.items = append(saved, o.items...)
+	o.saveLocked()
+	return saved, nil
+}
+
+// saveLocked writes the outbox to disk, leaving out the items kept in
+// memory. Callers must hold o.mu.
+func (o *outbox) saveLocked() {
+	if o.path == "" {
+		return
+	}
+	var items []*outboxItem
+	for _, it := range o.items {
+		if !it.memOnly {
+			items = append(items, it)
+		}
+	}
+	if len(items) == 0 {
+		if err := os.Remove(o.path); err != nil && !os.IsNotExist(err) {
+			log.Error("Failed to remove outbox", "file", o.path, "err", err)
+		}
+		return
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		log.Error("Failed to encode outbox", "err", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0700); err != nil {
+		log.Error("Failed to save outbox", "err", err)
+		return
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Error("Failed to save outbox", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		log.Error("Failed to save outbox", "err", err)
+	}
+}
+
+// outboxPath is the outbox file of username.
+func outboxPath(username string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, username)
+	return filepath.Join(outboxDir, name+".json")
+}
+
+// loadOutbox opens the outbox of the logged in user and shows what an
+// earlier run left in it as pending.
+func (c *Client) loadOutbox() {
+	saved, err := c.outbox.load(outboxPath(c.username))
+	if err != nil {
+		log.Error("Failed to load outbox", "err", err)
+		return
+	}
+	for _, it := range saved {
+		c.showQueued(it)
+	}
+	if len(saved) > 0 {
+		log.Info("Loaded outbox", "items", len(saved))
+	}
+}
+
+// submit sends it, or queues it in the outbox if the client is offline or
+// older items are still waiting. A private item whose recipient's public key
+// is not known yet is queued too, so the caller does not wait for the key.
+// Only items queued while offline are saved to disk; the others hold
+// plaintext that should not outlive the process.
+func (c *Client) submit(it *outboxItem) error {
+	if it.Path != "" {
+		// A later run may start in another directory.
+		if abs, err := filepath.Abs(it.Path); err == nil {
+			it.Path = abs
+		}
+	}
+	online := c.ConnectionState() == StateConnected
+	it.memOnly = online
+	mustQueue := !online
+	if it.To != "" {
+		if _, ok := c.PublicKeyCache.Get(it.To); !ok {
+			mustQueue = true
+		}
+	}
+	if c.outbox.queue(it, mustQueue) {
+		c.showQueued(it)
+		if c.ConnectionState() == StateConnected {
+			c.flushOutbox()
+		}
+		return nil
+	}
+
+	if it.Kind == outboxPublic || it.Kind == outboxPrivate {
+		c.displayChat(it.chatMessage(c.username, SendSent))
+	}
+	err := c.transmit(it)
+	if errors.Is(err, errOffline) {
+		// The connection dropped before we noticed.
+		it.memOnly = false
+		c.outbox.queue(it, true)
+		c.setSendStatus(it, SendPending, nil)
+		return nil
+	}
+	if err != nil && (it.Kind == outboxPublic || it.Kind == outboxPrivate) {
+		c.setSendStatus(it, SendFailed, err)
+	}
+	return err
+}
+
+// showQueued shows a queued item as pending.
+func (c *Client) showQueued(it *outboxItem) {
+	if it.Kind == outboxPublic || it.Kind == outboxPrivate {
+		c.displayChat(it.chatMessage(c.username, SendPending))
+	}
+	c.emit(newSendStatusEvent(it, SendPending, nil))
+}
+
+// setSendStatus records the status of a chat message and reports it.
+func (c *Client) setSendStatus(it *outboxItem, status SendStatus, err error) {
+	c.mu.Lock()
+	if msg, ok := c.messages[it.ID]; ok {
+		msg.Send = status
+	}
+	c.mu.Unlock()
+	c.emit(newSendStatusEvent(it, status, err))
+}
+
+// flushOutbox starts sending the outbox, unless it is empty or already
+// being sent. It runs whenever a user list arrives, which the server sends
+// right after every login.
+func (c *Client) flushOutbox() {
+	if c.outbox.startFlush() {
+		go c.runFlush()
+	}
+}
+
+func (c *Client) runFlush() {
+	<-c.roomKeyReady
+	for {
+		it := c.outbox.next()
+		if it == nil {
+			return
+		}
+		var err error
+		if it.To != "" && !c.UserExists(it.To) {
+			// The server would refuse it without saying which message
+			// it refused.
+			err = fmt.Errorf("%s is not online", it.To)
+		} else {
+			err = c.transmit(it)
+		}
+		if errors.Is(err, errOffline) {
+			c.outbox.persist()
+			c.outbox.stopFlush()
+			return
+		}
+		// Report before removing, so whoever sees the outbox empty has
+		// been told about every item in it.
+		if err != nil {
+			log.Warn("Dropped message from outbox", "id", it.ID, "kind", string(it.Kind), "err", err)
+			c.setSendStatus(it, SendFailed, err)
+		} else {
+			c.setSendStatus(it, SendSent, nil)
+		}
+		c.outbox.remove(it)
+	}
+}
+
+// Unsent returns how many messages and files are waiting in the outbox.
+func (c *Client) Unsent() int {
+	c.outbox.mu.Lock()
+	defer c.outbox.mu.Unlock()
+	return len(c.outbox.items)
+}
+
+// transmit sends it now. Errors wrapping errOffline mean it should be
+// retried once the connection is back; others mean it cannot be sent.
+func (c *Client) transmit(it *outboxItem) error {
+	if c.ConnectionState() != StateConnected {
+		return errOffline
+	}
+	switch it.Kind {
+	case outboxPublic:
+		return c.transmitPublic(it)
+	case outboxFile:
+		return c.transmitFile(it)
+	}
+
+	key, err := c.waitPublicKey(it.To)
+	if err != nil {
+		return err
+	}
+	if it.Kind == outboxPrivate {
+		return c.transmitPrivate(it, key)
+	}
+	return c.transmitPrivateFile(it, key)
+}
+
+// waitPublicKey returns the public key of username, asking the server for it
+// and waiting up to keyWait if it is not known yet.
+func (c *Client) waitPublicKey(username string) (*rsa.PublicKey, error) {
+	if key, ok := c.PublicKeyCache.Get(username); ok {
+		return key, nil
+	}
+	req := &shared.Message{
+		Type: shared.TypePublicKeyRequest,
+		From: c.username,
+		To:   username,
+	}
+	if err := c.send(req); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(keyWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		if key, ok := c.PublicKeyCache.Get(username); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no public key for %s", username)
+}
+
+// send writes msg, marking a failure as errOffline.
+func (c *Client) send(msg *shared.Message) error {
+	if err := c.connection().Send(msg); err != nil {
+		return fmt.Errorf("%w: %v", errOffline, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+// typingInterval limits how often typing notices are sent per target.
+const typingInterval = 3 * time.Second
+
+func (c *Client) SetStatus(status shared.PresenceStatus, text string) error {
+	if !status.IsValid() {
+		return fmt.Errorf("invalid status %q", status)
+	}
+	msg := &shared.Message{
+		Type:      shared.TypeStatus,
+		From:      c.username,
+		Status:    status,
+		Content:   strings.TrimSpace(text),
+		Timestamp: time.Now(),
+	}
+	return c.connection().Send(msg)
+}
+
+// SetNick sets the display nickname shown next to our username. An empty
+// nick clears it.
+func (c *Client) SetNick(nick string) error {
+	msg := &shared.Message{
+		Type:      shared.TypeNick,
+		From:      c.username,
+		Content:   strings.TrimSpace(nick),
+		Timestamp: time.Now(),
+	}
+	return c.connection().Send(msg)
+}
+
+// SendTyping tells target (or the whole room if target is empty) that we are
+// typing. Calls made within typingInterval of the previous one are ignored.
+func (c *Client) SendTyping(target string) error {
+	target = strings.TrimSpace(target)
+
+	c.mu.Lock()
+	if last, ok := c.lastTypingSent[target]; ok && time.Since(last) < typingInterval {
+		c.mu.Unlock()
+		return nil
+	}
+	c.lastTypingSent[target] = time.Now()
+	c.mu.Unlock()
+
+	msg := &shared.Message{
+		Type:      shared.TypeTyping,
+		From:      c.username,
+		To:        target,
+		Timestamp: time.Now(),
+	}
+	return c.connection().Send(msg)
+}
+
+func (c *Client) GetPresence(username string) (shared.Presence, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.presence[username]
+	return p, ok
+}
+
+func (c *Client) updatePresence(list []shared.Presence) {
+	if list == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.presence = make(map[string]shared.Presence, len(list))
+	for _, p := range list {
+		c.presence[p.Username] = p
+	}
+	c.mu.Unlock()
+
+	c.emit(PresenceEvent{Presence: list})
+}
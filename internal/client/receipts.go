@@ -0,0 +1,75 @@
+package client
+
+import (
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+type ReceiptStatus int
+
+const (
+	ReceiptSent ReceiptStatus = iota
+	ReceiptDelivered
+	ReceiptRead
+)
+
+// SetReadReceipts turns delivered and read receipts for incoming private
+// messages on or off.
+func (c *Client) SetReadReceipts(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readReceipts = enabled
+}
+
+func (c *Client) ReadReceiptsEnabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readReceipts
+}
+
+// MarkRead sends a read receipt for an incoming private message once it has
+// been shown to the user.
+func (c *Client) MarkRead(msg *ChatMessage) error {
+	if msg == nil || !msg.Private || msg.Outgoing || msg.ID == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	if !c.readReceipts || c.readSent[msg.ID] {
+		c.mu.Unlock()
+		return nil
+	}
+	c.readSent[msg.ID] = true
+	c.mu.Unlock()
+
+	return c.sendReceipt(shared.TypeReadReceipt, msg.From, msg.ID)
+}
+
+func (c *Client) sendDeliveredReceipt(msg *shared.Message) {
+	if msg.ID == "" || !c.ReadReceiptsEnabled() {
+		return
+	}
+	if err := c.sendReceipt(shared.TypeDeliveredReceipt, msg.From, msg.ID); err != nil {
+		c.emit(ErrorEvent{Text: "Failed to send delivery receipt: " + err.Error()})
+	}
+}
+
+func (c *Client) sendReceipt(kind shared.MessageType, to, id string) error {
+	msg := &shared.Message{
+		Type:      kind,
+		From:      c.username,
+		To:        to,
+		RefID:     id,
+		Timestamp: time.Now(),
+	}
+	return c.connection().Send(msg)
+}
+
+func (c *Client) handleReceipt(msg *shared.Message) {
+	status := ReceiptDelivered
+	if msg.Type == shared.TypeReadReceipt {
+		status = ReceiptRead
+	}
+	c.emit(ReceiptEvent{ID: msg.RefID, Status: status})
+}
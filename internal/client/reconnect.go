@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ConnState is the state of the connection to the server.
+type ConnState int
+
+const (
+	StateOffline ConnState = iota // not connected and not trying to be
+	StateConnected
+	StateReconnecting // the connection dropped and the client is trying to get it back
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	default:
+		return "offline"
+	}
+}
+
+// ReconnectPolicy sets how long the client waits between reconnect
+// attempts. The first attempt is made right away; after each failure the
+// wait doubles from Initial up to Max. Jitter, between 0 and 1, is the part
+// of each wait chosen at random, so clients dropped together do not all
+// come back at the same moment.
+type ReconnectPolicy struct {
+	Initial time.Duration
+	Max     time.Duration
+	Jitter  float64
+}
+
+var DefaultReconnectPolicy = ReconnectPolicy{
+	Initial: time.Second,
+	Max:     time.Minute,
+	Jitter:  0.2,
+}
+
+// delay returns the wait after failures failed attempts.
+func (p ReconnectPolicy) delay(failures int) time.Duration {
+	d := p.Initial
+	for i := 1; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// reconnector runs the one reconnect loop of a client.
+type reconnector struct {
+	mu     sync.Mutex
+	policy ReconnectPolicy
+	state  ConnState
+	auto   bool               // reconnect when the connection drops
+	cancel context.CancelFunc // stops the running loop, nil if none
+	retry  chan struct{}      // cuts the current wait short
+}
+
+// SetReconnectPolicy replaces DefaultReconnectPolicy.
+func (c *Client) SetReconnectPolicy(p ReconnectPolicy) {
+	c.reconnect.mu.Lock()
+	c.reconnect.policy = p
+	c.reconnect.mu.Unlock()
+}
+
+// ConnectionState returns the current state of the connection.
+func (c *Client) ConnectionState() ConnState {
+	c.reconnect.mu.Lock()
+	defer c.reconnect.mu.Unlock()
+	return c.reconnect.state
+}
+
+// RetryNow makes the next reconnect attempt right away. If the client
+// stopped trying, it starts again.
+func (c *Client) RetryNow() {
+	r := &c.reconnect
+	r.mu.Lock()
+	running, offline := r.cancel != nil, r.state == StateOffline
+	if !running && offline && c.address != "" {
+		r.auto = true
+	}
+	retry := r.retry
+	r.mu.Unlock()
+
+	switch {
+	case running:
+		select {
+		case retry <- struct{}{}:
+		default:
+		}
+	case offline && c.address != "":
+		c.startReconnect(0)
+	}
+}
+
+// StopReconnect gives up reconnecting and leaves the client offline until
+// RetryNow.
+func (c *Client) StopReconnect() {
+	if c.stopReconnect() {
+		c.setConnState(ConnectionEvent{State: StateOffline})
+	}
+}
+
+// setAutoReconnect turns reconnecting after a dropped connection on or off.
+func (c *Client) setAutoReconnect(on bool) {
+	c.reconnect.mu.Lock()
+	c.reconnect.auto = on
+	c.reconnect.mu.Unlock()
+}
+
+// stopReconnect cancels the reconnect loop and reports whether one was
+// running.
+func (c *Client) stopReconnect() bool {
+	r := &c.reconnect
+	r.mu.Lock()
+	cancel := r.cancel
+	r.cancel = nil
+	r.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	return true
+}
+
+// startReconnect starts the reconnect loop unless it already runs. The
+// first attempt is made after firstDelay. It reports false, and does
+// nothing, if automatic reconnects are off.
+func (c *Client) startReconnect(firstDelay time.Duration) bool {
+	r := &c.reconnect
+	r.mu.Lock()
+	if !r.auto {
+		r.mu.Unlock()
+		return false
+	}
+	if r.cancel != nil {
+		r.mu.Unlock()
+		return true
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.retry = make(chan struct{}, 1)
+	policy, retry := r.policy, r.retry
+	r.mu.Unlock()
+
+	go c.reconnectLoop(ctx, policy, retry, firstDelay)
+	return true
+}
+
+func (c *Client) reconnectLoop(ctx context.Context, policy ReconnectPolicy, retry <-chan struct{}, wait time.Duration) {
+	for attempt := 1; ; attempt++ {
+		c.setConnState(ConnectionEvent{State: StateReconnecting, Attempt: attempt, Retry: wait})
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-retry:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+
+		err := c.handshake(ctx, c.address)
+		if err == nil {
+			log.Info("Reconnected", "attempt", attempt)
+			c.endReconnect(ctx)
+			c.setConnState(ConnectionEvent{State: StateConnected, Connected: true})
+			go c.handleMessages()
+			return
+		}
+		if ctx.Err() != nil {
+			return // stopped
+		}
+		wait = policy.delay(attempt)
+		log.Warn("Reconnect failed", "attempt", attempt, "retry_in", wait.Round(time.Millisecond).String(), "err", err)
+	}
+}
+
+// endReconnect marks the loop of ctx as finished, unless StopReconnect got
+// to it first.
+func (c *Client) endReconnect(ctx context.Context) {
+	r := &c.reconnect
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ctx.Err() == nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
+
+// setConnState records the state of ev and emits it.
+func (c *Client) setConnState(ev ConnectionEvent) {
+	c.reconnect.mu.Lock()
+	c.reconnect.state = ev.State
+	c.reconnect.mu.Unlock()
+	c.emit(ev)
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+// SendReply posts content to the public room as a reply to parentID. Replies
+// to a reply end up in the thread of the original message.
+func (c *Client) SendReply(parentID, content string) error {
+	parent, ok := c.GetChatMessage(parentID)
+	if !ok {
+		return fmt.Errorf("unknown message %s", parentID)
+	}
+	if parent.Private {
+		return fmt.Errorf("private messages cannot have replies")
+	}
+	if parent.ParentID != "" {
+		parentID = parent.ParentID
+	}
+	return c.sendPublic(content, parentID)
+}
+
+// FetchThread asks the server for the whole thread of parentID. The result
+// is delivered as a ThreadEvent.
+func (c *Client) FetchThread(parentID string) error {
+	msg := &shared.Message{
+		Type:      shared.TypeThreadRequest,
+		From:      c.username,
+		RefID:     parentID,
+		Timestamp: time.Now(),
+	}
+	return c.connection().Send(msg)
+}
+
+// Quote formats a message as a quoted block that can be prepended to a new
+// message.
+func Quote(msg *ChatMessage) string {
+	var b strings.Builder
+	for _, line := range strings.Split(msg.Content, "\n") {
+		fmt.Fprintf(&b, "> %s\n", line)
+	}
+	return fmt.Sprintf("> %s wrote:\n%s", msg.From, b.String())
+}
+
+func (c *Client) handleThread(msg *shared.Message) {
+	msgs := make([]*ChatMessage, 0, len(msg.Messages))
+	for _, m := range msg.Messages {
+		chat := &ChatMessage{
+			ID:         m.ID,
+			ParentID:   m.ParentID,
+			From:       m.From,
+			Timestamp:  m.Timestamp,
+			Outgoing:   m.From == c.username,
+			Edited:     m.Edited,
+			Deleted:    m.Deleted,
+			Reactions:  m.Reactions,
+			ReplyCount: m.ReplyCount,
+		}
+		if !m.Deleted {
+			plain, err := shared.DecryptWithRoomKey(m.EncryptedData, c.getRoomKey())
+			if err != nil {
+				log.Warn("Failed to decrypt thread message", "id", m.ID, "err", err)
+				continue
+			}
+			chat.Content = string(plain)
+		}
+		c.tagMentions(chat)
+		msgs = append(msgs, chat)
+	}
+
+	c.mu.Lock()
+	for _, m := range msgs {
+		stored := *m
+		c.messages[m.ID] = &stored
+	}
+	c.mu.Unlock()
+
+	c.emit(ThreadEvent{ParentID: msg.RefID, Messages: msgs})
+}
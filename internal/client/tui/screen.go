@@ -0,0 +1,243 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"chatroom/internal/shared"
+)
+
+// ANSI colors of the message pane.
+const (
+	colorReset   = "\x1b[0m"
+	colorPublic  = "\x1b[34m"   // Blue
+	colorOwn     = "\x1b[32m"   // Green
+	colorPrivate = "\x1b[35m"   // Magenta
+	colorMention = "\x1b[1;33m" // Bold yellow
+	colorSystem  = "\x1b[90m"   // Gray
+	colorError   = "\x1b[31m"   // Red
+)
+
+// userPaneWidth is the width of the user list, including its border.
+const userPaneWidth = 24
+
+type line struct {
+	color string
+	text  string
+}
+
+type keyKind int
+
+const (
+	keyRune keyKind = iota
+	keyEnter
+	keyBackspace
+	keyClearLine
+	keyTab
+	keyPageUp
+	keyPageDown
+	keyUp
+	keyDown
+	keyInterrupt
+	keyEOF
+	keyUnknown
+)
+
+type key struct {
+	kind keyKind
+	r    rune
+}
+
+// readKey reads one key press from a terminal in raw mode.
+func readKey(r *bufio.Reader) (key, error) {
+	ch, _, err := r.ReadRune()
+	if err != nil {
+		return key{}, err
+	}
+
+	switch ch {
+	case '\r', '\n':
+		return key{kind: keyEnter}, nil
+	case 0x7f, 0x08:
+		return key{kind: keyBackspace}, nil
+	case 0x03:
+		return key{kind: keyInterrupt}, nil
+	case 0x04:
+		return key{kind: keyEOF}, nil
+	case 0x15:
+		return key{kind: keyClearLine}, nil
+	case '\t':
+		return key{kind: keyTab}, nil
+	case 0x1b:
+		return readEscape(r)
+	}
+	if unicode.IsPrint(ch) {
+		return key{kind: keyRune, r: ch}, nil
+	}
+	return key{kind: keyUnknown}, nil
+}
+
+// readEscape decodes the CSI sequences of the keys we use, such as
+// "\x1b[5~" for PgUp.
+func readEscape(r *bufio.Reader) (key, error) {
+	if next, err := r.ReadByte(); err != nil || next != '[' {
+		return key{kind: keyUnknown}, err
+	}
+
+	var seq []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		seq = append(seq, b)
+		if b >= 0x40 && b <= 0x7e {
+			break
+		}
+	}
+
+	switch string(seq) {
+	case "A":
+		return key{kind: keyUp}, nil
+	case "B":
+		return key{kind: keyDown}, nil
+	case "5~":
+		return key{kind: keyPageUp}, nil
+	case "6~":
+		return key{kind: keyPageDown}, nil
+	}
+	return key{kind: keyUnknown}, nil
+}
+
+// paneHeight is the number of message rows. Callers must hold a.mu.
+func (a *App) paneHeight() int {
+	// Title bar, separator and input line.
+	if h := a.height - 3; h > 1 {
+		return h
+	}
+	return 1
+}
+
+// redraw repaints the whole screen: title bar, message pane with the user
+// list on its right, and the input line.
+func (a *App) redraw() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.width == 0 {
+		return
+	}
+
+	userWidth := userPaneWidth
+	if a.width < 60 {
+		userWidth = 0
+	}
+	msgWidth := a.width - userWidth
+	paneHeight := a.paneHeight()
+
+	var wrapped []line
+	for _, l := range a.lines {
+		for _, part := range wrap(l.text, msgWidth) {
+			wrapped = append(wrapped, line{color: l.color, text: part})
+		}
+	}
+	if max := len(wrapped) - paneHeight; a.scroll > max {
+		a.scroll = max
+	}
+	if a.scroll < 0 {
+		a.scroll = 0
+	}
+	end := len(wrapped) - a.scroll
+	start := end - paneHeight
+	if start < 0 {
+		start = 0
+	}
+	visible := wrapped[start:end]
+
+	var b strings.Builder
+	b.WriteString("\x1b[?25l\x1b[H") // hide cursor while drawing
+
+	title := a.title
+	if a.scroll > 0 {
+		title += fmt.Sprintf("  [scrolled back %d lines]", a.scroll)
+	}
+	b.WriteString("\x1b[7m" + pad(title, a.width) + colorReset + "\r\n")
+
+	for row := 0; row < paneHeight; row++ {
+		if row < len(visible) {
+			b.WriteString(visible[row].color + pad(visible[row].text, msgWidth) + colorReset)
+		} else {
+			b.WriteString(strings.Repeat(" ", msgWidth))
+		}
+		if userWidth > 0 {
+			b.WriteString("\x1b[90m│" + colorReset + pad(a.userRow(row), userWidth-1))
+		}
+		b.WriteString("\r\n")
+	}
+
+	b.WriteString("\x1b[90m" + strings.Repeat("─", a.width) + colorReset + "\r\n")
+
+	prompt := "> "
+	input := a.input
+	if avail := a.width - len(prompt) - 1; len(input) > avail {
+		input = input[len(input)-avail:]
+	}
+	b.WriteString(prompt + string(input) + "\x1b[K")
+	b.WriteString("\x1b[?25h")
+
+	fmt.Fprint(a.out, b.String())
+}
+
+// userRow returns the text of one row of the user list. Callers must hold
+// a.mu.
+func (a *App) userRow(row int) string {
+	if row == 0 {
+		return fmt.Sprintf(" Online (%d)", len(a.users))
+	}
+	if row-1 >= len(a.users) {
+		return ""
+	}
+
+	name := a.users[row-1]
+	mark := "●"
+	label := name
+	if p, ok := a.client.GetPresence(name); ok {
+		switch p.Status {
+		case shared.StatusAway:
+			mark = "◐"
+		case shared.StatusBusy:
+			mark = "○"
+		}
+		if p.Nick != "" {
+			label += " (" + p.Nick + ")"
+		}
+	}
+	if name == a.client.GetUsername() {
+		label += " (you)"
+	}
+	return " " + mark + " " + label
+}
+
+// wrap splits text into pieces of at most width runes.
+func wrap(text string, width int) []string {
+	runes := []rune(text)
+	if width <= 0 || len(runes) <= width {
+		return []string{text}
+	}
+	var parts []string
+	for len(runes) > width {
+		parts = append(parts, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(parts, string(runes))
+}
+
+// pad cuts or pads text with spaces to exactly width runes.
+func pad(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return text + strings.Repeat(" ", width-len(runes))
+}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("terminal mode is not supported on this platform")
+
+func makeRaw(f *os.File) (func() error, error) {
+	return nil, errUnsupported
+}
+
+func terminalSize(f *os.File) (int, int, error) {
+	return 0, 0, errUnsupported
+}
+
+func notifyResize(ch chan<- os.Signal) {}
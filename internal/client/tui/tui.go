@@ -0,0 +1,280 @@
+// Package tui is a terminal front end for internal/client. It needs nothing
+// but an ANSI terminal, so it also works over SSH.
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"chatroom/internal/client"
+)
+
+// maxLines is how many lines the message pane keeps for scrolling back.
+const maxLines = 2000
+
+type App struct {
+	client *client.Client
+	in     *os.File
+	out    *os.File
+	title  string
+
+	mu     sync.Mutex
+	lines  []line   // message log, oldest first
+	scroll int      // lines scrolled back from the bottom
+	users  []string // online users
+	input  []rune
+	width  int
+	height int
+
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// NewApp creates a terminal UI for an already connected client. in and out
+// must be the terminal.
+func NewApp(c *client.Client, in, out *os.File, title string) *App {
+	a := &App{
+		client: c,
+		in:     in,
+		out:    out,
+		title:  title,
+		quit:   make(chan struct{}),
+	}
+	c.SetEventHandler(a.handleEvent)
+	a.registerCommands()
+	return a
+}
+
+// Run takes over the terminal until the user quits.
+func (a *App) Run() error {
+	restore, err := makeRaw(a.in)
+	if err != nil {
+		return fmt.Errorf("failed to set up terminal: %v", err)
+	}
+	defer restore()
+
+	fmt.Fprint(a.out, "\x1b[?1049h") // alternate screen
+	defer fmt.Fprint(a.out, "\x1b[?1049l")
+
+	a.resize()
+	a.addLine(colorSystem, "Type a message and press Enter. /help lists the commands, Tab completes, PgUp/PgDn scroll.")
+
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	go func() {
+		for range resized {
+			a.resize()
+		}
+	}()
+
+	go a.readKeys()
+
+	<-a.quit
+	return nil
+}
+
+func (a *App) stop() {
+	a.quitOnce.Do(func() { close(a.quit) })
+}
+
+func (a *App) resize() {
+	w, h, err := terminalSize(a.out)
+	if err != nil || w < 20 || h < 5 {
+		w, h = 80, 24
+	}
+	a.mu.Lock()
+	a.width, a.height = w, h
+	a.mu.Unlock()
+	a.redraw()
+}
+
+func (a *App) handleEvent(ev client.Event) {
+	switch ev := ev.(type) {
+	case client.PublicMessageEvent:
+		a.addChat(ev.Message)
+	case client.PrivateMessageEvent:
+		a.addChat(ev.Message)
+		if !ev.Message.Outgoing {
+			a.client.MarkRead(ev.Message)
+		}
+	case client.UserListEvent:
+		a.mu.Lock()
+		a.users = ev.Users
+		a.mu.Unlock()
+		a.redraw()
+	case client.PresenceEvent:
+		a.redraw()
+	case client.FileAvailableEvent:
+		a.addLine(colorSystem, fmt.Sprintf("%s  (/download %s)", ev, ev.Filename))
+	case client.ErrorEvent:
+		a.addLine(colorError, ev.String())
+	case client.TypingEvent, client.ReceiptEvent, client.MentionEvent,
+		client.MessageUpdatedEvent, client.ThreadEvent:
+		// Not shown in the terminal.
+	default:
+		a.addLine(colorSystem, ev.String())
+	}
+}
+
+func (a *App) addChat(msg *client.ChatMessage) {
+	switch {
+	case msg.Mentioned:
+		a.addLine(colorMention, msg.String())
+	case msg.Private:
+		a.addLine(colorPrivate, msg.String())
+	case msg.Outgoing:
+		a.addLine(colorOwn, msg.String())
+	default:
+		a.addLine(colorPublic, msg.String())
+	}
+}
+
+func (a *App) addLine(color, text string) {
+	a.mu.Lock()
+	for _, l := range strings.Split(text, "\n") {
+		a.lines = append(a.lines, line{color: color, text: l})
+	}
+	if len(a.lines) > maxLines {
+		a.lines = append([]line(nil), a.lines[len(a.lines)-maxLines:]...)
+	}
+	a.mu.Unlock()
+	a.redraw()
+}
+
+func (a *App) readKeys() {
+	r := bufio.NewReader(a.in)
+	for {
+		k, err := readKey(r)
+		if err != nil {
+			a.stop()
+			return
+		}
+		a.handleKey(k)
+	}
+}
+
+func (a *App) handleKey(k key) {
+	a.mu.Lock()
+	switch k.kind {
+	case keyRune:
+		a.input = append(a.input, k.r)
+	case keyBackspace:
+		if len(a.input) > 0 {
+			a.input = a.input[:len(a.input)-1]
+		}
+	case keyClearLine:
+		a.input = nil
+	case keyTab:
+		if options := a.complete(); len(options) > 0 {
+			a.mu.Unlock()
+			a.addLine(colorSystem, strings.Join(options, "  "))
+			return
+		}
+	case keyPageUp:
+		a.scroll += a.paneHeight() - 1
+	case keyPageDown:
+		a.scroll -= a.paneHeight() - 1
+	case keyUp:
+		a.scroll++
+	case keyDown:
+		a.scroll--
+	case keyEOF:
+		if len(a.input) == 0 {
+			a.mu.Unlock()
+			a.stop()
+			return
+		}
+	case keyInterrupt:
+		a.mu.Unlock()
+		a.stop()
+		return
+	case keyEnter:
+		text := strings.TrimSpace(string(a.input))
+		a.input = nil
+		a.scroll = 0
+		a.mu.Unlock()
+		a.redraw()
+		if text != "" {
+			a.submit(text)
+		}
+		return
+	}
+	if a.scroll < 0 {
+		a.scroll = 0
+	}
+	a.mu.Unlock()
+	a.redraw()
+}
+
+func (a *App) submit(text string) {
+	if !client.IsCommand(text) {
+		a.report(a.client.SendMessage(text))
+		return
+	}
+	a.report(a.client.Commands().Execute(a.client, a, text))
+}
+
+// registerCommands adds the commands that only make sense in the terminal.
+func (a *App) registerCommands() {
+	cmds := a.client.Commands()
+	cmds.Register(&client.Command{
+		Name:    "quit",
+		Aliases: []string{"exit"},
+		Help:    "leave the chat",
+		Run: func(c *client.Client, ui client.CommandUI, args []string) error {
+			a.stop()
+			return nil
+		},
+	})
+	cmds.Register(&client.Command{
+		Name:    "send",
+		Args:    []string{"path"},
+		MinArgs: 1,
+		Help:    "share a file with the room",
+		Run: func(c *client.Client, ui client.CommandUI, args []string) error {
+			return c.SendFile(args[0])
+		},
+	})
+	cmds.Register(&client.Command{
+		Name:    "sendto",
+		Args:    []string{"user", "path"},
+		MinArgs: 2,
+		Help:    "send a file to one user",
+		Run: func(c *client.Client, ui client.CommandUI, args []string) error {
+			if !c.UserExists(args[0]) {
+				return fmt.Errorf("user %s is not online", args[0])
+			}
+			return c.SendPrivateFile(args[1], args[0])
+		},
+	})
+}
+
+// Print implements client.CommandUI.
+func (a *App) Print(text string) {
+	a.addLine(colorSystem, text)
+}
+
+// Clear implements client.CommandUI.
+func (a *App) Clear() {
+	a.mu.Lock()
+	a.lines = nil
+	a.scroll = 0
+	a.mu.Unlock()
+	a.redraw()
+}
+
+// complete handles the tab key. Callers must hold a.mu.
+func (a *App) complete() []string {
+	completed, options := a.client.Commands().Complete(a.client, string(a.input))
+	a.input = []rune(completed)
+	return options
+}
+
+func (a *App) report(err error) {
+	if err != nil {
+		a.addLine(colorError, "(Error) "+err.Error())
+	}
+}
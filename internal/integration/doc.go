@@ -0,0 +1,3 @@
+// Package integration holds end-to-end tests that run the real server and
+// clients against each other over the in-memory transport.
+package integration
@@ -0,0 +1,1104 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chatroom/internal/client"
+	"chatroom/internal/server"
+	"chatroom/internal/server/plugin"
+	"chatroom/internal/server/webhook"
+	"chatroom/internal/shared"
+	"chatroom/internal/transport"
+)
+
+const (
+	serverAddr  = "chatroom"
+	waitTimeout = 15 * time.Second
+)
+
+// env is one server with its transport, running in a temporary directory
+// because the server keeps its room key, history and uploads in the
+// working directory.
+type env struct {
+	t     *testing.T
+	mem   *transport.Memory
+	srv   *server.Server
+	setup []func(*server.Server) // run on each server before it starts
+}
+
+// newEnv starts a server, calling setup on it first to enable optional
+// features.
+func newEnv(t *testing.T, setup ...func(*server.Server)) *env {
+	t.Helper()
+	chdirTemp(t)
+
+	e := &env{t: t, mem: transport.NewMemory(), setup: setup}
+	e.start()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		e.srv.Shutdown(ctx)
+	})
+	return e
+}
+
+// chdirTemp moves the test into a temporary directory for the files the
+// server and clients keep in the working directory.
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// start runs a new server on the transport and waits until it listens. A
+// test that shut the previous one down can call it again to bring the
+// server back.
+func (e *env) start() {
+	e.t.Helper()
+	e.srv = server.New(serverAddr)
+	e.srv.SetTransport(e.mem)
+	for _, fn := range e.setup {
+		fn(e.srv)
+	}
+
+	started := make(chan error, 1)
+	go func() { started <- e.srv.Start() }()
+	deadline := time.Now().Add(waitTimeout)
+	for !e.mem.Listening(serverAddr) {
+		select {
+		case err := <-started:
+			e.t.Fatalf("server stopped: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			e.t.Fatal("server did not start listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dial logs in as username without waiting for anything but the auth
+// response.
+func (e *env) dial(username string) (*client.Client, *recorder, error) {
+	rec := &recorder{}
+	c := client.New()
+	c.SetTransport(e.mem)
+	c.SetEventHandler(rec.record)
+	c.Login(username)
+	if err := c.Connect(serverAddr); err != nil {
+		return nil, nil, err
+	}
+	e.t.Cleanup(func() { c.Disconnect() })
+	return c, rec, nil
+}
+
+// join logs in as username and waits for the room key.
+func (e *env) join(username string) (*client.Client, *recorder) {
+	e.t.Helper()
+	c, rec, err := e.dial(username)
+	if err != nil {
+		e.t.Fatalf("connect %s: %v", username, err)
+	}
+	select {
+	case <-c.RoomKeyReady():
+	case <-time.After(waitTimeout):
+		e.t.Fatalf("%s did not receive the room key", username)
+	}
+	return c, rec
+}
+
+// waitUsers waits until c sees every name in its user list, so private
+// messages to them can be addressed.
+func waitUsers(t *testing.T, c *client.Client, rec *recorder, names ...string) {
+	t.Helper()
+	rec.wait(t, "user list", func(client.Event) bool {
+		for _, name := range names {
+			if !c.UserExists(name) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// recorder keeps every event a client emits.
+type recorder struct {
+	mu     sync.Mutex
+	events []client.Event
+}
+
+func (r *recorder) record(ev client.Event) {
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+}
+
+// wait returns the first event recorded so far or later that match accepts.
+func (r *recorder) wait(t *testing.T, what string, match func(client.Event) bool) client.Event {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		r.mu.Lock()
+		events := append([]client.Event(nil), r.events...)
+		r.mu.Unlock()
+		for _, ev := range events {
+			if match(ev) {
+				return ev
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// index returns the position of the first event recorded so far that match
+// accepts, or -1.
+func (r *recorder) index(match func(client.Event) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, ev := range r.events {
+		if match(ev) {
+			return i
+		}
+	}
+	return -1
+}
+
+// has reports whether an event matching match was recorded so far.
+func (r *recorder) has(match func(client.Event) bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range r.events {
+		if match(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+func publicFrom(from, content string) func(client.Event) bool {
+	return func(ev client.Event) bool {
+		m, ok := ev.(client.PublicMessageEvent)
+		return ok && m.Message.From == from && m.Message.Content == content
+	}
+}
+
+func privateFrom(from, content string) func(client.Event) bool {
+	return func(ev client.Event) bool {
+		m, ok := ev.(client.PrivateMessageEvent)
+		return ok && !m.Message.Outgoing && m.Message.From == from && m.Message.Content == content
+	}
+}
+
+func TestAuth(t *testing.T) {
+	e := newEnv(t)
+
+	alice, arec := e.join("  @Alice ")
+	if got := alice.GetUsername(); got != "alice" {
+		t.Errorf("username = %q, want the server's normalized %q", got, "alice")
+	}
+
+	dials := &dialCounter{Transport: e.mem}
+	rejected := client.New()
+	rejected.SetTransport(dials)
+	rejected.Login("ALICE")
+	if err := rejected.Connect(serverAddr); !errors.Is(err, client.ErrAuthFailed) {
+		t.Errorf("second login as alice: err = %v, want ErrAuthFailed", err)
+	}
+	if open := dials.open(); open != 0 {
+		t.Errorf("%d connections left open after the rejected login", open)
+	}
+
+	e.join("bob")
+	waitUsers(t, alice, arec, "alice", "bob")
+}
+
+// dialCounter counts the connections a client opened and has not closed.
+type dialCounter struct {
+	transport.Transport
+	mu    sync.Mutex
+	conns int
+}
+
+func (d *dialCounter) Dial(ctx context.Context, address string) (transport.Conn, error) {
+	conn, err := d.Transport.Dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.conns++
+	d.mu.Unlock()
+	return &countedConn{Conn: conn, d: d}, nil
+}
+
+func (d *dialCounter) open() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns
+}
+
+type countedConn struct {
+	transport.Conn
+	d    *dialCounter
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.d.mu.Lock()
+		c.d.conns--
+		c.d.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+func TestKeyExchange(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	bob, brec := e.join("bob")
+	waitUsers(t, alice, arec, "bob")
+	waitUsers(t, bob, brec, "alice")
+
+	// Both clients decrypt each other's messages, so they were handed the
+	// same room key, and each holds the other's public key once a private
+	// message got through.
+	if err := alice.SendMessage("room key check"); err != nil {
+		t.Fatal(err)
+	}
+	brec.wait(t, "alice's public message", publicFrom("alice", "room key check"))
+
+	if err := bob.SendPrivateMessage("alice", "rsa check"); err != nil {
+		t.Fatal(err)
+	}
+	arec.wait(t, "bob's private message", privateFrom("bob", "rsa check"))
+	if _, ok := bob.PublicKeyCache.Get("alice"); !ok {
+		t.Error("bob has no public key for alice after messaging her")
+	}
+}
+
+func TestPublicMessage(t *testing.T) {
+	e := newEnv(t)
+	alice, _ := e.join("alice")
+	_, brec := e.join("bob")
+	_, crec := e.join("carol")
+
+	if err := alice.SendMessage("hello, room"); err != nil {
+		t.Fatal(err)
+	}
+	brec.wait(t, "message at bob", publicFrom("alice", "hello, room"))
+	crec.wait(t, "message at carol", publicFrom("alice", "hello, room"))
+}
+
+func TestPrivateMessage(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	_, brec := e.join("bob")
+	_, crec := e.join("carol")
+	waitUsers(t, alice, arec, "bob", "carol")
+
+	if err := alice.SendPrivateMessage("bob", "just for you"); err != nil {
+		t.Fatal(err)
+	}
+	brec.wait(t, "private message at bob", privateFrom("alice", "just for you"))
+
+	// Carol saw everything bob did up to now; make sure that did not
+	// include the private message.
+	if err := alice.SendMessage("sync"); err != nil {
+		t.Fatal(err)
+	}
+	crec.wait(t, "public message at carol", publicFrom("alice", "sync"))
+	if crec.has(privateFrom("alice", "just for you")) {
+		t.Error("carol received a private message meant for bob")
+	}
+}
+
+func TestForgedReceipt(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	bob, brec := e.join("bob")
+	bob.SetReadReceipts(false)
+	mallory, _ := e.rawLogin("mallory", "")
+	waitUsers(t, alice, arec, "bob", "mallory")
+
+	if err := alice.SendPrivateMessage("bob", "just for you"); err != nil {
+		t.Fatal(err)
+	}
+	ev := brec.wait(t, "private message at bob", privateFrom("alice", "just for you"))
+	id := ev.(client.PrivateMessageEvent).Message.ID
+
+	mallory.send(&shared.Message{Type: shared.TypeReadReceipt, From: "mallory", To: "alice", RefID: id})
+	// The server handles mallory's messages in order, so once the typing
+	// notice is in, the receipt would have been too.
+	mallory.send(&shared.Message{Type: shared.TypeTyping, From: "mallory"})
+	arec.wait(t, "typing notice", func(ev client.Event) bool {
+		ty, ok := ev.(client.TypingEvent)
+		return ok && ty.From == "mallory"
+	})
+	if arec.has(func(ev client.Event) bool { _, ok := ev.(client.ReceiptEvent); return ok }) {
+		t.Error("alice got a receipt from someone who never received the message")
+	}
+}
+
+func TestPrivateEdit(t *testing.T) {
+	e := newEnv(t)
+	author, _ := e.rawLogin("author", "")
+	reader, _ := e.rawLogin("reader", "")
+
+	author.send(&shared.Message{Type: shared.TypePrivate, From: "author", To: "reader", ID: "p1", Content: "c1", EncryptedKey: "k1", Timestamp: time.Now()})
+	reader.next("private message", func(m *shared.Message) bool { return m.ID == "p1" })
+	author.next("confirmation", func(m *shared.Message) bool { return m.ID == "p1" })
+
+	// The connections are unbuffered, so read the notices in the order the
+	// server writes them: author first.
+	author.send(&shared.Message{Type: shared.TypeEdit, From: "author", To: "reader", RefID: "p1", Content: "c2", EncryptedKey: "k2", Timestamp: time.Now()})
+	isEdit := func(m *shared.Message) bool { return m.Type == shared.TypeEdit && m.RefID == "p1" }
+	// The new text is encrypted for the reader; the author has no use for it.
+	if got := author.next("edit at author", isEdit); got.Content != "" || got.EncryptedKey != "" || !got.Edited {
+		t.Errorf("author got edit %+v, want only the metadata", got)
+	}
+	if got := reader.next("edit at reader", isEdit); got.Content != "c2" || got.EncryptedKey != "k2" {
+		t.Errorf("reader got edit %q/%q, want c2/k2", got.Content, got.EncryptedKey)
+	}
+}
+
+func TestFileTransfer(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	bob, brec := e.join("bob")
+	waitUsers(t, alice, arec, "bob")
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	src := filepath.Join(t.TempDir(), "report.bin")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("public", func(t *testing.T) {
+		if err := alice.SendFile(src); err != nil {
+			t.Fatal(err)
+		}
+		brec.wait(t, "file offer", func(ev client.Event) bool {
+			f, ok := ev.(client.FileAvailableEvent)
+			return ok && !f.Private && f.From == "alice" && f.Filename == "report.bin"
+		})
+		if err := bob.RequestFile("report.bin"); err != nil {
+			t.Fatal(err)
+		}
+		ev := brec.wait(t, "download", func(ev client.Event) bool {
+			f, ok := ev.(client.FileReceivedEvent)
+			return ok && !f.Private && f.Filename == "report.bin"
+		})
+		checkFile(t, ev.(client.FileReceivedEvent).Path, content)
+	})
+
+	t.Run("private", func(t *testing.T) {
+		if err := alice.SendPrivateFile(src, "bob"); err != nil {
+			t.Fatal(err)
+		}
+		brec.wait(t, "private file offer", func(ev client.Event) bool {
+			f, ok := ev.(client.FileAvailableEvent)
+			return ok && f.Private && f.From == "alice" && f.Filename == "report.bin"
+		})
+		if err := bob.RequestPrivateFile("report.bin", "alice"); err != nil {
+			t.Fatal(err)
+		}
+		ev := brec.wait(t, "private download", func(ev client.Event) bool {
+			f, ok := ev.(client.FileReceivedEvent)
+			return ok && f.Private && f.Filename == "report.bin"
+		})
+		checkFile(t, ev.(client.FileReceivedEvent).Path, content)
+	})
+}
+
+func checkFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: got %d bytes, want the %d bytes sent", path, len(got), len(want))
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	bob, brec := e.join("bob")
+	waitUsers(t, alice, arec, "bob")
+
+	e.mem.DropAll()
+
+	for name, rec := range map[string]*recorder{"alice": arec, "bob": brec} {
+		rec.wait(t, name+" noticing the drop", func(ev client.Event) bool {
+			c, ok := ev.(client.ConnectionEvent)
+			return ok && !c.Connected
+		})
+		rec.wait(t, name+" reconnecting", func(ev client.Event) bool {
+			c, ok := ev.(client.ConnectionEvent)
+			return ok && c.Connected
+		})
+	}
+	waitUsers(t, alice, arec, "bob")
+	waitUsers(t, bob, brec, "alice")
+
+	if err := alice.SendMessage("still here"); err != nil {
+		t.Fatal(err)
+	}
+	brec.wait(t, "message after reconnect", publicFrom("alice", "still here"))
+
+	if err := bob.SendPrivateMessage("alice", "me too"); err != nil {
+		t.Fatal(err)
+	}
+	arec.wait(t, "private message after reconnect", privateFrom("bob", "me too"))
+}
+
+func TestShutdown(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	_, brec := e.join("bob")
+	waitUsers(t, alice, arec, "bob")
+
+	// A connection that never logs in must not hold up the shutdown.
+	idle, err := e.mem.Dial(context.Background(), serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	for name, rec := range map[string]*recorder{"alice": arec, "bob": brec} {
+		ev := rec.wait(t, name+" getting the shutdown notice", func(ev client.Event) bool {
+			_, ok := ev.(client.ServerShutdownEvent)
+			return ok
+		})
+		if d := ev.(client.ServerShutdownEvent).RetryAfter; d <= 0 {
+			t.Errorf("%s: reconnect hint %v, want a positive delay", name, d)
+		}
+		rec.wait(t, name+" noticing the drop", func(ev client.Event) bool {
+			c, ok := ev.(client.ConnectionEvent)
+			return ok && !c.Connected
+		})
+	}
+}
+
+// rawConn speaks the protocol directly, for tests that need control over
+// what a client sends and acknowledges.
+type rawConn struct {
+	t    *testing.T
+	conn transport.Conn
+	r    *bufio.Reader
+}
+
+func (e *env) rawLogin(username, session string) (*rawConn, *shared.Message) {
+	e.t.Helper()
+	conn, err := e.mem.Dial(context.Background(), serverAddr)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() { conn.Close() })
+	rc := &rawConn{t: e.t, conn: conn, r: bufio.NewReader(conn)}
+	rc.send(&shared.Message{Type: shared.TypeAuth, From: username, Content: "auth", Session: session})
+	resp := rc.next("auth response", func(m *shared.Message) bool { return m.Type == shared.TypeAuthResponse })
+	return rc, resp
+}
+
+func (rc *rawConn) send(msg *shared.Message) {
+	rc.t.Helper()
+	if err := shared.WriteMessage(rc.conn, msg); err != nil {
+		rc.t.Fatal(err)
+	}
+}
+
+// next skips messages until one matches.
+func (rc *rawConn) next(what string, match func(*shared.Message) bool) *shared.Message {
+	rc.t.Helper()
+	rc.conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	for {
+		msg, err := shared.ReadMessage(rc.r)
+		if err != nil {
+			rc.t.Fatalf("waiting for %s: %v", what, err)
+		}
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	e := newEnv(t)
+	bob, brec := e.join("bob")
+
+	old, resp := e.rawLogin("alice", "")
+	if !resp.Success || resp.Session == "" {
+		t.Fatalf("login: success=%v error=%q, want a session token", resp.Success, resp.Error)
+	}
+	token := resp.Session
+	waitUsers(t, bob, brec, "alice")
+
+	publicFromBob := func(m *shared.Message) bool { return m.Type == shared.TypePublic && m.From == "bob" }
+	if err := bob.SendMessage("first"); err != nil {
+		t.Fatal(err)
+	}
+	first := old.next("first message", publicFromBob)
+	// The second message reaches the old connection, but the client is
+	// gone before it reads it.
+	if err := bob.SendMessage("second"); err != nil {
+		t.Fatal(err)
+	}
+	second := old.next("second message", publicFromBob)
+
+	if _, resp := e.rawLogin("alice", "forged"); resp.Success {
+		t.Fatal("login with a forged token succeeded while alice is online")
+	}
+
+	// The server still holds the old connection; the token takes it over.
+	cur, resp := e.rawLogin("alice", token)
+	if !resp.Success {
+		t.Fatalf("resume: %s", resp.Error)
+	}
+	cur.send(&shared.Message{Type: shared.TypeReconnect, From: "alice", RefID: first.ID})
+	cur.next("replay of the second message", func(m *shared.Message) bool {
+		return publicFromBob(m) && m.ID == second.ID
+	})
+
+	old.conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	for {
+		_, err := shared.ReadMessage(old.r)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Error("the old connection was not closed")
+		}
+		break
+	}
+	if brec.has(func(ev client.Event) bool {
+		u, ok := ev.(client.UserLeftEvent)
+		return ok && u.Username == "alice"
+	}) {
+		t.Error("bob saw alice leave during the takeover")
+	}
+}
+
+func TestReconnectStopAndRetry(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	alice.SetReconnectPolicy(client.ReconnectPolicy{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The shutdown notice asks for a wait before the first attempt.
+	arec.wait(t, "reconnect scheduled", func(ev client.Event) bool {
+		c, ok := ev.(client.ConnectionEvent)
+		return ok && c.State == client.StateReconnecting && c.Attempt == 1 && c.Retry > 0
+	})
+	alice.StopReconnect()
+	if got := alice.ConnectionState(); got != client.StateOffline {
+		t.Fatalf("state after StopReconnect = %v, want offline", got)
+	}
+
+	// Retrying starts over at once; with the server gone the attempts fail
+	// and back off.
+	alice.RetryNow()
+	arec.wait(t, "second attempt after retry", func(ev client.Event) bool {
+		c, ok := ev.(client.ConnectionEvent)
+		return ok && c.State == client.StateReconnecting && c.Attempt == 2 && c.Retry > 0 && c.Retry <= 50*time.Millisecond
+	})
+	alice.StopReconnect()
+}
+
+func TestOutbox(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	bob, brec := e.join("bob")
+	waitUsers(t, alice, arec, "bob")
+
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(src, []byte("queued file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, rec := range map[string]*recorder{"alice": arec, "bob": brec} {
+		rec.wait(t, name+" noticing the drop", func(ev client.Event) bool {
+			c, ok := ev.(client.ConnectionEvent)
+			return ok && !c.Connected
+		})
+	}
+	alice.StopReconnect()
+	bob.StopReconnect()
+
+	if err := alice.SendMessage("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SendPrivateMessage("bob", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SendFile(src); err != nil {
+		t.Fatal(err)
+	}
+	for _, what := range []string{"public", "private", "file"} {
+		arec.wait(t, what+" reported pending", sendStatus(what, client.SendPending))
+	}
+	if _, err := os.Stat(filepath.Join("outbox", "alice.json")); err != nil {
+		t.Fatalf("outbox not saved: %v", err)
+	}
+
+	e.start()
+	bob.RetryNow()
+	brec.wait(t, "bob reconnecting", func(ev client.Event) bool {
+		c, ok := ev.(client.ConnectionEvent)
+		return ok && c.Connected
+	})
+	alice.RetryNow()
+	// Whether or not alice is back yet, this must not overtake the queue.
+	if err := alice.SendPrivateMessage("bob", "fourth"); err != nil {
+		t.Fatal(err)
+	}
+
+	queuedFile := func(ev client.Event) bool {
+		f, ok := ev.(client.FileAvailableEvent)
+		return ok && f.From == "alice" && f.Filename == "notes.txt"
+	}
+	brec.wait(t, "queued public message", publicFrom("alice", "first"))
+	brec.wait(t, "queued private message", privateFrom("alice", "second"))
+	brec.wait(t, "queued file", queuedFile)
+	brec.wait(t, "later private message", privateFrom("alice", "fourth"))
+	// The server queues broadcasts but writes private messages straight
+	// away, so only messages that take the same path keep their order.
+	if brec.index(publicFrom("alice", "first")) > brec.index(queuedFile) {
+		t.Error("bob got the file before the public message queued ahead of it")
+	}
+	if brec.index(privateFrom("alice", "second")) > brec.index(privateFrom("alice", "fourth")) {
+		t.Error("the later private message overtook the queued one")
+	}
+
+	for _, what := range []string{"public", "private", "file"} {
+		arec.wait(t, what+" reported sent", sendStatus(what, client.SendSent))
+	}
+	if arec.has(func(ev client.Event) bool {
+		s, ok := ev.(client.SendStatusEvent)
+		return ok && s.Status == client.SendFailed
+	}) {
+		t.Error("an outbox item failed")
+	}
+	if _, err := os.Stat(filepath.Join("outbox", "alice.json")); !os.IsNotExist(err) {
+		t.Errorf("outbox still on disk after the flush: %v", err)
+	}
+
+	// A message that has to wait for a public key holds back the ones after
+	// it, even while connected.
+	_, crec := e.join("carol")
+	waitUsers(t, alice, arec, "carol")
+	if err := alice.SendPrivateMessage("carol", "fifth"); err != nil {
+		t.Fatal(err)
+	}
+	// Waiting for a key while online is no reason to write the text to disk.
+	if _, err := os.Stat(filepath.Join("outbox", "alice.json")); !os.IsNotExist(err) {
+		t.Errorf("message waiting for a key was saved to the outbox: %v", err)
+	}
+	if err := alice.SendPrivateMessage("bob", "sixth"); err != nil {
+		t.Fatal(err)
+	}
+	crec.wait(t, "private message at carol", privateFrom("alice", "fifth"))
+	brec.wait(t, "private message at bob", privateFrom("alice", "sixth"))
+	outgoing := func(content string) func(client.Event) bool {
+		return func(ev client.Event) bool {
+			m, ok := ev.(client.PrivateMessageEvent)
+			return ok && m.Message.Outgoing && m.Message.Content == content
+		}
+	}
+	if arec.index(outgoing("fifth")) > arec.index(outgoing("sixth")) {
+		t.Error("the message to bob overtook the one waiting for carol's key")
+	}
+}
+
+// sendStatus matches the SendStatusEvent of the public message, private
+// message or file TestOutbox queues.
+func sendStatus(what string, status client.SendStatus) func(client.Event) bool {
+	return func(ev client.Event) bool {
+		s, ok := ev.(client.SendStatusEvent)
+		if !ok || s.Status != status {
+			return false
+		}
+		switch what {
+		case "private":
+			return s.To == "bob"
+		case "file":
+			return s.Filename == "notes.txt"
+		}
+		return s.To == "" && s.Filename == ""
+	}
+}
+
+// TestCLISendPrivate runs "chatroom send -to" against a server on TCP. The
+// command starts without the recipient's public key, so the message waits in
+// the outbox for it; the command must not exit before it went out.
+func TestCLISendPrivate(t *testing.T) {
+	// Build while still in the module.
+	bin := filepath.Join(t.TempDir(), "chatroom")
+	if out, err := exec.Command("go", "build", "-o", bin, "chatroom/cmd/chatroom").CombinedOutput(); err != nil {
+		t.Fatalf("building the CLI: %v\n%s", err, out)
+	}
+	chdirTemp(t)
+
+	addr := freeAddr(t)
+	srv := server.New(addr)
+	go srv.Start()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	bob := client.New()
+	brec := &recorder{}
+	bob.SetEventHandler(brec.record)
+	bob.Login("bob")
+	deadline := time.Now().Add(waitTimeout)
+	for bob.Connect(addr) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { bob.Disconnect() })
+
+	out, err := exec.Command(bin, "send", "-server", addr, "-user", "alice", "-to", "bob", "hello bob").CombinedOutput()
+	if err != nil {
+		t.Fatalf("chatroom send: %v\n%s", err, out)
+	}
+	brec.wait(t, "private message at bob", privateFrom("alice", "hello bob"))
+	if _, err := os.Stat(filepath.Join("outbox", "alice.json")); !os.IsNotExist(err) {
+		t.Errorf("message left in the outbox: %v", err)
+	}
+}
+
+// freeAddr returns a local TCP address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestIRCLineInjection(t *testing.T) {
+	ircAddr := freeAddr(t)
+	e := newEnv(t, func(srv *server.Server) { srv.EnableIRC(ircAddr) })
+	alice, _ := e.join("alice")
+
+	var conn net.Conn
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		var err error
+		if conn, err = net.Dial("tcp", ircAddr); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("IRC bridge did not start listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(waitTimeout))
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "NICK carol\r\nUSER carol 0 * :Carol\r\n")
+
+	// Many IRC clients end a line at a bare CR as well, so split on both.
+	next := func(what, contains string) {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("waiting for %s: %v", what, err)
+			}
+			for _, part := range strings.Split(strings.TrimRight(line, "\r\n"), "\r") {
+				if strings.HasPrefix(part, ":evil") {
+					t.Fatalf("injected line reached the IRC client: %q", part)
+				}
+			}
+			if strings.Contains(line, contains) {
+				return
+			}
+		}
+	}
+	next("welcome", " 001 ")
+
+	if err := alice.SendMessage("hi\r:evil!x@chatroom PRIVMSG #chatroom :gotcha\x00"); err != nil {
+		t.Fatal(err)
+	}
+	next("alice's message", "PRIVMSG")
+}
+
+func TestReactionLimits(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	bob, brec := e.join("bob")
+	carol, crec := e.join("carol")
+
+	if err := alice.SendMessage("react to me"); err != nil {
+		t.Fatal(err)
+	}
+	ev := brec.wait(t, "the message", publicFrom("alice", "react to me"))
+	id := ev.(client.PublicMessageEvent).Message.ID
+	crec.wait(t, "the message", publicFrom("alice", "react to me"))
+
+	emoji := []string{"😀", "😂", "😍", "😎", "😢", "😡", "👍", "👎", "👏", "🙏",
+		"🔥", "🎉", "❤️", "💔", "⭐", "🌈", "🍕", "☕", "🐱", "🐶", "🦄"}
+	refused := func(rec *recorder, what, text string) {
+		t.Helper()
+		rec.wait(t, what, func(ev client.Event) bool {
+			e, ok := ev.(client.ErrorEvent)
+			return ok && strings.Contains(e.Text, text)
+		})
+	}
+	reactions := func(n int) func(client.Event) bool {
+		return func(ev client.Event) bool {
+			u, ok := ev.(client.MessageUpdatedEvent)
+			return ok && u.Message.ID == id && len(u.Message.Reactions) == n
+		}
+	}
+
+	carol.AddReaction(id, "abc")
+	refused(crec, "a reaction that is not an emoji refused", "Invalid reaction")
+
+	// Ten each for alice and bob; the eleventh is one too many for alice.
+	for i, em := range emoji[:20] {
+		c := alice
+		if i >= 10 {
+			c = bob
+		}
+		if err := c.AddReaction(id, em); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crec.wait(t, "20 reactions", reactions(20))
+	alice.AddReaction(id, "🐶")
+	refused(arec, "alice's eleventh reaction refused", "already reacted")
+
+	// Twenty different emoji is the most a message takes.
+	carol.AddReaction(id, emoji[20])
+	refused(crec, "a 21st emoji refused", "different reactions")
+	if crec.has(reactions(21)) {
+		t.Error("the message took a 21st emoji")
+	}
+}
+
+func TestDuplicateMessageID(t *testing.T) {
+	e := newEnv(t)
+	mallory, _ := e.rawLogin("mallory", "")
+	watcher, _ := e.rawLogin("watcher", "")
+
+	public := func(id string) *shared.Message {
+		return &shared.Message{Type: shared.TypePublic, From: "mallory", ID: id, EncryptedData: "x", Timestamp: time.Now()}
+	}
+	mallory.send(public("taken"))
+	watcher.next("first message", func(m *shared.Message) bool { return m.ID == "taken" })
+
+	mallory.send(public("taken"))
+	mallory.next("duplicate refused", func(m *shared.Message) bool { return m.Type == shared.TypeError })
+
+	mallory.send(public("marker"))
+	watcher.next("next message", func(m *shared.Message) bool {
+		if m.ID == "taken" {
+			t.Fatal("the duplicate was broadcast")
+		}
+		return m.ID == "marker"
+	})
+}
+
+func TestForgedMessageFields(t *testing.T) {
+	e := newEnv(t)
+	mallory, _ := e.rawLogin("mallory", "")
+	watcher, _ := e.rawLogin("watcher", "")
+
+	mallory.send(&shared.Message{
+		Type:          shared.TypePublic,
+		From:          "mallory",
+		ID:            "forged",
+		EncryptedData: "x",
+		Timestamp:     time.Now(),
+		Reactions:     map[string][]string{"👍": {"watcher"}},
+		ReplyCount:    7,
+		Edited:        true,
+		Deleted:       true,
+		Messages:      []*shared.Message{{ID: "fake"}},
+	})
+	got := watcher.next("the message", func(m *shared.Message) bool { return m.ID == "forged" })
+	if len(got.Reactions) > 0 || got.ReplyCount != 0 || got.Edited || got.Deleted || len(got.Messages) > 0 {
+		t.Errorf("forged fields were broadcast: %+v", got)
+	}
+
+	// The history serves the message to threads and replays; it must not
+	// have kept them either.
+	watcher.send(&shared.Message{Type: shared.TypeThreadRequest, From: "watcher", RefID: "forged"})
+	thread := watcher.next("the thread", func(m *shared.Message) bool { return m.Type == shared.TypeThread })
+	for _, m := range thread.Messages {
+		if m.ID == "forged" && (len(m.Reactions) > 0 || m.ReplyCount != 0 || m.Edited || m.Deleted) {
+			t.Errorf("forged fields were stored: %+v", m)
+		}
+	}
+}
+
+func TestNickCollision(t *testing.T) {
+	e := newEnv(t)
+	alice, _ := e.rawLogin("alice", "")
+	bob, _ := e.rawLogin("bob", "")
+
+	nick := func(rc *rawConn, from, nick string) *shared.Message {
+		rc.send(&shared.Message{Type: shared.TypeNick, From: from, Content: nick})
+		return rc.next("nick reply", func(m *shared.Message) bool {
+			return m.Type == shared.TypeError ||
+				m.Type == shared.TypeInfo && strings.HasPrefix(m.Content, from+" is now known as")
+		})
+	}
+	if got := nick(alice, "alice", "Ally"); got.Type != shared.TypeInfo {
+		t.Fatalf("free nick refused: %s", got.Content)
+	}
+	for _, taken := range []string{"Alice", "ally"} {
+		if got := nick(bob, "bob", taken); got.Type != shared.TypeError {
+			t.Errorf("nick %q was accepted", taken)
+		}
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	e := newEnv(t, func(srv *server.Server) {
+		srv.RegisterPlugin(plugin.NewUptime())
+		srv.EnableIncomingWebhooks(&server.IncomingConfig{
+			Listen:       "127.0.0.1:0",
+			Integrations: []server.Integration{{Name: "ci", Token: "a-long-random-token"}},
+		})
+	})
+
+	for _, name := range []string{"ci", "@CI", "server", "Uptime"} {
+		if _, resp := e.rawLogin(name, ""); resp.Success {
+			t.Errorf("login as %q succeeded", name)
+		}
+	}
+}
+
+func TestDisconnectWhileReconnecting(t *testing.T) {
+	e := newEnv(t)
+	alice, arec := e.join("alice")
+	alice.SetReconnectPolicy(client.ReconnectPolicy{Initial: 10 * time.Millisecond, Max: 10 * time.Millisecond})
+
+	// Dropping first keeps the shutdown notice from delaying the attempts.
+	e.mem.DropAll()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	arec.wait(t, "reconnecting", func(ev client.Event) bool {
+		c, ok := ev.(client.ConnectionEvent)
+		return ok && c.State == client.StateReconnecting
+	})
+
+	if err := alice.Disconnect(); err != nil {
+		t.Errorf("Disconnect while reconnecting: %v", err)
+	}
+	if got := alice.ConnectionState(); got != client.StateOffline {
+		t.Errorf("state after Disconnect = %v, want offline", got)
+	}
+	attempts := func() int {
+		n := 0
+		arec.has(func(ev client.Event) bool {
+			if c, ok := ev.(client.ConnectionEvent); ok && c.State == client.StateReconnecting {
+				n++
+			}
+			return false
+		})
+		return n
+	}
+	before := attempts()
+	time.Sleep(200 * time.Millisecond)
+	if after := attempts(); after != before {
+		t.Errorf("%d reconnect attempts after Disconnect", after-before)
+	}
+}
+
+// TestWebhookSlowEndpoint checks that an endpoint that never answers does
+// not hold up deliveries to the others.
+func TestWebhookSlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	got := make(chan string, 10)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get(webhook.HeaderEvent)
+	}))
+	defer fast.Close()
+
+	e := newEnv(t, func(srv *server.Server) {
+		err := srv.EnableWebhooks(&webhook.Config{Endpoints: []webhook.Endpoint{
+			{URL: slow.URL, Secret: "slow"},
+			{URL: fast.URL, Secret: "fast"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	e.join("alice")
+	e.join("bob")
+
+	// Both joins reach the fast endpoint well before the slow one's
+	// request times out.
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-got:
+			if ev != webhook.EventUserJoined {
+				t.Errorf("event %d = %q, want %q", i+1, ev, webhook.EventUserJoined)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("fast endpoint got %d of 2 events while the slow one hung", i)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chatroom/internal/server/audit"
+	"chatroom/internal/server/users"
+	"chatroom/internal/server/webhook"
+	"chatroom/internal/shared"
+)
+
+// handleAdminCommand runs a moderation request after checking that user is
+// an admin.
+func (s *Server) handleAdminCommand(user *shared.User, msg *shared.Message) error {
+	if !s.IsAdmin(user.Username) {
+		s.sendErrorToConn(user.Conn, "Only admins can do that")
+		return fmt.Errorf("%s is not an admin", user.Username)
+	}
+
+	switch msg.Type {
+	case shared.TypeKick:
+		err := s.kick(user.Username, strings.ToLower(strings.TrimSpace(msg.To)), strings.TrimSpace(msg.Content))
+		if err != nil {
+			s.sendErrorToConn(user.Conn, err.Error())
+		}
+		return err
+	case shared.TypeAnnounce:
+		text := strings.TrimSpace(msg.Content)
+		if text == "" {
+			s.sendErrorToConn(user.Conn, "Announcement cannot be empty")
+			return fmt.Errorf("empty announcement")
+		}
+		s.broadcast(&shared.Message{
+			Type:      shared.TypeInfo,
+			Content:   fmt.Sprintf("Announcement from %s: %s", user.Username, text),
+			Timestamp: time.Now(),
+		})
+		log.Info("Announcement", "user", user.Username)
+		s.record(audit.AdminAnnounce, user.Username, "", nil)
+		s.publishWebhook(webhook.Event{
+			Type:   webhook.EventAdminAction,
+			User:   user.Username,
+			Action: "announce",
+			Reason: text,
+		})
+		return nil
+	}
+	return fmt.Errorf("unknown admin command %s", msg.Type)
+}
+
+// kick tells target why it is being removed and closes its connection. The
+// connection handler then announces the leave as usual. by is the admin's
+// username, or operatorName for the admin HTTP API.
+func (s *Server) kick(by, target, reason string) error {
+	if by != operatorName && target == by {
+		return fmt.Errorf("you cannot kick yourself")
+	}
+	u, ok := s.users.GetByUsername(target)
+	if !ok {
+		return fmt.Errorf("user %s not found", target)
+	}
+
+	notice := &shared.Message{
+		Type:      shared.TypeKick,
+		From:      by,
+		To:        target,
+		Content:   reason,
+		Timestamp: time.Now(),
+	}
+	if err := u.WriteMessage(notice); err != nil {
+		log.Warn("Failed to notify user about kick", "user", target, "err", err)
+	}
+	s.sessions.Revoke(target)
+	u.Conn.Close()
+
+	text := fmt.Sprintf("%s was removed by %s", target, by)
+	if reason != "" {
+		text += ": " + reason
+	}
+	s.broadcast(&shared.Message{
+		Type:      shared.TypeInfo,
+		Content:   text,
+		Timestamp: time.Now(),
+	})
+	log.Info("User kicked", "by", by, "user", target, "reason", reason)
+	s.record(audit.AdminKick, by, target, map[string]string{"reason": reason})
+	s.publishWebhook(webhook.Event{
+		Type:   webhook.EventAdminAction,
+		User:   by,
+		Action: "kick",
+		Target: target,
+		Reason: reason,
+	})
+	return nil
+}
+
+// ban keeps target from logging in and removes it if it is online. Bans are
+// saved with the server state right away.
+func (s *Server) ban(by, target, reason string) error {
+	target = users.Normalize(target)
+	if target == "" {
+		return fmt.Errorf("username is required")
+	}
+	if by != operatorName && target == by {
+		return fmt.Errorf("you cannot ban yourself")
+	}
+
+	s.mu.Lock()
+	s.bans[target] = reason
+	s.mu.Unlock()
+	if err := s.SaveState(); err != nil {
+		log.Error("Failed to save ban", "user", target, "err", err)
+	}
+	log.Info("User banned", "by", by, "user", target, "reason", reason)
+	s.record(audit.AdminBan, by, target, map[string]string{"reason": reason})
+	s.publishWebhook(webhook.Event{
+		Type:   webhook.EventAdminAction,
+		User:   by,
+		Action: "ban",
+		Target: target,
+		Reason: reason,
+	})
+
+	if _, online := s.users.GetByUsername(target); online {
+		return s.kick(by, target, reason)
+	}
+	return nil
+}
+
+// unban lifts a ban. It reports whether target was banned.
+func (s *Server) unban(by, target string) bool {
+	target = users.Normalize(target)
+	s.mu.Lock()
+	_, banned := s.bans[target]
+	delete(s.bans, target)
+	s.mu.Unlock()
+	if !banned {
+		return false
+	}
+
+	if err := s.SaveState(); err != nil {
+		log.Error("Failed to save unban", "user", target, "err", err)
+	}
+	log.Info("User unbanned", "by", by, "user", target)
+	s.record(audit.AdminUnban, by, target, nil)
+	s.publishWebhook(webhook.Event{
+		Type:   webhook.EventAdminAction,
+		User:   by,
+		Action: "unban",
+		Target: target,
+	})
+	return true
+}
+
+// banReason reports whether username is banned, and why.
+func (s *Server) banReason(username string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reason, banned := s.bans[users.Normalize(username)]
+	return reason, banned
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"chatroom/internal/server/filetransfer"
+	"chatroom/internal/server/users"
+	"chatroom/pkg/logger"
+)
+
+// operatorName is who kicks and bans made through the admin API come from.
+const operatorName = "server"
+
+// maxAdminBody limits the size of an admin API request.
+const maxAdminBody = 16 << 10
+
+var apiLog = logger.New("adminapi")
+
+//go:embed adminapi.html
+var dashboardHTML []byte
+
+type adminAPI struct {
+	addr  string
+	token string
+}
+
+// EnableAdminAPI serves the admin HTTP API and dashboard on addr once the
+// server starts. Call it before Start. addr must be a loopback address such
+// as "127.0.0.1:9090", and every API request must carry token as a bearer
+// token.
+func (s *Server) EnableAdminAPI(addr, token string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid admin API address %q: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("admin API address %q must be bound to localhost", addr)
+	}
+	if len(token) < 16 {
+		return fmt.Errorf("admin API token must be at least 16 characters")
+	}
+	s.adminAPI = &adminAPI{addr: addr, token: token}
+	return nil
+}
+
+// serveAdminAPI runs the HTTP listener until the server shuts down.
+func (s *Server) serveAdminAPI(api *adminAPI) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleDashboard)
+	mux.HandleFunc("/api/users", s.adminOnly(http.MethodGet, s.handleAdminUsers))
+	mux.HandleFunc("/api/stats", s.adminOnly(http.MethodGet, s.handleAdminStats))
+	mux.HandleFunc("/api/bans", s.adminOnly(http.MethodGet, s.handleAdminBans))
+	mux.HandleFunc("/api/kick", s.adminOnly(http.MethodPost, s.handleAdminKick))
+	mux.HandleFunc("/api/ban", s.adminOnly(http.MethodPost, s.handleAdminBan))
+	mux.HandleFunc("/api/unban", s.adminOnly(http.MethodPost, s.handleAdminUnban))
+	mux.HandleFunc("/api/state", s.adminOnly("", s.handleAdminState))
+
+	ln, err := net.Listen("tcp", api.addr)
+	if err != nil {
+		apiLog.Error("Admin API disabled", "err", err)
+		return
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-s.done
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	apiLog.Info("Admin API and dashboard listening", "url", "http://"+ln.Addr().String()+"/")
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		apiLog.Error("Admin API stopped", "err", err)
+	}
+}
+
+// adminOnly checks the bearer token and, unless method is empty, the request
+// method before calling h.
+func (s *Server) adminOnly(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminAPI.token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		if method != "" && r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSONError(w, http.StatusMethodNotAllowed, "use "+method)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleDashboard serves the dashboard page. It holds no data itself; its
+// script asks for the token and calls the API.
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(dashboardHTML)
+}
+
+// adminUser is one connected user in the admin API.
+type adminUser struct {
+	Username   string    `json:"username"`
+	JoinedAt   time.Time `json:"joined_at"`
+	RemoteAddr string    `json:"remote_addr"`
+	Admin      bool      `json:"admin"`
+	Status     string    `json:"status,omitempty"`
+	Nick       string    `json:"nick,omitempty"`
+}
+
+func (s *Server) adminUsers() []adminUser {
+	list := []adminUser{}
+	for _, u := range s.users.GetAll() {
+		au := adminUser{
+			Username: u.Username,
+			JoinedAt: u.JoinedAt,
+			Admin:    s.IsAdmin(u.Username),
+		}
+		if u.Conn != nil {
+			au.RemoteAddr = u.Conn.RemoteAddr().String()
+		}
+		if p, ok := s.presence.Get(u.Username); ok {
+			au.Status = string(p.Status)
+			au.Nick = p.Nick
+		}
+		list = append(list, au)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// adminStats are the figures shown at the top of the dashboard.
+type adminStats struct {
+	StartedAt      time.Time          `json:"started_at"`
+	UptimeSeconds  int64              `json:"uptime_seconds"`
+	UsersOnline    int                `json:"users_online"`
+	BroadcastQueue int                `json:"broadcast_queue"` // messages waiting to be broadcast
+	BroadcastLimit int                `json:"broadcast_limit"` // further messages are dropped
+	Uploads        filetransfer.Stats `json:"uploads"`
+}
+
+func (s *Server) adminStats() adminStats {
+	uploads, err := s.fileTransfer.Stats()
+	if err != nil {
+		apiLog.Warn("Failed to read upload stats", "err", err)
+	}
+	return adminStats{
+		StartedAt:      s.startedAt,
+		UptimeSeconds:  int64(time.Since(s.startedAt).Seconds()),
+		UsersOnline:    len(s.users.GetUsernames()),
+		BroadcastQueue: len(s.broadcastCh),
+		BroadcastLimit: cap(s.broadcastCh),
+		Uploads:        uploads,
+	}
+}
+
+func (s *Server) adminBans() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	bans := make(map[string]string, len(s.bans))
+	for name, reason := range s.bans {
+		bans[name] = reason
+	}
+	return bans
+}
+
+func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.adminUsers())
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.adminStats())
+}
+
+func (s *Server) handleAdminBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.adminBans())
+}
+
+// adminAction is the request body of the kick, ban and unban endpoints.
+type adminAction struct {
+	User   string `json:"user"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func readAdminAction(w http.ResponseWriter, r *http.Request) (adminAction, bool) {
+	var a adminAction
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAdminBody)).Decode(&a); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return a, false
+	}
+	a.User = users.Normalize(a.User)
+	a.Reason = strings.TrimSpace(a.Reason)
+	if a.User == "" {
+		writeJSONError(w, http.StatusBadRequest, "user is required")
+		return a, false
+	}
+	return a, true
+}
+
+func (s *Server) handleAdminKick(w http.ResponseWriter, r *http.Request) {
+	a, ok := readAdminAction(w, r)
+	if !ok {
+		return
+	}
+	if err := s.kick(operatorName, a.User, a.Reason); err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"kicked": a.User})
+}
+
+func (s *Server) handleAdminBan(w http.ResponseWriter, r *http.Request) {
+	a, ok := readAdminAction(w, r)
+	if !ok {
+		return
+	}
+	if err := s.ban(operatorName, a.User, a.Reason); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"banned": a.User})
+}
+
+func (s *Server) handleAdminUnban(w http.ResponseWriter, r *http.Request) {
+	a, ok := readAdminAction(w, r)
+	if !ok {
+		return
+	}
+	if !s.unban(operatorName, a.User) {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("%s is not banned", a.User))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"unbanned": a.User})
+}
+
+// adminState is a snapshot of everything the admin API knows.
+type adminState struct {
+	Time     time.Time         `json:"time"`
+	Stats    adminStats        `json:"stats"`
+	Users    []adminUser       `json:"users"`
+	Admins   []string          `json:"admins"`
+	Bans     map[string]string `json:"bans"`
+	History  int               `json:"history_messages"`
+	Plugins  int               `json:"plugins"`
+	Webhooks bool              `json:"webhooks"`
+	Saved    bool              `json:"saved,omitempty"` // POST only
+}
+
+// handleAdminState returns a snapshot of the server. POST also writes the
+// server state and message history to disk first.
+func (s *Server) handleAdminState(w http.ResponseWriter, r *http.Request) {
+	var saved bool
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := s.SaveState(); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "failed to save state: "+err.Error())
+			return
+		}
+		saved = true
+		apiLog.Info("Server state saved through the admin API")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		return
+	}
+
+	s.mu.RLock()
+	admins := make([]string, 0, len(s.admins))
+	for name := range s.admins {
+		admins = append(admins, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(admins)
+
+	writeJSON(w, http.StatusOK, adminState{
+		Time:     time.Now(),
+		Stats:    s.adminStats(),
+		Users:    s.adminUsers(),
+		Admins:   admins,
+		Bans:     s.adminBans(),
+		History:  s.history.Len(),
+		Plugins:  s.plugins.Len(),
+		Webhooks: s.webhooks != nil,
+		Saved:    saved,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
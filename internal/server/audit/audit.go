@@ -0,0 +1,426 @@
+// Package audit keeps the server's audit log of security-relevant events.
+//
+// The log is a file with one JSON entry per line. Each entry holds the
+// hash of the entry before it and a hash of itself, so editing, removing,
+// reordering or inserting entries breaks the chain. The hashes are
+// HMAC-SHA256 with a secret kept in a key file next to the log, so the
+// chain cannot be rebuilt without it. A head file next to the log records
+// the latest entry, so cutting entries off the end shows too. Entries are
+// only ever appended. VerifyFile checks a log.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event names.
+const (
+	AuthSuccess      = "auth.success"
+	AuthFailure      = "auth.failure"
+	AdminKick        = "admin.kick"
+	AdminBan         = "admin.ban"
+	AdminUnban       = "admin.unban"
+	AdminAnnounce    = "admin.announce"
+	AdminEdit        = "admin.edit" // an admin edited someone else's message
+	MessageDelete    = "message.delete"
+	FileUpload       = "file.upload"
+	FileDownload     = "file.download"
+	RoomKeyGenerated = "room_key.generated"
+	RoomKeyLoaded    = "room_key.loaded"
+	RoomKeySent      = "room_key.sent"
+	LogRecovered     = "audit.recovered" // Open dropped a torn last line
+)
+
+// Files kept next to the log, named after it.
+const (
+	keySuffix  = ".key"  // HMAC secret, hex encoded
+	headSuffix = ".head" // the latest entry, see head
+)
+
+// genesis is the previous hash of the first entry.
+var genesis = hex.EncodeToString(make([]byte, sha256.Size))
+
+// maxLine limits one entry when reading a log back.
+const maxLine = 1 << 20
+
+// Entry is one line of the log. Details never hold keys or message text.
+type Entry struct {
+	Seq     uint64            `json:"seq"`
+	Time    time.Time         `json:"time"`
+	Event   string            `json:"event"`
+	Actor   string            `json:"actor,omitempty"`  // who did it
+	Target  string            `json:"target,omitempty"` // who or what it was done to
+	Details map[string]string `json:"details,omitempty"`
+	Prev    string            `json:"prev"` // hash of the previous entry
+	Hash    string            `json:"hash"` // hash of this entry with Hash empty
+}
+
+// sum returns the keyed hash of e, ignoring e.Hash.
+func (e Entry) sum(key []byte) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return mac(key, data), nil
+}
+
+func mac(key, data []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// head is the content of the head file: the sequence number and hash of the
+// latest entry, signed with the key so it cannot be pointed at an earlier
+// entry.
+type head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	MAC  string `json:"mac"`
+}
+
+func (h head) sum(key []byte) string {
+	return mac(key, []byte(fmt.Sprintf("head %d %s", h.Seq, h.Hash)))
+}
+
+// check reports whether a chain ending at entry seq with the given hash and
+// previous hash matches the head. The chain may be one entry ahead: Record
+// updates the head right after appending, and the server may have stopped
+// in between. h is nil when there is no head file yet.
+func (h *head) check(seq uint64, hash, prev string) error {
+	if h == nil {
+		if seq > 0 {
+			return fmt.Errorf("%w: the head file is missing", ErrBroken)
+		}
+		return nil
+	}
+	switch {
+	case seq < h.Seq:
+		return fmt.Errorf("%w: the log ends at entry %d but entry %d was recorded (truncated)", ErrBroken, seq, h.Seq)
+	case seq == h.Seq && hash == h.Hash:
+		return nil
+	case seq == h.Seq+1 && prev == h.Hash:
+		return nil
+	}
+	return fmt.Errorf("%w: the log does not end at the recorded entry %d", ErrBroken, h.Seq)
+}
+
+func readHead(path string, key []byte) (*head, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h head
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("%w: invalid head file: %v", ErrBroken, err)
+	}
+	if !hmac.Equal([]byte(h.MAC), []byte(h.sum(key))) {
+		return nil, fmt.Errorf("%w: the head file was modified", ErrBroken)
+	}
+	return &h, nil
+}
+
+func writeHead(path string, key []byte, seq uint64, hash string) error {
+	h := head{Seq: seq, Hash: hash}
+	h.MAC = h.sum(key)
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readKey reads the HMAC secret of the log at logPath.
+func readKey(logPath string) ([]byte, error) {
+	data, err := os.ReadFile(logPath + keySuffix)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid audit key in %s", logPath+keySuffix)
+	}
+	return key, nil
+}
+
+func loadOrCreateKey(logPath string) ([]byte, error) {
+	key, err := readKey(logPath)
+	if err == nil || !os.IsNotExist(err) {
+		return key, err
+	}
+	key = make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// O_EXCL: never replace a key that appeared in the meantime.
+	f, err := os.OpenFile(logPath+keySuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		return nil, err
+	}
+	return key, f.Sync()
+}
+
+// Log appends entries to an audit log file. It is safe for concurrent use.
+type Log struct {
+	mu       sync.Mutex
+	f        *os.File
+	key      []byte
+	headPath string
+	seq      uint64
+	prev     string
+}
+
+// Open opens the log at path, creating it and its key if needed, and
+// continues its chain. A last line cut short by a crash is dropped, and the
+// drop is recorded. Open fails if the last entry or the head file does not
+// check out; run VerifyFile to find out what is wrong.
+func Open(path string) (*Log, error) {
+	key, err := loadOrCreateKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("audit key: %v", err)
+	}
+	l := &Log{key: key, headPath: path + headSuffix, prev: genesis}
+
+	var last *Entry
+	var torn int64
+	if f, err := os.Open(path); err == nil {
+		var good int64
+		last, good, err = lastEntry(f)
+		if err == nil {
+			var size int64
+			if size, err = f.Seek(0, io.SeekEnd); err == nil {
+				torn = size - good
+			}
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("audit log %s: %v", path, err)
+		}
+		if torn > 0 {
+			if err := os.Truncate(path, good); err != nil {
+				return nil, err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if last != nil {
+		sum, err := last.sum(key)
+		if err != nil {
+			return nil, err
+		}
+		if sum != last.Hash {
+			return nil, fmt.Errorf("audit log %s: %w: the last entry was modified", path, ErrBroken)
+		}
+		l.seq, l.prev = last.Seq, last.Hash
+	}
+	h, err := readHead(l.headPath, key)
+	if err == nil {
+		err = h.check(l.seq, l.prev, prevOf(last))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("audit log %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+	if err := writeHead(l.headPath, key, l.seq, l.prev); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if torn > 0 {
+		if err := l.Record(LogRecovered, "", "", map[string]string{"dropped_bytes": fmt.Sprint(torn)}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func prevOf(e *Entry) string {
+	if e == nil {
+		return ""
+	}
+	return e.Prev
+}
+
+// lastEntry returns the last entry of a log and the offset just past it.
+// Anything after the last newline is a torn write and is not counted.
+func lastEntry(r io.Reader) (*Entry, int64, error) {
+	var last []byte
+	var offset, good int64
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += int64(len(line))
+		good = offset
+		if line := bytes.TrimSpace(line); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if last == nil {
+		return nil, good, nil
+	}
+	var e Entry
+	if err := json.Unmarshal(last, &e); err != nil {
+		return nil, 0, fmt.Errorf("invalid last entry: %v", err)
+	}
+	return &e, good, nil
+}
+
+// Record appends an entry and syncs it to disk. A nil *Log records nothing.
+func (l *Log) Record(event, actor, target string, details map[string]string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := Entry{
+		Seq:     l.seq + 1,
+		Time:    time.Now().UTC(),
+		Event:   event,
+		Actor:   actor,
+		Target:  target,
+		Details: details,
+		Prev:    l.prev,
+	}
+	hash, err := e.sum(l.key)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.seq, l.prev = e.Seq, e.Hash
+	return writeHead(l.headPath, l.key, l.seq, l.prev)
+}
+
+// Close closes the file. Later calls to Record fail.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// ErrBroken is wrapped by the errors Verify returns for a broken chain.
+var ErrBroken = errors.New("audit chain broken")
+
+// Verify reads a log and checks every entry's hash, computed with key, and
+// link to the entry before it. It returns the number of entries and the last
+// hash. The error names the first line that does not check out. Verify
+// cannot tell whether entries are missing at the end; VerifyFile can.
+func Verify(r io.Reader, key []byte) (count int, lastHash string, err error) {
+	count, lastHash, _, err = verify(r, key)
+	return count, lastHash, err
+}
+
+// verify is Verify that also returns the previous hash of the last entry.
+func verify(r io.Reader, key []byte) (count int, lastHash, lastPrev string, err error) {
+	prev := genesis
+	var seq uint64
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxLine)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			return count, prev, lastPrev, fmt.Errorf("%w: line %d is empty", ErrBroken, lineNo)
+		}
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return count, prev, lastPrev, fmt.Errorf("%w: line %d is not a valid entry: %v", ErrBroken, lineNo, err)
+		}
+		if e.Seq != seq+1 {
+			return count, prev, lastPrev, fmt.Errorf("%w: line %d has sequence number %d, want %d", ErrBroken, lineNo, e.Seq, seq+1)
+		}
+		if e.Prev != prev {
+			return count, prev, lastPrev, fmt.Errorf("%w: line %d does not follow the entry before it", ErrBroken, lineNo)
+		}
+		sum, err := e.sum(key)
+		if err != nil {
+			return count, prev, lastPrev, err
+		}
+		if sum != e.Hash {
+			return count, prev, lastPrev, fmt.Errorf("%w: line %d was modified (hash mismatch)", ErrBroken, lineNo)
+		}
+
+		lastPrev = e.Prev
+		seq, prev = e.Seq, e.Hash
+		count++
+	}
+	if err := sc.Err(); err != nil {
+		return count, prev, lastPrev, err
+	}
+	return count, prev, lastPrev, nil
+}
+
+// VerifyFile runs Verify on the log at path with the key stored next to it,
+// then checks that the log ends where its head file says.
+func VerifyFile(path string) (count int, lastHash string, err error) {
+	key, err := readKey(path)
+	if err != nil {
+		return 0, "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	count, lastHash, prev, err := verify(f, key)
+	if err != nil {
+		return count, lastHash, err
+	}
+	h, err := readHead(path+headSuffix, key)
+	if err == nil {
+		err = h.check(uint64(count), lastHash, prev)
+	}
+	return count, lastHash, err
+}
@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newLog records n entries in a fresh log and returns its path.
+func newLog(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := l.Record(AuthSuccess, "alice", "", map[string]string{"n": string(rune('a' + i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	return lines[:len(lines)-1] // drop the empty piece after the last newline
+}
+
+func writeLines(t *testing.T, path string, lines [][]byte) {
+	t.Helper()
+	data := bytes.Join(lines, nil)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wantBroken(t *testing.T, path, contains string) {
+	t.Helper()
+	_, _, err := VerifyFile(path)
+	if !errors.Is(err, ErrBroken) {
+		t.Fatalf("VerifyFile = %v, want ErrBroken", err)
+	}
+	if !strings.Contains(err.Error(), contains) {
+		t.Errorf("VerifyFile = %v, want it to mention %q", err, contains)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := newLog(t, 3)
+	count, last, err := VerifyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+
+	// Reopening continues the chain.
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Record(AdminKick, "root", "bob", nil); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	count, next, err := VerifyFile(path)
+	if err != nil || count != 4 || next == last {
+		t.Errorf("after reopening: count %d, hash %s, err %v", count, next, err)
+	}
+}
+
+func TestTampering(t *testing.T) {
+	path := newLog(t, 3)
+	lines := readLines(t, path)
+	lines[1] = bytes.Replace(lines[1], []byte(`"actor":"alice"`), []byte(`"actor":"mallory"`), 1)
+	writeLines(t, path, lines)
+	wantBroken(t, path, "line 2 was modified")
+}
+
+// TestRewrite rebuilds the chain after an edit the way anyone could if the
+// hashes were not keyed.
+func TestRewrite(t *testing.T) {
+	path := newLog(t, 3)
+	lines := readLines(t, path)
+	prev := genesis
+	for i, line := range lines {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			e.Actor = "mallory"
+		}
+		e.Prev, e.Hash = prev, ""
+		data, _ := json.Marshal(e)
+		sum := sha256.Sum256(data)
+		e.Hash = hex.EncodeToString(sum[:])
+		prev = e.Hash
+		lines[i], _ = json.Marshal(e)
+		lines[i] = append(lines[i], '\n')
+	}
+	writeLines(t, path, lines)
+	wantBroken(t, path, "line 1 was modified")
+}
+
+func TestReordering(t *testing.T) {
+	path := newLog(t, 3)
+	lines := readLines(t, path)
+	lines[1], lines[2] = lines[2], lines[1]
+	writeLines(t, path, lines)
+	wantBroken(t, path, "line 2 has sequence number 3")
+}
+
+func TestTruncation(t *testing.T) {
+	t.Run("end", func(t *testing.T) {
+		path := newLog(t, 3)
+		writeLines(t, path, readLines(t, path)[:2])
+		wantBroken(t, path, "truncated")
+		if _, err := Open(path); !errors.Is(err, ErrBroken) {
+			t.Errorf("Open = %v, want ErrBroken", err)
+		}
+	})
+	t.Run("all", func(t *testing.T) {
+		path := newLog(t, 3)
+		writeLines(t, path, nil)
+		wantBroken(t, path, "truncated")
+	})
+	t.Run("start", func(t *testing.T) {
+		path := newLog(t, 3)
+		writeLines(t, path, readLines(t, path)[1:])
+		wantBroken(t, path, "line 1 has sequence number 2")
+	})
+	t.Run("head", func(t *testing.T) {
+		// Pointing the head at an earlier entry takes the key too.
+		path := newLog(t, 3)
+		lines := readLines(t, path)
+		var e Entry
+		json.Unmarshal(lines[1], &e)
+		writeLines(t, path, lines[:2])
+		data, _ := json.Marshal(head{Seq: e.Seq, Hash: e.Hash})
+		os.WriteFile(path+headSuffix, data, 0600)
+		wantBroken(t, path, "head file was modified")
+	})
+}
+
+func TestTornLine(t *testing.T) {
+	path := newLog(t, 2)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"time":"2026-`)
+	f.Close()
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open with a torn last line: %v", err)
+	}
+	l.Close()
+	count, _, err := VerifyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("count = %d, want the 2 entries and the recovery", count)
+	}
+	lines := readLines(t, path)
+	if !bytes.Contains(lines[2], []byte(LogRecovered)) {
+		t.Errorf("last entry = %s, want %s", lines[2], LogRecovered)
+	}
+}
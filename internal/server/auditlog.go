@@ -0,0 +1,12 @@
+package server
+
+// auditFile is the server's audit log, next to its other state files.
+const auditFile = "audit.log"
+
+// record appends an event to the audit log. Details must not hold keys or
+// message text.
+func (s *Server) record(event, actor, target string, details map[string]string) {
+	if err := s.audit.Record(event, actor, target, details); err != nil {
+		log.Error("Failed to write audit log", "event", event, "err", err)
+	}
+}
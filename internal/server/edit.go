@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"chatroom/internal/server/audit"
+	"chatroom/internal/server/webhook"
+	"chatroom/internal/shared"
+)
+
+// canModify reports whether user may edit or delete the stored message.
+// Authors may always change their own messages; admins may also moderate
+// public ones.
+func (s *Server) canModify(user *shared.User, original *shared.Message) bool {
+	if original.From == user.Username {
+		return true
+	}
+	return original.Type == shared.TypePublic && s.IsAdmin(user.Username)
+}
+
+func (s *Server) handleEdit(user *shared.User, msg *shared.Message) error {
+	updated, err := s.history.Update(msg.RefID, func(original *shared.Message) error {
+		if original.Deleted {
+			return fmt.Errorf("message %s was deleted", original.ID)
+		}
+		if !s.canModify(user, original) {
+			return fmt.Errorf("not allowed to edit message %s", original.ID)
+		}
+
+		switch original.Type {
+		case shared.TypePublic:
+			if msg.EncryptedData == "" {
+				return fmt.Errorf("edit of message %s has no content", original.ID)
+			}
+			original.EncryptedData = msg.EncryptedData
+		case shared.TypePrivate:
+			if msg.Content == "" || msg.EncryptedKey == "" {
+				return fmt.Errorf("edit of message %s has no content", original.ID)
+			}
+			original.EncryptedKey = msg.EncryptedKey
+			original.Content = msg.Content
+		default:
+			return fmt.Errorf("message %s cannot be edited", original.ID)
+		}
+		original.Edited = true
+		return nil
+	})
+	if err != nil {
+		s.sendErrorToConn(user.Conn, "Cannot edit message: "+err.Error())
+		return err
+	}
+
+	notice := &shared.Message{
+		Type:          shared.TypeEdit,
+		From:          user.Username,
+		To:            updated.To,
+		RefID:         updated.ID,
+		EncryptedKey:  updated.EncryptedKey,
+		EncryptedData: updated.EncryptedData,
+		Content:       updated.Content,
+		Edited:        true,
+		Timestamp:     time.Now(),
+	}
+	s.propagateUpdate(updated, notice)
+	log.Info("Message edited", "id", updated.ID, "user", user.Username)
+	if updated.From != user.Username {
+		s.record(audit.AdminEdit, user.Username, updated.ID, map[string]string{"author": updated.From})
+	}
+	s.publishModeration(user, updated, "edit")
+	return nil
+}
+
+func (s *Server) handleDelete(user *shared.User, msg *shared.Message) error {
+	updated, err := s.history.Update(msg.RefID, func(original *shared.Message) error {
+		if original.Deleted {
+			return fmt.Errorf("message %s was already deleted", original.ID)
+		}
+		if !s.canModify(user, original) {
+			return fmt.Errorf("not allowed to delete message %s", original.ID)
+		}
+		if original.Type != shared.TypePublic && original.Type != shared.TypePrivate {
+			return fmt.Errorf("message %s cannot be deleted", original.ID)
+		}
+
+		original.Deleted = true
+		original.Content = ""
+		original.EncryptedKey = ""
+		original.EncryptedData = ""
+		return nil
+	})
+	if err != nil {
+		s.sendErrorToConn(user.Conn, "Cannot delete message: "+err.Error())
+		return err
+	}
+
+	notice := &shared.Message{
+		Type:      shared.TypeDelete,
+		From:      user.Username,
+		To:        updated.To,
+		RefID:     updated.ID,
+		Deleted:   true,
+		Timestamp: time.Now(),
+	}
+	s.propagateUpdate(updated, notice)
+	log.Info("Message deleted", "id", updated.ID, "user", user.Username)
+	s.record(audit.MessageDelete, user.Username, updated.ID, map[string]string{"author": updated.From})
+	s.publishModeration(user, updated, "delete")
+	return nil
+}
+
+// publishModeration reports an admin changing someone else's message.
+func (s *Server) publishModeration(user *shared.User, msg *shared.Message, action string) {
+	if msg.From == user.Username {
+		return
+	}
+	s.publishWebhook(webhook.Event{
+		Type:   webhook.EventAdminAction,
+		User:   user.Username,
+		Action: action,
+		Target: msg.ID,
+		To:     msg.From,
+	})
+}
+
+// propagateUpdate sends an edit or delete notice to everyone who received
+// the original message. The new text of a private message is encrypted for
+// the recipient only, so its author gets the notice without it.
+func (s *Server) propagateUpdate(original, notice *shared.Message) {
+	if original.Type == shared.TypePublic {
+		s.broadcast(notice)
+		return
+	}
+
+	authorNotice := *notice
+	authorNotice.EncryptedKey = ""
+	authorNotice.Content = ""
+	for _, name := range []string{original.From, original.To} {
+		n := notice
+		if name == original.From {
+			n = &authorNotice
+		}
+		if u, ok := s.users.GetByUsername(name); ok {
+			if err := u.WriteMessage(n); err != nil {
+				log.Error("Failed to send notice", "type", notice.Type, "user", name, "err", err)
+			}
+		}
+	}
+}
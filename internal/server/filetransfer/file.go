@@ -4,8 +4,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// tempPrefix starts the names of uploads still being written.
+const tempPrefix = ".upload-"
+
 type FileTransfer struct {
 	uploadDir string
 }
@@ -16,16 +20,29 @@ func New(uploadDir string) *FileTransfer {
 	}
 }
 
+// Upload stores content as filename. The file is written to a temporary
+// name, synced and then renamed, so an upload cut short, for example by a
+// shutdown, never leaves a partial file behind.
 func (ft *FileTransfer) Upload(filename string, content io.Reader) error {
 	path := filepath.Join(ft.uploadDir, filename)
-	file, err := os.Create(path)
+	file, err := os.CreateTemp(ft.uploadDir, tempPrefix+"*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer os.Remove(file.Name()) // fails harmlessly once renamed
 
-	_, err = io.Copy(file, content)
-	return err
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
 }
 
 func (ft *FileTransfer) Download(filename string) (io.ReadCloser, error) {
@@ -36,3 +53,33 @@ func (ft *FileTransfer) Download(filename string) (io.ReadCloser, error) {
 func (ft *FileTransfer) UploadDir() string {
 	return ft.uploadDir
 }
+
+// Stats summarizes the upload directory.
+type Stats struct {
+	Files        int   `json:"files"`
+	PrivateFiles int   `json:"private_files"` // stored as private_<from>_to_<to>_<name>.enc
+	Bytes        int64 `json:"bytes"`
+}
+
+func (ft *FileTransfer) Stats() (Stats, error) {
+	var st Stats
+	entries, err := os.ReadDir(ft.uploadDir)
+	if err != nil {
+		return st, err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), tempPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		st.Files++
+		st.Bytes += info.Size()
+		if strings.HasPrefix(e.Name(), "private_") {
+			st.PrivateFiles++
+		}
+	}
+	return st, nil
+}
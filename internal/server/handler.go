@@ -3,61 +3,108 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"chatroom/internal/server/audit"
+	"chatroom/internal/server/plugin"
+	"chatroom/internal/server/webhook"
 	"chatroom/internal/shared"
+	"chatroom/internal/transport"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-func (s *Server) handleConnection(conn net.Conn) {
+// handleConnection runs one client session. conn is a TCP connection or a
+// WebSocket wrapped by wsConn; both carry the same newline-delimited JSON.
+func (s *Server) handleConnection(conn transport.Conn) {
+	if !s.trackConn(conn) {
+		return
+	}
+	defer s.untrackConn(conn)
+	s.metrics.connections.Inc()
+	conn = countingConn{Conn: conn, m: s.metrics}
 	defer conn.Close()
 	addr := conn.RemoteAddr()
-	log.Printf("[INFO] New connection from %s", addr)
+	log.Info("New connection", "addr", addr)
 
 	reader := bufio.NewReader(conn)
 
 	var user *shared.User
+	var resumed bool // replaced a connection the server still held
 
 	// AUTH LOOP
 	for {
 		msg, err := shared.ReadMessage(reader)
 		if err != nil {
-			log.Printf("[ERROR] Failed to read auth message from %s: %v", addr, err)
+			log.Error("Failed to read auth message", "addr", addr, "err", err)
 			return
 		}
+		s.metrics.messages.Inc(typeLabel(msg.Type))
 
 		if msg.Type != shared.TypeAuth {
+			s.metrics.authFailures.Inc("not_auth")
 			s.sendError(conn, "First message must be authentication")
 			continue
 		}
 
-		u, err := s.users.AuthenticateUser(msg.From, conn)
+		if s.shuttingDown() {
+			s.sendAuthResponse(conn, false, "Server is shutting down")
+			return
+		}
+
+		if reason, banned := s.banReason(msg.From); banned {
+			text := "You are banned from this server"
+			if reason != "" {
+				text += ": " + reason
+			}
+			s.metrics.authFailures.Inc("banned")
+			s.record(audit.AuthFailure, msg.From, "", map[string]string{"addr": addr.String(), "reason": "banned"})
+			s.sendAuthResponse(conn, false, text)
+			continue
+		}
+
+		u, token, replaced, err := s.authenticate(msg, conn)
 		if err != nil {
+			s.metrics.authFailures.Inc("rejected")
+			s.record(audit.AuthFailure, msg.From, "", map[string]string{"addr": addr.String(), "reason": err.Error()})
 			s.sendAuthResponse(conn, false, err.Error())
 			continue
 		}
 
-		user = u
+		user, resumed = u, replaced
+		details := map[string]string{"addr": addr.String()}
+		if token != "" && token == msg.Session {
+			details["resumed"] = "true"
+		}
+		s.record(audit.AuthSuccess, user.Username, "", details)
 
-		s.sendAuthResponse(conn, true, "")
+		s.sendAuthSuccess(user, token)
 		break
 	}
 
-	// Notify others about new users
-	s.broadcastUserJoin(user.Username)
-	log.Printf("[INFO] User %s joined from %s", user.Username, addr)
+	if resumed {
+		// To everyone else the user never left.
+		log.Info("User resumed session", "user", user.Username, "addr", addr)
+	} else {
+		s.presence.Join(user.Username)
+
+		// Notify others about new users
+		s.broadcastUserJoin(user.Username)
+		log.Info("User joined", "user", user.Username, "addr", addr)
+
+		// Broadcast user list update
+		s.broadcastUserList()
+		log.Info("Sent user list", "user", user.Username)
 
-	// Broadcast user list update
-	s.broadcastUserList()
-	log.Printf("[INFO] Sent user list to %s", user.Username)
+		s.notifyPlugins(plugin.Event{Kind: plugin.UserJoined, User: user.Username})
+	}
 
 	msgChan := make(chan *shared.Message, 100) // Buffered to prevent blocking
 	errChan := make(chan error, 1)
@@ -93,9 +140,16 @@ func (s *Server) handleConnection(conn net.Conn) {
 	cleanup := func() {
 		cancel()         // Signal reader to stop
 		messageWg.Wait() // Wait for message handlers
-		s.broadcastUserLeave(user.Username)
-		s.users.Remove(user.Username)
-		s.broadcastUserList()
+		if !s.users.RemoveUser(user) {
+			return // a resumed session took over
+		}
+		s.sessions.Detach(user.Username)
+		s.presence.Leave(user.Username)
+		if !s.shuttingDown() {
+			s.broadcastUserLeave(user.Username)
+			s.broadcastUserList()
+		}
+		s.notifyPlugins(plugin.Event{Kind: plugin.UserLeft, User: user.Username})
 	}
 	defer cleanup()
 
@@ -105,21 +159,26 @@ func (s *Server) handleConnection(conn net.Conn) {
 			if !ok {
 				return
 			}
-			log.Printf("Error reading message from %s: %v", user.Username, err)
+			log.Warn("Failed to read message", "user", user.Username, "err", err)
 			return
 		case msg, ok := <-msgChan:
 			if !ok {
 				return
 			}
 			msg.Timestamp = time.Now()
+			if !s.beginMessage() {
+				continue // shutting down
+			}
 			messageWg.Add(1)
 			go func(m *shared.Message) {
 				defer messageWg.Done()
+				defer s.inflight.Done()
+				defer s.metrics.observeMessage(m.Type, time.Now())
 				if err := s.handleMessage(user, m); err != nil {
-					log.Printf("Error handling message from %s: %v", user.Username, err)
+					log.Error("Failed to handle message", "user", user.Username, "err", err)
 				}
 			}(msg)
-		case <-s.done:
+		case <-s.drained:
 			return
 		}
 	}
@@ -127,100 +186,193 @@ func (s *Server) handleConnection(conn net.Conn) {
 }
 
 func (s *Server) handleMessage(user *shared.User, msg *shared.Message) error {
-	log.Printf("[DEBUG] Handling message from user %s, type: %s, content: %s",
-		user.Username, msg.Type, msg.Content)
+	log.Debug("Handling message", "user", user.Username, "type", msg.Type)
 
 	msg.From = user.Username
 	msg.Timestamp = time.Now()
 
+	if s.presence.Touch(user.Username) {
+		s.broadcastPresence()
+	}
+
+	if msg.Type == shared.TypeTyping {
+		return s.handleTyping(user, msg)
+	}
+
+	if msg.Type == shared.TypeNick {
+		err := s.handleNick(user, msg)
+		if err != nil {
+			log.Error("Failed to handle nickname change", "user", user.Username, "err", err)
+		}
+		return err
+	}
+
+	if msg.Type == shared.TypeKick || msg.Type == shared.TypeAnnounce {
+		err := s.handleAdminCommand(user, msg)
+		if err != nil {
+			log.Error("Admin command failed", "type", msg.Type, "user", user.Username, "err", err)
+		}
+		return err
+	}
+
+	if msg.Type == shared.TypeStatus {
+		log.Debug("Handling status change", "user", user.Username, "status", msg.Status)
+		err := s.handleStatus(user, msg)
+		if err != nil {
+			log.Error("Failed to handle status change", "user", user.Username, "err", err)
+		}
+		return err
+	}
+
+	if msg.Type == shared.TypeReactionAdd || msg.Type == shared.TypeReactionRemove {
+		err := s.handleReaction(user, msg)
+		if err != nil {
+			log.Error("Failed to handle message", "type", msg.Type, "user", user.Username, "err", err)
+		}
+		return err
+	}
+
+	if msg.Type == shared.TypeThreadRequest {
+		err := s.handleThreadRequest(user, msg)
+		if err != nil {
+			log.Error("Failed to send thread", "id", msg.RefID, "user", user.Username, "err", err)
+		}
+		return err
+	}
+
+	if msg.Type == shared.TypeDeliveredReceipt || msg.Type == shared.TypeReadReceipt {
+		err := s.handleReceipt(user, msg)
+		if err != nil {
+			log.Error("Failed to forward message", "type", msg.Type, "user", user.Username, "err", err)
+		}
+		return err
+	}
+
+	if msg.Type == shared.TypeEdit || msg.Type == shared.TypeDelete {
+		log.Debug("Handling message change", "type", msg.Type, "id", msg.RefID, "user", user.Username)
+		var err error
+		if msg.Type == shared.TypeEdit {
+			err = s.handleEdit(user, msg)
+		} else {
+			err = s.handleDelete(user, msg)
+		}
+		if err != nil {
+			log.Error("Failed to handle message", "type", msg.Type, "user", user.Username, "err", err)
+		}
+		return err
+	}
+
 	if msg.Type == shared.TypePrivateFileTransfer {
-		log.Printf("[DEBUG] Handling file transfer from %s", user.Username)
+		log.Debug("Handling file transfer", "user", user.Username)
 		err := s.HandlePrivateFileTransfer(user, msg)
 		if err != nil {
-			log.Printf("[ERROR] Failed to handle file transfer from %s: %v", user.Username, err)
+			log.Error("Failed to handle file transfer", "user", user.Username, "err", err)
 		}
 		return err
 
 	}
 
 	if msg.Type == shared.TypePrivateFileDownload {
-		log.Printf("[DEBUG] Handling file download request from %s for file %s",
-			user.Username, msg.Filename)
+		log.Debug("Handling file download request", "user", user.Username, "file", msg.Filename)
 		err := s.HandlePrivateFileRequest(user, msg)
 		if err != nil {
-			log.Printf("[ERROR] Failed to handle file request from %s: %v",
-				user.Username, err)
+			log.Error("Failed to handle file request", "user", user.Username, "err", err)
 		}
 		return err
 	}
 
 	if msg.Type == shared.TypeFileDownload {
-		log.Printf("[DEBUG] Handling file download request from %s for file %s",
-			user.Username, msg.Filename)
+		log.Debug("Handling file download request", "user", user.Username, "file", msg.Filename)
 		err := s.HandleFileRequest(user, msg)
 		if err != nil {
-			log.Printf("[ERROR] Failed to handle file request from %s: %v",
-				user.Username, err)
+			log.Error("Failed to handle file request", "user", user.Username, "err", err)
 		}
 		return err
 	}
 
 	if msg.Type == shared.TypeFileTransfer {
-		log.Printf("[DEBUG] Handling file transfer from %s", user.Username)
+		log.Debug("Handling file transfer", "user", user.Username)
 		err := s.HandleFileTransfer(user, msg)
 		if err != nil {
-			log.Printf("[ERROR] Failed to handle file transfer from %s: %v", user.Username, err)
+			log.Error("Failed to handle file transfer", "user", user.Username, "err", err)
 		}
 		return err
 	}
 
+	if msg.Type == shared.TypeLeave {
+		// A client that logs out does not come back to this session.
+		s.sessions.Revoke(user.Username)
+		return nil
+	}
+
 	if msg.Type == shared.TypeReconnect {
-		log.Printf("[DEBUG] Handling reconnect from %s", user.Username)
-		err := s.handleReconnect(user)
+		log.Debug("Handling reconnect", "user", user.Username)
+		err := s.handleReconnect(user, msg)
 		if err != nil {
-			log.Printf("[ERROR] Failed to handle reconnect from %s: %v",
-				user.Username, err)
+			log.Error("Failed to handle reconnect", "user", user.Username, "err", err)
 		}
 		return err
 	}
 
 	if msg.Type == shared.TypePublicKeyRequest {
-		log.Printf("[DEBUG] Handling public key request from %s for %s",
-			user.Username, msg.To)
+		log.Debug("Handling public key request", "user", user.Username, "for", msg.To)
 		err := s.handlePublicKeyRequest(msg)
 		if err != nil {
-			log.Printf("[ERROR] Failed to handle public key request from %s: %v",
-				user.Username, err)
+			log.Error("Failed to handle public key request", "user", user.Username, "err", err)
 		}
 		return err
 	}
 
 	if msg.Type == shared.TypePublicKey {
 
-		log.Printf("[DEBUG] Processing public key from %s", user.Username)
+		log.Debug("Processing public key", "user", user.Username)
 		err := s.handlePublicKey(user, msg)
 		s.sendRoomKey(user.Username, user.Conn)
 		if err != nil {
-			log.Printf("[ERROR] Failed to process public key from %s: %v", user.Username, err)
+			log.Error("Failed to process public key", "user", user.Username, "err", err)
 		}
 	}
 
 	if msg.Type == shared.TypePrivate {
-		log.Printf("[DEBUG] Processing private message from %s", user.Username)
+		log.Debug("Processing private message", "user", user.Username)
 		err := s.handlePrivateMessage(user, msg) // Pass user object
 		if err != nil {
-			log.Printf("[ERROR] Failed to handle private message from %s: %v", user.Username, err)
+			log.Error("Failed to handle private message", "user", user.Username, "err", err)
 		}
 		return err
 	} else if msg.Type == shared.TypePublic {
-		log.Printf("[DEBUG] Broadcasting public message from %s", user.Username)
-		err := s.broadcastPublicMessage(msg)
+		log.Debug("Broadcasting public message", "user", user.Username)
+		clearServerFields(msg)
+		if err := s.assignMessageID(msg); err != nil {
+			s.sendErrorToConn(user.Conn, err.Error())
+			return err
+		}
+		if err := s.resolveThreadParent(msg); err != nil {
+			s.sendErrorToConn(user.Conn, "Cannot reply: "+err.Error())
+			return err
+		}
+		flush, err := s.runPlugins(s.messageEvent(msg))
 		if err != nil {
-			log.Printf("[ERROR] Failed to broadcast message from %s: %v", user.Username, err)
+			s.sendErrorToConn(user.Conn, err.Error())
+			flush()
+			return err
+		}
+		if !s.history.Add(msg) {
+			flush()
+			return s.rejectDuplicate(user, msg)
+		}
+		err = s.broadcastPublicMessage(msg)
+		if msg.ParentID != "" {
+			s.broadcastThreadUpdate(msg.ParentID)
+		}
+		flush()
+		s.publishMessageWebhook(msg)
+		if err != nil {
+			log.Error("Failed to broadcast message", "user", user.Username, "err", err)
 		}
 		return err
 	} else {
-		log.Printf("[WARN] Unknown message type %s from %s", msg.Type, user.Username)
+		log.Warn("Unknown message type", "type", msg.Type, "user", user.Username)
 		return nil
 	}
 }
@@ -229,58 +381,129 @@ func (s *Server) handlePrivateMessage(user *shared.User, msg *shared.Message) er
 	targetUsername := msg.To
 	msg.Type = shared.TypePrivate
 	msg.To = targetUsername
+	msg.ParentID = "" // threads are only kept for the public room
+	clearServerFields(msg)
+	if err := s.assignMessageID(msg); err != nil {
+		s.sendErrorToConn(user.Conn, err.Error())
+		return err
+	}
 
 	if strings.TrimSpace(targetUsername) == strings.TrimSpace(msg.From) {
 		s.sendErrorToConn(user.Conn, "Cannot send private message to yourself")
 		return fmt.Errorf("user %s attempted to message themselves", msg.From)
 	}
 
-	log.Printf("[DEBUG] Private message from %s to %s: %s",
-		msg.From, targetUsername, msg.Content)
+	log.Debug("Private message", "id", msg.ID, "from", msg.From, "to", targetUsername)
 
 	// Find target user
 	targetUser, exists := s.users.GetByUsername(targetUsername)
-	log.Printf("[DEBUG] Target raw: %q bytes=%v", targetUsername, []byte(targetUsername))
-
-	for _, u := range s.users.GetAll() {
-		log.Printf("[DEBUG] user: username=%q addr=%p", u.Username, u)
-	}
-
-	log.Printf("[DEBUG] Lookup for target user %s: exists=%v", targetUsername, exists)
 	if !exists {
-		log.Printf("[ERROR] Target user not found: %s", targetUsername)
+		log.Warn("Private message target not found", "from", msg.From, "to", targetUsername)
 		s.sendErrorToConn(user.Conn, "User "+targetUsername+" not found")
 		return fmt.Errorf("target user not found: %s", targetUsername)
 	}
 
+	flush, err := s.runPlugins(s.messageEvent(msg))
+	defer flush()
+	if err != nil {
+		s.sendErrorToConn(user.Conn, err.Error())
+		return err
+	}
+
+	if !s.history.Add(msg) {
+		return s.rejectDuplicate(user, msg)
+	}
+
 	// Use thread-safe write
 	if err := targetUser.WriteMessage(msg); err != nil {
-		log.Printf("[ERROR] Failed to send to target %s: %v", targetUsername, err)
+		log.Error("Failed to send private message", "to", targetUsername, "err", err)
 		return fmt.Errorf("failed to send to target: %v", err)
 	}
-	log.Printf("[DEBUG] Message sent to target %s", targetUsername)
+	log.Debug("Private message sent", "id", msg.ID, "to", targetUsername)
 
 	// Send to sender using their connection directly
 	if err := user.WriteMessage(msg); err != nil {
-		log.Printf("[ERROR] Failed to send confirmation to sender %s: %v", msg.From, err)
+		log.Error("Failed to send confirmation to sender", "user", msg.From, "err", err)
 		return fmt.Errorf("failed to send to sender: %v", err)
 	}
-	log.Printf("[DEBUG] Confirmation sent to sender %s", msg.From)
+	log.Debug("Confirmation sent to sender", "id", msg.ID, "user", msg.From)
+
+	return nil
+}
+
+// clearServerFields drops what a client may not say about its own message:
+// its reactions, replies and edit state are kept by the server, and a
+// thread listing or session token has no place in a chat message.
+func clearServerFields(msg *shared.Message) {
+	msg.Reactions = nil
+	msg.ReplyCount = 0
+	msg.Edited = false
+	msg.Deleted = false
+	msg.Messages = nil
+	msg.Session = ""
+}
 
+// assignMessageID gives msg an ID unless the client chose one, which lets
+// the sender show its message before the server echoes it. Edits,
+// reactions, threads and the replay after a reconnect find messages by ID,
+// so a client's ID must not be in use already.
+func (s *Server) assignMessageID(msg *shared.Message) error {
+	if msg.ID == "" {
+		msg.ID = shared.GenerateID()
+		return nil
+	}
+	if _, exists := s.history.Get(msg.ID); exists {
+		return fmt.Errorf("message ID %s is already in use", msg.ID)
+	}
 	return nil
 }
 
+// rejectDuplicate refuses a message that lost the race for its ID to
+// another one stored since assignMessageID checked it.
+func (s *Server) rejectDuplicate(user *shared.User, msg *shared.Message) error {
+	err := fmt.Errorf("message ID %s is already in use", msg.ID)
+	s.sendErrorToConn(user.Conn, err.Error())
+	return err
+}
+
+// handleReceipt forwards a delivered/read receipt for a private message back
+// to the user who sent it.
+func (s *Server) handleReceipt(user *shared.User, msg *shared.Message) error {
+	if msg.RefID == "" || msg.To == "" {
+		return fmt.Errorf("receipt from %s is missing message id or recipient", user.Username)
+	}
+
+	// Only the recipient of a private message may confirm it, and only to
+	// its sender.
+	orig, ok := s.history.Get(msg.RefID)
+	if !ok || orig.Type != shared.TypePrivate || orig.From != msg.To || orig.To != user.Username {
+		return fmt.Errorf("receipt from %s for a message they did not receive: %s", user.Username, msg.RefID)
+	}
+
+	sender, exists := s.users.GetByUsername(msg.To)
+	if !exists {
+		return fmt.Errorf("receipt target not found: %s", msg.To)
+	}
+
+	receipt := &shared.Message{
+		Type:      msg.Type,
+		From:      user.Username,
+		To:        msg.To,
+		RefID:     msg.RefID,
+		Timestamp: time.Now(),
+	}
+	return sender.WriteMessage(receipt)
+}
+
 func (s *Server) broadcastPublicMessage(msg *shared.Message) error {
 	for _, user := range s.users.GetAll() {
-		log.Printf("[DEBUG] broadcast: sender=%q | current=%q", msg.From, user.Username)
-
 		if strings.TrimSpace(user.Username) == strings.TrimSpace(msg.From) {
-			log.Printf("[DEBUG] Skipping sender %s", user.Username)
 			continue
 		}
 
 		if err := user.WriteMessage(msg); err != nil {
-			log.Printf("[ERROR] Failed to send to %s: %v", user.Username, err)
+			s.metrics.deliveryErrors.Inc()
+			log.Error("Failed to deliver message", "user", user.Username, "err", err)
 		}
 	}
 	return nil
@@ -290,6 +513,7 @@ func (s *Server) broadcastUserList() {
 	msg := &shared.Message{
 		Type:      shared.TypeUserList,
 		Users:     s.users.GetUsernames(),
+		Presence:  s.presence.Snapshot(),
 		Timestamp: time.Now(),
 	}
 	s.broadcast(msg)
@@ -298,19 +522,23 @@ func (s *Server) broadcastUserList() {
 func (s *Server) broadcastUserJoin(username string) {
 	msg := &shared.Message{
 		Type:      shared.TypeJoin,
+		From:      username,
 		Content:   username + " has joined the chat",
 		Timestamp: time.Now(),
 	}
 	s.broadcast(msg)
+	s.publishWebhook(webhook.Event{Type: webhook.EventUserJoined, Time: msg.Timestamp, User: username})
 }
 
 func (s *Server) broadcastUserLeave(username string) {
 	msg := &shared.Message{
 		Type:      shared.TypeLeave,
+		From:      username,
 		Content:   username + " has left the chat",
 		Timestamp: time.Now(),
 	}
 	s.broadcast(msg)
+	s.publishWebhook(webhook.Event{Type: webhook.EventUserLeft, Time: msg.Timestamp, User: username})
 }
 
 func (s *Server) sendError(connOrUsername interface{}, errMsg string) {
@@ -321,7 +549,7 @@ func (s *Server) sendError(connOrUsername interface{}, errMsg string) {
 	}
 
 	switch v := connOrUsername.(type) {
-	case net.Conn:
+	case transport.Conn:
 		shared.WriteMessage(v, msg)
 	case string:
 		if user, exists := s.users.GetByUsername(v); exists {
@@ -330,7 +558,7 @@ func (s *Server) sendError(connOrUsername interface{}, errMsg string) {
 	}
 }
 
-func (s *Server) sendAuthResponse(conn net.Conn, success bool, errorMsg string) {
+func (s *Server) sendAuthResponse(conn transport.Conn, success bool, errorMsg string) {
 	msg := &shared.Message{
 		Type:      shared.TypeAuthResponse,
 		Success:   success,
@@ -340,19 +568,29 @@ func (s *Server) sendAuthResponse(conn net.Conn, success bool, errorMsg string)
 	shared.WriteMessage(conn, msg)
 }
 
-func (s *Server) broadcast(msg *shared.Message) {
-	log.Printf("[DEBUG] Attempting to broadcast message: Type=%s, From=%s, Content=%s",
-		msg.Type, msg.From, msg.Content)
+func (s *Server) sendAuthSuccess(user *shared.User, token string) {
+	msg := &shared.Message{
+		Type:      shared.TypeAuthResponse,
+		To:        user.Username,
+		Success:   true,
+		Admin:     s.IsAdmin(user.Username),
+		Session:   token,
+		Timestamp: time.Now(),
+	}
+	user.WriteMessage(msg)
+}
 
+func (s *Server) broadcast(msg *shared.Message) {
 	select {
 	case s.broadcastCh <- msg:
-		log.Printf("[DEBUG] Message successfully queued for broadcast")
+		log.Debug("Queued broadcast", "type", msg.Type, "id", msg.ID, "from", msg.From)
 	default:
-		log.Printf("[ERROR] Broadcast channel full, message dropped: %+v", msg)
+		s.metrics.broadcastsDropped.Inc()
+		log.Error("Broadcast channel full, message dropped", "type", msg.Type, "id", msg.ID, "from", msg.From)
 	}
 }
 
-func (s *Server) sendErrorToConn(conn net.Conn, errMsg string) {
+func (s *Server) sendErrorToConn(conn transport.Conn, errMsg string) {
 	msg := &shared.Message{
 		Type:      shared.TypeError,
 		Content:   errMsg,
@@ -362,32 +600,31 @@ func (s *Server) sendErrorToConn(conn net.Conn, errMsg string) {
 }
 
 func (s *Server) handlePublicKey(user *shared.User, msg *shared.Message) error {
-	log.Printf("[DEBUG] Received public key from %s", user.Username)
+	log.Debug("Received public key", "user", user.Username)
 	pemData := []byte(msg.Content)
 	pubKey, err := shared.ParsePublicKeyFromPEM(pemData)
 	if err != nil {
 		return fmt.Errorf("invalid public key from %s: %v", user.Username, err)
 	}
 	s.users.SetPublicKey(user.Username, pubKey)
-	log.Printf("[INFO] Stored public key for user %s", user.Username)
+	log.Info("Stored public key", "user", user.Username)
 	return nil
 }
 
-func (s *Server) sendRoomKey(username string, conn net.Conn) {
+func (s *Server) sendRoomKey(username string, conn transport.Conn) {
 	user, exists := s.users.GetByUsername(username)
 	if !exists {
-		log.Printf("[ERROR] Cannot send room key, user not found: %s", username)
+		log.Error("Cannot send room key, user not found", "user", username)
 		return
 	}
 	if user.PublicKey == nil {
-		log.Printf("[ERROR] Cannot send room key, public key not set for user: %s", username)
+		log.Error("Cannot send room key, public key not set", "user", username)
 		return
 	}
 
 	encKeyB64, err := shared.EncryptRoomKey(user.PublicKey, s.roomKey)
-	fmt.Print("Encrypted room key for user ", username, ": ", encKeyB64, "\n")
 	if err != nil {
-		log.Printf("[ERROR] Failed to encrypt room key for %s: %v", username, err)
+		log.Error("Failed to encrypt room key", "user", username, "err", err)
 		return
 	}
 
@@ -400,9 +637,10 @@ func (s *Server) sendRoomKey(username string, conn net.Conn) {
 	}
 
 	if err := user.WriteMessage(msg); err != nil {
-		log.Printf("[ERROR] Failed to send room key to %s: %v", username, err)
+		log.Error("Failed to send room key", "user", username, "err", err)
 	} else {
-		log.Printf("[INFO] Sent room key to %s", username)
+		log.Info("Sent room key", "user", username)
+		s.record(audit.RoomKeySent, "", username, nil)
 	}
 }
 func (s *Server) handlePublicKeyRequest(msg *shared.Message) error {
@@ -447,26 +685,33 @@ func (s *Server) handlePublicKeyRequest(msg *shared.Message) error {
 	return requester.WriteMessage(resp)
 }
 
-func (s *Server) handleReconnect(user *shared.User) error {
-	log.Printf("[DEBUG] Handling reconnect for user %s", user.Username)
+// handleReconnect brings a client that reconnected up to date. msg.RefID is
+// the last chat message it received, if any.
+func (s *Server) handleReconnect(user *shared.User, msg *shared.Message) error {
+	log.Debug("Handling reconnect", "user", user.Username)
 	s.sendRoomKey(user.Username, user.Conn)
 
 	userListMsg := &shared.Message{
 		Type:      shared.TypeUserList,
 		Users:     s.users.GetUsernames(),
+		Presence:  s.presence.Snapshot(),
 		Timestamp: time.Now(),
 	}
 	if err := user.WriteMessage(userListMsg); err != nil {
-		log.Printf("[ERROR] Failed to resend user list to %s: %v", user.Username, err)
+		log.Error("Failed to resend user list", "user", user.Username, "err", err)
 		return err
 	}
-	log.Printf("[INFO] Resent user list to %s", user.Username)
+	log.Info("Resent user list", "user", user.Username)
+
+	if msg.RefID != "" {
+		s.replayMissed(user, msg.RefID)
+	}
 	return nil
 }
 
 func (s *Server) HandleFileTransfer(user *shared.User, msg *shared.Message) error {
 	if msg.Filename == "" || msg.Content == "" {
-		log.Printf("[WARN] Invalid file transfer message from %s", user.Username)
+		log.Warn("Invalid file transfer message", "user", user.Username)
 		s.sendError(user.Username, "Invalid file transfer message (missing filename or content)")
 		return fmt.Errorf("invalid file message from %s", user.Username)
 	}
@@ -475,12 +720,18 @@ func (s *Server) HandleFileTransfer(user *shared.User, msg *shared.Message) erro
 
 	reader := bytes.NewReader([]byte(msg.Content))
 	if err := s.fileTransfer.Upload(filename, reader); err != nil {
-		log.Printf("[ERROR] Failed to save file %s: %v", filename, err)
+		log.Error("Failed to save file", "file", filename, "err", err)
 		s.sendError(user.Username, fmt.Sprintf("Failed to save file %s", filename))
 		return err
 	}
 
-	log.Printf("[INFO] File received: %s from %s", filename, user.Username)
+	s.metrics.uploadSize.Observe(float64(len(msg.Content)), "public")
+	log.Info("File received", "file", filename, "user", user.Username)
+	s.record(audit.FileUpload, user.Username, filename, map[string]string{
+		"visibility": "public",
+		"bytes":      strconv.Itoa(len(msg.Content)),
+	})
+	defer s.notifyPlugins(plugin.Event{Kind: plugin.FileUploaded, User: user.Username, Filename: filename})
 
 	ack := &shared.Message{
 		Type:      shared.TypeInfo,
@@ -497,6 +748,12 @@ func (s *Server) HandleFileTransfer(user *shared.User, msg *shared.Message) erro
 		Timestamp: time.Now(),
 	}
 	s.broadcast(notify)
+	s.publishWebhook(webhook.Event{
+		Type:     webhook.EventFileAvailable,
+		Time:     notify.Timestamp,
+		User:     user.Username,
+		Filename: filename,
+	})
 
 	return nil
 }
@@ -512,7 +769,7 @@ func (s *Server) HandleFileRequest(user *shared.User, msg *shared.Message) error
 
 	file, err := os.Open(path)
 	if err != nil {
-		log.Printf("[ERROR] Failed to open requested file %s: %v", filename, err)
+		log.Error("Failed to open requested file", "file", filename, "err", err)
 		s.sendError(user.Username, fmt.Sprintf("File '%s' not found", filename))
 		return err
 	}
@@ -520,7 +777,7 @@ func (s *Server) HandleFileRequest(user *shared.User, msg *shared.Message) error
 
 	data, err := io.ReadAll(file)
 	if err != nil {
-		log.Printf("[ERROR] Failed to read file %s: %v", filename, err)
+		log.Error("Failed to read file", "file", filename, "err", err)
 		s.sendError(user.Username, fmt.Sprintf("Failed to read file '%s'", filename))
 		return err
 	}
@@ -534,17 +791,18 @@ func (s *Server) HandleFileRequest(user *shared.User, msg *shared.Message) error
 	}
 
 	if err := user.WriteMessage(resp); err != nil {
-		log.Printf("[ERROR] Failed to send file %s to %s: %v", filename, user.Username, err)
+		log.Error("Failed to send file", "file", filename, "user", user.Username, "err", err)
 		return err
 	}
 
-	log.Printf("[INFO] Sent file '%s' to %s successfully", filename, user.Username)
+	log.Info("Sent file", "file", filename, "user", user.Username)
+	s.record(audit.FileDownload, user.Username, filename, map[string]string{"visibility": "public"})
 	return nil
 }
 
 func (s *Server) HandlePrivateFileTransfer(user *shared.User, msg *shared.Message) error {
 	if msg.Filename == "" || msg.Content == "" || msg.To == "" || msg.EncryptedKey == "" {
-		log.Printf("[WARN] Invalid private file transfer message from %s", user.Username)
+		log.Warn("Invalid private file transfer message", "user", user.Username)
 		s.sendError(user.Username, "Invalid private file transfer message (missing filename, content, encrypted key, or recipient)")
 		return fmt.Errorf("invalid private file message from %s", user.Username)
 	}
@@ -570,7 +828,7 @@ func (s *Server) HandlePrivateFileTransfer(user *shared.User, msg *shared.Messag
 
 	jsonData, err := json.Marshal(fileData)
 	if err != nil {
-		log.Printf("[ERROR] Failed to marshal file data: %v", err)
+		log.Error("Failed to marshal file data", "err", err)
 		s.sendError(user.Username, "Failed to process file data")
 		return err
 	}
@@ -579,12 +837,25 @@ func (s *Server) HandlePrivateFileTransfer(user *shared.User, msg *shared.Messag
 	reader := bytes.NewReader(jsonData)
 
 	if err := s.fileTransfer.Upload(privateFilename, reader); err != nil {
-		log.Printf("[ERROR] Failed to save private file %s: %v", privateFilename, err)
+		log.Error("Failed to save private file", "file", privateFilename, "err", err)
 		s.sendError(user.Username, fmt.Sprintf("Failed to save file %s", filename))
 		return err
 	}
 
-	log.Printf("[INFO] Private file received: %s from %s to %s", filename, user.Username, msg.To)
+	s.metrics.uploadSize.Observe(float64(len(msg.Content)), "private")
+	log.Info("Private file received", "file", filename, "from", user.Username, "to", msg.To)
+	s.record(audit.FileUpload, user.Username, filename, map[string]string{
+		"visibility": "private",
+		"to":         msg.To,
+		"bytes":      strconv.Itoa(len(msg.Content)),
+	})
+	defer s.notifyPlugins(plugin.Event{
+		Kind:     plugin.FileUploaded,
+		User:     user.Username,
+		To:       msg.To,
+		Private:  true,
+		Filename: filename,
+	})
 
 	ack := &shared.Message{
 		Type:      shared.TypeInfo,
@@ -602,6 +873,14 @@ func (s *Server) HandlePrivateFileTransfer(user *shared.User, msg *shared.Messag
 		Timestamp: time.Now(),
 	}
 	recipient.WriteMessage(notify)
+	s.publishWebhook(webhook.Event{
+		Type:     webhook.EventFileAvailable,
+		Time:     notify.Timestamp,
+		User:     user.Username,
+		To:       msg.To,
+		Private:  true,
+		Filename: filename,
+	})
 
 	return nil
 }
@@ -619,7 +898,7 @@ func (s *Server) HandlePrivateFileRequest(user *shared.User, msg *shared.Message
 
 	file, err := os.Open(path)
 	if err != nil {
-		log.Printf("[ERROR] Failed to open requested private file %s: %v", privateFilename, err)
+		log.Error("Failed to open requested private file", "file", privateFilename, "err", err)
 		s.sendError(user.Username, fmt.Sprintf("Private file '%s' from %s not found", filename, msg.To))
 		return err
 	}
@@ -627,14 +906,14 @@ func (s *Server) HandlePrivateFileRequest(user *shared.User, msg *shared.Message
 
 	jsonData, err := io.ReadAll(file)
 	if err != nil {
-		log.Printf("[ERROR] Failed to read private file %s: %v", privateFilename, err)
+		log.Error("Failed to read private file", "file", privateFilename, "err", err)
 		s.sendError(user.Username, fmt.Sprintf("Failed to read file '%s'", filename))
 		return err
 	}
 
 	var fileData shared.EncryptedFileData
 	if err := json.Unmarshal(jsonData, &fileData); err != nil {
-		log.Printf("[ERROR] Failed to unmarshal file data: %v", err)
+		log.Error("Failed to unmarshal file data", "err", err)
 		s.sendError(user.Username, "Failed to process file data")
 		return err
 	}
@@ -650,10 +929,11 @@ func (s *Server) HandlePrivateFileRequest(user *shared.User, msg *shared.Message
 	}
 
 	if err := user.WriteMessage(resp); err != nil {
-		log.Printf("[ERROR] Failed to send private file %s to %s: %v", filename, user.Username, err)
+		log.Error("Failed to send private file", "file", filename, "user", user.Username, "err", err)
 		return err
 	}
 
-	log.Printf("[INFO] Sent private file '%s' from %s to %s successfully", filename, msg.To, user.Username)
+	log.Info("Sent private file", "file", filename, "from", msg.To, "to", user.Username)
+	s.record(audit.FileDownload, user.Username, filename, map[string]string{"visibility": "private", "from": msg.To})
 	return nil
 }
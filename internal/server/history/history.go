@@ -0,0 +1,238 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"chatroom/internal/shared"
+)
+
+var ErrNotFound = errors.New("message not found")
+
+// Store keeps the most recent chat messages (still encrypted) so they can be
+// looked up by ID after they were delivered.
+type Store struct {
+	path     string
+	limit    int
+	messages []*shared.Message
+	index    map[string]*shared.Message // message ID -> message
+	threads  map[string][]string        // parent ID -> reply IDs, oldest first
+	mu       sync.RWMutex
+}
+
+func New(path string, limit int) *Store {
+	return &Store{
+		path:    path,
+		limit:   limit,
+		index:   make(map[string]*shared.Message),
+		threads: make(map[string][]string),
+	}
+}
+
+// Add stores a copy of msg and reports whether it did. Messages without an
+// ID, or with the ID of a stored message, are not stored.
+func (s *Store) Add(msg *shared.Message) bool {
+	if msg == nil || msg.ID == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.index[msg.ID]; exists {
+		return false
+	}
+
+	stored := clone(msg)
+	s.messages = append(s.messages, stored)
+	s.index[stored.ID] = stored
+	s.indexReply(stored)
+
+	if s.limit > 0 && len(s.messages) > s.limit {
+		drop := len(s.messages) - s.limit
+		for _, old := range s.messages[:drop] {
+			delete(s.index, old.ID)
+			delete(s.threads, old.ID)
+			s.unlinkReply(old)
+		}
+		s.messages = append([]*shared.Message(nil), s.messages[drop:]...)
+	}
+	return true
+}
+
+// Get returns a copy of the message with the given ID.
+func (s *Store) Get(id string) (*shared.Message, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msg, ok := s.index[id]
+	if !ok {
+		return nil, false
+	}
+	return clone(msg), true
+}
+
+// Update applies fn to the stored message and returns a copy of the result.
+// If fn returns an error the message is left untouched.
+func (s *Store) Update(id string, fn func(msg *shared.Message) error) (*shared.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.index[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	updated := clone(msg)
+	if err := fn(updated); err != nil {
+		return nil, err
+	}
+	*msg = *updated
+
+	return clone(updated), nil
+}
+
+// Thread returns the parent message and its replies in the order they were
+// posted.
+func (s *Store) Thread(parentID string) (*shared.Message, []*shared.Message, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	parent, ok := s.index[parentID]
+	if !ok {
+		return nil, nil, false
+	}
+
+	replies := make([]*shared.Message, 0, len(s.threads[parentID]))
+	for _, id := range s.threads[parentID] {
+		if reply, ok := s.index[id]; ok {
+			replies = append(replies, clone(reply))
+		}
+	}
+	return clone(parent), replies, true
+}
+
+// Since returns copies of the messages posted after the one with the given
+// ID, oldest first. It reports false if that message is no longer kept.
+func (s *Store) Since(id string) ([]*shared.Message, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].ID != id {
+			continue
+		}
+		after := make([]*shared.Message, 0, len(s.messages)-i-1)
+		for _, msg := range s.messages[i+1:] {
+			after = append(after, clone(msg))
+		}
+		return after, true
+	}
+	return nil, false
+}
+
+// indexReply links a reply to its parent and bumps the parent's reply count.
+// Callers must hold s.mu.
+func (s *Store) indexReply(msg *shared.Message) {
+	if msg.ParentID == "" {
+		return
+	}
+	s.threads[msg.ParentID] = append(s.threads[msg.ParentID], msg.ID)
+	s.countReplies(msg.ParentID)
+}
+
+// unlinkReply removes an evicted reply from its parent's thread. Callers must
+// hold s.mu and have removed msg from s.index.
+func (s *Store) unlinkReply(msg *shared.Message) {
+	if msg.ParentID == "" {
+		return
+	}
+	ids := s.threads[msg.ParentID]
+	for i, id := range ids {
+		if id == msg.ID {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(s.threads, msg.ParentID)
+	} else {
+		s.threads[msg.ParentID] = ids
+	}
+	s.countReplies(msg.ParentID)
+}
+
+// countReplies sets the parent's reply count to the replies still kept, so
+// it always matches what Thread returns. Callers must hold s.mu.
+func (s *Store) countReplies(parentID string) {
+	parent, ok := s.index[parentID]
+	if !ok {
+		return
+	}
+	n := 0
+	for _, id := range s.threads[parentID] {
+		if _, ok := s.index[id]; ok {
+			n++
+		}
+	}
+	parent.ReplyCount = n
+}
+
+// clone copies msg deeply enough that the copy can be changed without
+// touching the stored message.
+func clone(msg *shared.Message) *shared.Message {
+	cp := *msg
+	if msg.Reactions != nil {
+		cp.Reactions = make(map[string][]string, len(msg.Reactions))
+		for emoji, users := range msg.Reactions {
+			cp.Reactions[emoji] = append([]string(nil), users...)
+		}
+	}
+	return &cp
+}
+
+// Len returns how many messages are kept.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.messages)
+}
+
+func (s *Store) Save() error {
+	s.mu.RLock()
+	data, err := json.MarshalIndent(s.messages, "", "  ")
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+func (s *Store) Load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var messages []*shared.Message
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	s.index = make(map[string]*shared.Message)
+	s.threads = make(map[string][]string)
+	for _, msg := range messages {
+		if msg == nil || msg.ID == "" {
+			continue
+		}
+		s.messages = append(s.messages, msg)
+		s.index[msg.ID] = msg
+		s.indexReply(msg)
+	}
+	return nil
+}
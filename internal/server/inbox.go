@@ -0,0 +1,105 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// inbox feeds handleConnection from connections that are not a plain byte
+// stream, such as wsConn and ircConn. Their receive goroutines put complete
+// newline-terminated JSON messages into it, and Read hands them out while
+// honoring the read deadline the connection handler sets.
+type inbox struct {
+	msgs     chan []byte
+	gone     chan struct{} // closed by stop
+	goneOnce sync.Once
+	err      error // why the sender stopped, set before gone is closed
+	buf      []byte
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func newInbox() *inbox {
+	return &inbox{
+		msgs: make(chan []byte, 16),
+		gone: make(chan struct{}),
+	}
+}
+
+// put queues one message. It returns false once closed is closed or the
+// inbox was stopped.
+func (in *inbox) put(data []byte, closed <-chan struct{}) bool {
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	select {
+	case in.msgs <- data:
+		return true
+	case <-closed:
+		return false
+	case <-in.gone:
+		return false
+	}
+}
+
+// stop makes Read return err, or io.EOF for a nil err, once the queued
+// messages are used up.
+func (in *inbox) stop(err error) {
+	in.goneOnce.Do(func() {
+		in.err = err
+		close(in.gone)
+	})
+}
+
+func (in *inbox) Read(p []byte) (int, error) {
+	if len(in.buf) == 0 {
+		in.mu.Lock()
+		deadline := in.deadline
+		in.mu.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			t := time.NewTimer(time.Until(deadline))
+			defer t.Stop()
+			timeout = t.C
+		}
+
+		select {
+		case data := <-in.msgs:
+			in.buf = data
+		case <-in.gone:
+			select {
+			case data := <-in.msgs:
+				in.buf = data
+			default:
+				if in.err == nil {
+					return 0, io.EOF
+				}
+				return 0, in.err
+			}
+		case <-timeout:
+			return 0, readTimeout{}
+		}
+	}
+
+	n := copy(p, in.buf)
+	in.buf = in.buf[n:]
+	return n, nil
+}
+
+func (in *inbox) SetReadDeadline(t time.Time) error {
+	in.mu.Lock()
+	in.deadline = t
+	in.mu.Unlock()
+	return nil
+}
+
+// readTimeout is the net.Error an inbox Read returns when its deadline
+// passes, which handleConnection treats as "nothing to read yet".
+type readTimeout struct{}
+
+func (readTimeout) Error() string   { return "i/o timeout" }
+func (readTimeout) Timeout() bool   { return true }
+func (readTimeout) Temporary() bool { return true }
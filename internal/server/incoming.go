@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"chatroom/internal/server/plugin"
+	"chatroom/internal/shared"
+)
+
+// maxIncomingBody limits the size of an incoming webhook request.
+const maxIncomingBody = 64 << 10
+
+// Integration is an external system allowed to post into the room, such as
+// a CI server. Its messages appear as coming from Name.
+type Integration struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+type IncomingConfig struct {
+	Listen       string        `json:"listen"` // e.g. "127.0.0.1:9080"
+	Integrations []Integration `json:"integrations"`
+}
+
+// LoadIncomingConfig reads the incoming webhook settings from a JSON file.
+func LoadIncomingConfig(path string) (*IncomingConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg IncomingConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid incoming webhook config %s: %v", path, err)
+	}
+	if cfg.Listen == "" {
+		return nil, fmt.Errorf("incoming webhook config %s has no listen address", path)
+	}
+	for i, in := range cfg.Integrations {
+		in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+		if !shared.IsValidUsername(in.Name) || len(in.Token) < 16 {
+			return nil, fmt.Errorf("integration %d needs a valid name and a token of at least 16 characters", i+1)
+		}
+		cfg.Integrations[i] = in
+	}
+	return &cfg, nil
+}
+
+// EnableIncomingWebhooks serves the incoming webhook endpoint on cfg.Listen
+// once the server starts. Call it before Start.
+func (s *Server) EnableIncomingWebhooks(cfg *IncomingConfig) {
+	s.incoming = cfg
+}
+
+// serveIncoming runs the HTTP listener until the server shuts down.
+func (s *Server) serveIncoming(cfg *IncomingConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hooks/message", s.handleIncomingMessage)
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Error("Incoming webhooks disabled", "err", err)
+		return
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-s.stopping
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	log.Info("Incoming webhooks listening", "addr", ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Incoming webhook listener stopped", "err", err)
+	}
+}
+
+// incomingMessage is the request body of POST /hooks/message.
+type incomingMessage struct {
+	Text     string `json:"text"`
+	ParentID string `json:"parent_id,omitempty"` // reply in this message's thread
+}
+
+// handleIncomingMessage posts a message as the integration owning the bearer
+// token:
+//
+//	curl -H "Authorization: Bearer $TOKEN" -d '{"text":"deploy finished"}' \
+//	     http://127.0.0.1:9080/hooks/message
+func (s *Server) handleIncomingMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	in, ok := s.authenticateIntegration(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "invalid or missing token")
+		return
+	}
+
+	var body incomingMessage
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxIncomingBody))
+	if err := dec.Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	body.Text = strings.TrimSpace(body.Text)
+	if body.Text == "" {
+		writeJSONError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+	if !s.beginMessage() {
+		writeJSONError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	defer s.inflight.Done()
+	msg, err := s.postAsIntegration(in.Name, body.Text, body.ParentID)
+	if err != nil {
+		var veto *plugin.VetoError
+		if errors.As(err, &veto) {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+		} else {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	log.Info("Integration posted message", "integration", in.Name, "id", msg.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": msg.ID})
+}
+
+func (s *Server) authenticateIntegration(r *http.Request) (Integration, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return Integration{}, false
+	}
+	for _, in := range s.incoming.Integrations {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(in.Token)) == 1 {
+			return in, true
+		}
+	}
+	return Integration{}, false
+}
+
+// postAsIntegration encrypts text with the room key and delivers it like a
+// public message from a connected user.
+func (s *Server) postAsIntegration(from, text, parentID string) (*shared.Message, error) {
+	_, enc, err := shared.EncryptWithRoomKey(text, s.roomKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt message: %v", err)
+	}
+	msg := &shared.Message{
+		ID:            shared.GenerateID(),
+		Type:          shared.TypePublic,
+		From:          from,
+		ParentID:      parentID,
+		EncryptedData: enc,
+		Timestamp:     time.Now(),
+	}
+	if err := s.resolveThreadParent(msg); err != nil {
+		return nil, fmt.Errorf("cannot reply: %v", err)
+	}
+
+	flush, err := s.runPlugins(s.messageEvent(msg))
+	defer flush()
+	if err != nil {
+		return nil, err
+	}
+
+	s.history.Add(msg)
+	s.broadcast(msg)
+	if msg.ParentID != "" {
+		s.broadcastThreadUpdate(msg.ParentID)
+	}
+	s.publishMessageWebhook(msg)
+	return msg, nil
+}
+
+func writeJSONError(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": text})
+}
@@ -0,0 +1,527 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"chatroom/internal/shared"
+	"chatroom/pkg/logger"
+)
+
+var ircLog = logger.New("irc")
+
+// IRC users see the room as this one channel.
+const (
+	ircChannel    = "#room"
+	ircServerName = "chatroom"
+	maxIRCLine    = 8192 // longer than RFC 1459's 512, for long messages
+)
+
+// EnableIRC accepts IRC clients on addr (e.g. ":6667") in addition to the
+// chat protocol once the server starts. Call it before Start.
+//
+// IRC has no end-to-end encryption, so the bridge holds the keys for its
+// users: it decrypts public messages with the room key and private messages
+// to IRC users with a key pair it generates for them, and sends their text
+// to the IRC client in the clear. Only enable it where that is acceptable.
+func (s *Server) EnableIRC(addr string) {
+	s.ircAddr = addr
+}
+
+func (s *Server) serveIRC(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		ircLog.Error("IRC listener disabled", "err", err)
+		return
+	}
+	go func() {
+		<-s.stopping
+		ln.Close()
+	}()
+
+	ircLog.Info("IRC listener started; messages are decrypted server-side for IRC users", "addr", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.stopping:
+			default:
+				ircLog.Error("IRC listener stopped", "err", err)
+			}
+			return
+		}
+
+		s.connections.Add(1)
+		go func(conn net.Conn) {
+			defer s.connections.Done()
+			s.handleConnection(newIRCConn(s, conn))
+		}(conn)
+	}
+}
+
+// ircConn runs an IRC session as a chat client inside the server: IRC
+// commands become shared.Messages for handleConnection, and the messages the
+// server writes to the user become IRC lines. Registration (NICK and USER)
+// maps to the login, PRIVMSG to public and private messages, NAMES to the
+// user list.
+type ircConn struct {
+	*inbox
+	s    *Server
+	conn net.Conn
+
+	writeMu sync.Mutex // one IRC line at a time
+
+	mu          sync.Mutex
+	nick        string // requested nickname, then the username we logged in as
+	gotUser     bool
+	authPending bool // an auth message awaits its response
+	registered  bool
+	priv        *rsa.PrivateKey // made at registration, for private messages to us
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newIRCConn(s *Server, conn net.Conn) *ircConn {
+	c := &ircConn{
+		inbox:  newInbox(),
+		s:      s,
+		conn:   conn,
+		closed: make(chan struct{}),
+	}
+	go c.receive()
+	return c
+}
+
+func (c *ircConn) receive() {
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 512), maxIRCLine)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if err := c.handleLine(line); err != nil {
+			c.stop(nil)
+			return
+		}
+	}
+	c.stop(scanner.Err())
+}
+
+// send hands msg to the connection handler as if a client had sent it.
+func (c *ircConn) send(msg *shared.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if !c.put(data, c.closed) {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// handleLine runs one command from the IRC client. A non-nil error ends the
+// session.
+func (c *ircConn) handleLine(line string) error {
+	cmd, params := parseIRCLine(line)
+
+	switch cmd {
+	case "CAP":
+		if len(params) > 0 && strings.ToUpper(params[0]) == "LS" {
+			c.reply("CAP", "*", "LS", "")
+		}
+		return nil
+	case "PASS", "PONG":
+		return nil
+	case "PING":
+		c.writeLine(fmt.Sprintf(":%s PONG %s :%s", ircServerName, ircServerName, strings.Join(params, " ")))
+		return nil
+	case "QUIT":
+		return errors.New("quit")
+	case "NICK":
+		return c.handleNick(params)
+	case "USER":
+		c.mu.Lock()
+		c.gotUser = true
+		c.mu.Unlock()
+		return c.login()
+	}
+
+	c.mu.Lock()
+	registered, nick := c.registered, c.nick
+	c.mu.Unlock()
+	if !registered {
+		c.reply("451", "*", "You have not registered")
+		return nil
+	}
+
+	switch cmd {
+	case "PRIVMSG":
+		if len(params) < 2 {
+			c.reply("411", nick, "No recipient given (PRIVMSG)")
+			return nil
+		}
+		return c.privmsg(nick, params[0], params[1])
+	case "NOTICE":
+		// Notices must never trigger replies, and there is nothing in the
+		// chat protocol to map them to.
+		return nil
+	case "JOIN":
+		for _, ch := range strings.Split(firstParam(params), ",") {
+			if !strings.EqualFold(ch, ircChannel) {
+				c.reply("403", nick, ch, "No such channel; everyone is in "+ircChannel)
+			}
+		}
+		return nil
+	case "PART":
+		c.notice(nick, "You cannot leave "+ircChannel+"; disconnect to leave the chat")
+		return nil
+	case "NAMES":
+		c.names(nick)
+		return nil
+	case "TOPIC":
+		c.reply("331", nick, ircChannel, "No topic is set")
+		return nil
+	case "MODE":
+		if strings.EqualFold(firstParam(params), ircChannel) {
+			c.reply("324", nick, ircChannel, "+nt")
+		}
+		return nil
+	case "WHO":
+		c.reply("315", nick, firstParam(params), "End of WHO list")
+		return nil
+	case "LIST":
+		c.reply("322", nick, ircChannel, fmt.Sprint(len(c.s.users.GetUsernames())), "The chat room")
+		c.reply("323", nick, "End of LIST")
+		return nil
+	}
+	c.reply("421", nick, cmd, "Unknown command")
+	return nil
+}
+
+func (c *ircConn) handleNick(params []string) error {
+	nick := firstParam(params)
+	if nick == "" {
+		c.reply("431", "*", "No nickname given")
+		return nil
+	}
+
+	c.mu.Lock()
+	registered, pending, current := c.registered, c.authPending, c.nick
+	if !registered && !pending {
+		c.nick = nick
+	}
+	c.mu.Unlock()
+
+	if registered {
+		// The username is the identity other users address; it cannot
+		// change for the length of a session.
+		c.reply("447", current, "Cannot change nickname while connected")
+		return nil
+	}
+	if pending {
+		c.reply("437", "*", nick, "Logging in as "+current+", try again if that fails")
+		return nil
+	}
+	return c.login()
+}
+
+// login sends the auth message once both NICK and USER arrived. Only one
+// is sent until its response arrives: the handler reads no more than that
+// before it answers, so further ones would fill the inbox.
+func (c *ircConn) login() error {
+	c.mu.Lock()
+	nick := c.nick
+	ready := c.gotUser && nick != "" && !c.registered && !c.authPending
+	valid := shared.IsValidUsername(strings.ToLower(nick))
+	if ready && valid {
+		c.authPending = true
+	} else if ready {
+		c.nick = ""
+	}
+	c.mu.Unlock()
+	if !ready {
+		return nil
+	}
+
+	if !valid {
+		c.reply("432", "*", nick, "Erroneous nickname")
+		return nil
+	}
+	return c.send(&shared.Message{Type: shared.TypeAuth, From: nick, Content: "auth"})
+}
+
+func (c *ircConn) privmsg(nick, target, text string) error {
+	if action, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+		text = fmt.Sprintf("* %s %s", nick, strings.TrimSuffix(action, "\x01"))
+	} else if strings.HasPrefix(text, "\x01") {
+		return nil // other CTCP requests have no counterpart
+	}
+
+	if strings.HasPrefix(target, "#") {
+		if !strings.EqualFold(target, ircChannel) {
+			c.reply("403", nick, target, "No such channel")
+			return nil
+		}
+		_, enc, err := shared.EncryptWithRoomKey(text, c.s.roomKey)
+		if err != nil {
+			return err
+		}
+		return c.send(&shared.Message{
+			ID:            shared.GenerateID(),
+			Type:          shared.TypePublic,
+			From:          nick,
+			EncryptedData: enc,
+			Timestamp:     time.Now(),
+		})
+	}
+
+	to, ok := c.s.users.GetByUsername(strings.ToLower(target))
+	if !ok {
+		c.reply("401", nick, target, "No such nick")
+		return nil
+	}
+	if to.PublicKey == nil {
+		c.notice(nick, to.Username+" has not finished logging in, try again in a moment")
+		return nil
+	}
+	encKey, encData, err := shared.Encrypt(text, to.PublicKey)
+	if err != nil {
+		return err
+	}
+	return c.send(&shared.Message{
+		ID:           shared.GenerateID(),
+		Type:         shared.TypePrivate,
+		From:         nick,
+		To:           to.Username,
+		EncryptedKey: encKey,
+		Content:      encData,
+		Timestamp:    time.Now(),
+	})
+}
+
+// names lists the online users, admins marked as channel operators.
+func (c *ircConn) names(nick string) {
+	var names []string
+	for _, name := range c.s.users.GetUsernames() {
+		if c.s.IsAdmin(name) {
+			name = "@" + name
+		}
+		names = append(names, name)
+	}
+	c.reply("353", nick, "=", ircChannel, strings.Join(names, " "))
+	c.reply("366", nick, ircChannel, "End of NAMES list")
+}
+
+// Write translates the messages the server sends to this user into IRC.
+func (c *ircConn) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		var msg shared.Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			return 0, err
+		}
+		if err := c.deliver(&msg); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *ircConn) deliver(msg *shared.Message) error {
+	c.mu.Lock()
+	nick, priv := c.nick, c.priv
+	c.mu.Unlock()
+
+	switch msg.Type {
+	case shared.TypeAuthResponse:
+		c.mu.Lock()
+		c.authPending = false
+		if !msg.Success {
+			c.nick = ""
+		}
+		c.mu.Unlock()
+		if !msg.Success {
+			c.reply("433", "*", nick, msg.Error)
+			return nil
+		}
+		return c.welcome(nick, msg.To)
+
+	case shared.TypePublic:
+		if msg.From == nick {
+			return nil // IRC clients show their own messages already
+		}
+		plain, err := shared.DecryptWithRoomKey(msg.EncryptedData, c.s.roomKey)
+		if err != nil {
+			ircLog.Warn("Cannot decrypt message", "id", msg.ID, "nick", nick, "err", err)
+			return nil
+		}
+		c.privmsgFrom(msg.From, ircChannel, string(plain))
+
+	case shared.TypePrivate:
+		if msg.From == nick || priv == nil {
+			return nil
+		}
+		plain, err := shared.Decrypt(msg.EncryptedKey, msg.Content, priv)
+		if err != nil {
+			ircLog.Warn("Cannot decrypt private message", "nick", nick, "err", err)
+			return nil
+		}
+		c.privmsgFrom(msg.From, nick, string(plain))
+
+	case shared.TypeJoin:
+		if msg.From != nick {
+			c.writeLine(fmt.Sprintf(":%s JOIN %s", ircPrefix(msg.From), ircChannel))
+		}
+	case shared.TypeLeave:
+		c.writeLine(fmt.Sprintf(":%s PART %s :%s", ircPrefix(msg.From), ircChannel, msg.Content))
+	case shared.TypeKick:
+		c.writeLine(fmt.Sprintf(":%s KICK %s %s :%s", ircPrefix(msg.From), ircChannel, nick, msg.Content))
+	case shared.TypeInfo:
+		c.notice(ircChannel, msg.Content)
+	case shared.TypeShutdown:
+		c.notice(ircChannel, fmt.Sprintf("%s; reconnect in %d seconds", msg.Content, msg.RetryAfter))
+	case shared.TypeError:
+		c.notice(nick, msg.Content)
+	case shared.TypeFileAvailable:
+		c.notice(ircChannel, fmt.Sprintf("%s shared %s (download it with a chat client)", msg.From, msg.Filename))
+	case shared.TypePrivateFileTransferAvailable:
+		c.notice(nick, fmt.Sprintf("%s sent you %s (download it with a chat client)", msg.From, msg.Filename))
+	}
+	// Everything else (user lists, presence, typing, receipts, reactions,
+	// the room key) has no IRC counterpart.
+	return nil
+}
+
+// welcome completes registration and joins the user to the channel. The
+// server may have normalized the nickname to username. The key pair for
+// private messages is only made now, so that connecting costs the server
+// nothing until the login succeeded.
+func (c *ircConn) welcome(nick, username string) error {
+	priv, pub, err := shared.GenerateRSAKeyPair(2048)
+	if err != nil {
+		return fmt.Errorf("failed to generate key pair: %v", err)
+	}
+	pemPub, err := shared.PublicKeyToPEM(pub)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.priv = priv
+	c.nick = username
+	c.registered = true
+	c.mu.Unlock()
+
+	if nick != username {
+		c.writeLine(fmt.Sprintf(":%s NICK %s", ircPrefix(nick), username))
+	}
+	c.reply("001", username, "Welcome to the chat room, "+username)
+	c.reply("002", username, "Your host is "+ircServerName)
+	c.reply("003", username, "This bridge decrypts messages on the server for IRC clients")
+	c.reply("004", username, ircServerName, "chatroom", "o", "nt")
+	c.reply("375", username, "- "+ircServerName+" Message of the day -")
+	c.reply("372", username, "- Everyone is in "+ircChannel+". Messages to and from IRC are")
+	c.reply("372", username, "- not end-to-end encrypted: the server sees their text.")
+	c.reply("376", username, "End of MOTD")
+
+	c.writeLine(fmt.Sprintf(":%s JOIN %s", ircPrefix(username), ircChannel))
+	c.reply("331", username, ircChannel, "No topic is set")
+	c.names(username)
+
+	// Other clients encrypt private messages to us with this key.
+	return c.send(&shared.Message{Type: shared.TypePublicKey, From: username, Content: string(pemPub)})
+}
+
+func (c *ircConn) privmsgFrom(from, target, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			c.writeLine(fmt.Sprintf(":%s PRIVMSG %s :%s", ircPrefix(from), target, line))
+		}
+	}
+}
+
+func (c *ircConn) notice(target, text string) {
+	if target == "" {
+		target = "*" // not registered yet
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			c.writeLine(fmt.Sprintf(":%s NOTICE %s :%s", ircServerName, target, line))
+		}
+	}
+}
+
+// reply sends a numeric or command from the server. The last parameter is
+// sent as the trailing one and may contain spaces.
+func (c *ircConn) reply(cmd string, params ...string) {
+	line := ":" + ircServerName + " " + cmd
+	for i, p := range params {
+		if i == len(params)-1 {
+			line += " :" + p
+		} else {
+			line += " " + p
+		}
+	}
+	c.writeLine(line)
+}
+
+// ircUnsafe replaces the characters that would end or corrupt an IRC line,
+// so chat text can never inject lines of its own.
+var ircUnsafe = strings.NewReplacer("\r", " ", "\n", " ", "\x00", " ")
+
+func (c *ircConn) writeLine(line string) {
+	line = ircUnsafe.Replace(line)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	c.conn.Write([]byte(line + "\r\n"))
+}
+
+func (c *ircConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *ircConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// parseIRCLine splits "[:prefix] COMMAND params... [:trailing]" into the
+// upper-cased command and its parameters, trailing one included.
+func parseIRCLine(line string) (string, []string) {
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	line, trailing, hasTrailing := strings.Cut(line, " :")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	params := fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return strings.ToUpper(fields[0]), params
+}
+
+func firstParam(params []string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	return params[0]
+}
+
+// ircPrefix is the nick!user@host source of messages from a chat user.
+func ircPrefix(username string) string {
+	return username + "!" + username + "@" + ircServerName
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"chatroom/internal/server/metrics"
+	"chatroom/internal/shared"
+	"chatroom/internal/transport"
+)
+
+// serverMetrics are the figures exported on /metrics. They are collected
+// whether or not the endpoint is enabled.
+type serverMetrics struct {
+	registry          *metrics.Registry
+	connections       *metrics.Counter
+	authFailures      *metrics.Counter // by reason
+	messages          *metrics.Counter // by type
+	bytesReceived     *metrics.Counter
+	bytesSent         *metrics.Counter
+	broadcastsDropped *metrics.Counter
+	deliveryErrors    *metrics.Counter
+	uploadSize        *metrics.Histogram // by visibility
+	handlingTime      *metrics.Histogram // by type
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry:          r,
+		connections:       r.Counter("chatroom_connections_total", "Connections accepted, over any transport."),
+		authFailures:      r.Counter("chatroom_auth_failures_total", "Rejected logins.", "reason"),
+		messages:          r.Counter("chatroom_messages_total", "Messages received from clients.", "type"),
+		bytesReceived:     r.Counter("chatroom_received_bytes_total", "Protocol bytes read from clients."),
+		bytesSent:         r.Counter("chatroom_sent_bytes_total", "Protocol bytes written to clients."),
+		broadcastsDropped: r.Counter("chatroom_broadcasts_dropped_total", "Broadcasts dropped because the queue was full."),
+		deliveryErrors:    r.Counter("chatroom_delivery_errors_total", "Messages that could not be written to a recipient."),
+		uploadSize: r.Histogram("chatroom_upload_size_bytes", "Size of uploaded files.",
+			metrics.ExponentialBuckets(1024, 4, 11), "visibility"),
+		handlingTime: r.Histogram("chatroom_message_handling_seconds", "Time spent handling one client message.",
+			metrics.DefaultBuckets, "type"),
+	}
+	r.Gauge("chatroom_users_online", "Users logged in right now.", func() float64 {
+		return float64(len(s.users.GetUsernames()))
+	})
+	r.Gauge("chatroom_broadcast_queue_length", "Broadcasts waiting to be sent.", func() float64 {
+		return float64(len(s.broadcastCh))
+	})
+	return m
+}
+
+// messageTypes are the types counted by name. Anything else a client sends
+// is counted as "unknown", so clients cannot create new series at will.
+var messageTypes = map[shared.MessageType]bool{
+	shared.TypeAuth: true, shared.TypePublic: true, shared.TypePrivate: true,
+	shared.TypeLeave: true, shared.TypePublicKey: true, shared.TypePublicKeyRequest: true,
+	shared.TypeReconnect: true, shared.TypeFileTransfer: true, shared.TypeFileDownload: true,
+	shared.TypePrivateFileTransfer: true, shared.TypePrivateFileDownload: true,
+	shared.TypeTyping: true, shared.TypeStatus: true, shared.TypeDeliveredReceipt: true,
+	shared.TypeReadReceipt: true, shared.TypeEdit: true, shared.TypeDelete: true,
+	shared.TypeReactionAdd: true, shared.TypeReactionRemove: true, shared.TypeThreadRequest: true,
+	shared.TypeNick: true, shared.TypeKick: true, shared.TypeAnnounce: true,
+}
+
+func typeLabel(t shared.MessageType) string {
+	if messageTypes[t] {
+		return string(t)
+	}
+	return "unknown"
+}
+
+// observeMessage records one handled message.
+func (m *serverMetrics) observeMessage(t shared.MessageType, started time.Time) {
+	label := typeLabel(t)
+	m.messages.Inc(label)
+	m.handlingTime.Observe(time.Since(started).Seconds(), label)
+}
+
+// countingConn counts the bytes going through a client connection.
+type countingConn struct {
+	transport.Conn
+	m *serverMetrics
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.m.bytesReceived.Add(float64(n))
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.m.bytesSent.Add(float64(n))
+	return n, err
+}
+
+// EnableMetrics serves the metrics on http://addr/metrics once the server
+// starts. Call it before Start. The endpoint has no authentication; bind it
+// to an address only the scraper can reach.
+func (s *Server) EnableMetrics(addr string) {
+	s.metricsAddr = addr
+}
+
+func (s *Server) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.registry.Handler())
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Error("Metrics endpoint disabled", "err", err)
+		return
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-s.done
+		srv.Close()
+	}()
+
+	log.Info("Metrics endpoint listening", "url", "http://"+ln.Addr().String()+"/metrics")
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("Metrics endpoint stopped", "err", err)
+	}
+}
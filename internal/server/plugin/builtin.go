@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Builtins maps the names of the built-in plugins to their constructors.
+var Builtins = map[string]func() Plugin{
+	"greeter": func() Plugin { return NewGreeter("") },
+	"uptime":  func() Plugin { return NewUptime() },
+}
+
+// BuiltinNames returns the names in Builtins, sorted.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(Builtins))
+	for name := range Builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Greeter welcomes users when they join.
+type Greeter struct {
+	message string
+}
+
+// NewGreeter returns a greeter that sends message to new users. "{user}" is
+// replaced by their name and "{online}" by the number of users online. An
+// empty message selects a default greeting.
+func NewGreeter(message string) *Greeter {
+	if message == "" {
+		message = "Welcome, {user}! {online} online. Type /help to see what you can do."
+	}
+	return &Greeter{message: message}
+}
+
+func (g *Greeter) Name() string { return "greeter" }
+
+func (g *Greeter) OnEvent(room Room, ev Event) error {
+	if ev.Kind != UserJoined {
+		return nil
+	}
+	online := len(room.Users())
+	count := fmt.Sprintf("%d users", online)
+	if online == 1 {
+		count = "1 user"
+	}
+	text := strings.NewReplacer("{user}", ev.User, "{online}", count).Replace(g.message)
+	return room.Notify(ev.User, text)
+}
+
+// Uptime answers "!uptime" in the room with how long the server has run.
+type Uptime struct {
+	started time.Time
+}
+
+func NewUptime() *Uptime {
+	return &Uptime{started: time.Now()}
+}
+
+func (u *Uptime) Name() string { return "uptime" }
+
+func (u *Uptime) OnEvent(room Room, ev Event) error {
+	if ev.Kind != MessageReceived || ev.Private || strings.TrimSpace(ev.Text) != "!uptime" {
+		return nil
+	}
+	up := time.Since(u.started).Round(time.Second)
+	return room.Say(u.Name(), fmt.Sprintf("Server up for %s (since %s)", up, u.started.Format("2006-01-02 15:04")))
+}
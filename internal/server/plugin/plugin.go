@@ -0,0 +1,158 @@
+// Package plugin lets in-process extensions react to what happens in the
+// chat room: auto-responders, welcome messages, keyword alerts and the like.
+//
+// Plugins run on the server, which holds the room key, so they see the
+// plaintext of public messages. Private messages are encrypted for their
+// recipient and reach plugins without their text.
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"chatroom/pkg/logger"
+)
+
+var log = logger.New("plugin")
+
+type Kind string
+
+const (
+	MessageReceived Kind = "message_received"
+	UserJoined      Kind = "user_joined"
+	UserLeft        Kind = "user_left"
+	FileUploaded    Kind = "file_uploaded"
+)
+
+// Event is passed to every plugin. Fields that do not apply to Kind are
+// left empty.
+type Event struct {
+	Kind     Kind
+	User     string // who sent, joined, left or uploaded
+	To       string // recipient of a private message or file
+	Private  bool
+	Text     string // plaintext of a public message
+	ParentID string // thread a public message replies to
+	Filename string
+	Time     time.Time
+}
+
+// Room is what plugins can do in response to an event. Messages sent from
+// OnEvent are delivered after the event itself, e.g. a reply shows up below
+// the message it answers.
+type Room interface {
+	// Say posts a public message to the room as from, encrypted with the
+	// room key like any other message. from must be the name of a
+	// registered plugin; no user can log in under one, so nobody else can
+	// edit or delete what a plugin said.
+	Say(from, text string) error
+	// Notify sends a system message to one user, or to everyone if to is
+	// empty.
+	Notify(to, text string) error
+	// Users returns the usernames currently online.
+	Users() []string
+}
+
+// Plugin is an in-process extension. OnEvent is called from the goroutine
+// handling the event, so it may be called concurrently and should return
+// quickly.
+type Plugin interface {
+	Name() string
+	// OnEvent handles one event. Returning an error made with Veto for a
+	// MessageReceived event drops the message; other errors are logged.
+	OnEvent(room Room, ev Event) error
+}
+
+// VetoError is returned by a plugin that blocks a message.
+type VetoError struct {
+	Plugin string
+	Reason string
+}
+
+func (e *VetoError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("message blocked by %s", e.Plugin)
+	}
+	return fmt.Sprintf("message blocked by %s: %s", e.Plugin, e.Reason)
+}
+
+// Veto returns an error that tells the server to drop the message. The
+// reason is shown to the sender.
+func Veto(reason string) error {
+	return &VetoError{Reason: reason}
+}
+
+// Manager holds the registered plugins and calls them in registration
+// order. It is safe for concurrent use.
+type Manager struct {
+	mu      sync.RWMutex
+	plugins []Plugin
+}
+
+func New() *Manager {
+	return &Manager{}
+}
+
+func (m *Manager) Register(p Plugin) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.plugins = append(m.plugins, p)
+	log.Info("Registered plugin", "plugin", p.Name())
+}
+
+// Len returns the number of registered plugins.
+func (m *Manager) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.plugins)
+}
+
+// Has reports whether a plugin named name is registered. Names are compared
+// without regard to case.
+func (m *Manager) Has(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, p := range m.plugins {
+		if strings.EqualFold(p.Name(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatch passes ev to every plugin. It stops at the first veto of a
+// MessageReceived event and returns it as a *VetoError.
+func (m *Manager) Dispatch(room Room, ev Event) error {
+	m.mu.RLock()
+	plugins := append([]Plugin(nil), m.plugins...)
+	m.mu.RUnlock()
+
+	for _, p := range plugins {
+		err := m.call(p, room, ev)
+		if err == nil {
+			continue
+		}
+
+		var veto *VetoError
+		if errors.As(err, &veto) && ev.Kind == MessageReceived {
+			veto.Plugin = p.Name()
+			return veto
+		}
+		log.Error("Plugin failed", "plugin", p.Name(), "event", ev.Kind, "err", err)
+	}
+	return nil
+}
+
+// call runs one plugin, turning a panic into an error so a broken plugin
+// cannot take the connection down with it.
+func (m *Manager) call(p Plugin, room Room, ev Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p.OnEvent(room, ev)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"chatroom/internal/server/plugin"
+	"chatroom/internal/server/users"
+	"chatroom/internal/shared"
+)
+
+// RegisterPlugin adds an in-process extension. Plugins are called in the
+// order they were registered.
+func (s *Server) RegisterPlugin(p plugin.Plugin) {
+	s.plugins.Register(p)
+}
+
+// pluginRoom implements plugin.Room for one event. Messages the plugins send
+// are held back until flush, so they follow the event that caused them.
+type pluginRoom struct {
+	s       *Server
+	mu      sync.Mutex
+	pending []func()
+}
+
+func (r *pluginRoom) Say(from, text string) error {
+	from = users.Normalize(from)
+	if !r.s.plugins.Has(from) {
+		return fmt.Errorf("%s is not a registered plugin", from)
+	}
+	_, enc, err := shared.EncryptWithRoomKey(text, r.s.roomKey)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message: %v", err)
+	}
+	msg := &shared.Message{
+		ID:            shared.GenerateID(),
+		Type:          shared.TypePublic,
+		From:          from,
+		EncryptedData: enc,
+		Timestamp:     time.Now(),
+	}
+	r.queue(func() {
+		r.s.history.Add(msg)
+		r.s.broadcast(msg)
+	})
+	return nil
+}
+
+func (r *pluginRoom) Notify(to, text string) error {
+	msg := &shared.Message{
+		Type:      shared.TypeInfo,
+		Content:   text,
+		Timestamp: time.Now(),
+	}
+	if to == "" {
+		r.queue(func() { r.s.broadcast(msg) })
+		return nil
+	}
+
+	u, ok := r.s.users.GetByUsername(to)
+	if !ok {
+		return fmt.Errorf("user %s not found", to)
+	}
+	r.queue(func() {
+		if err := u.WriteMessage(msg); err != nil {
+			log.Error("Failed to send plugin notice", "user", to, "err", err)
+		}
+	})
+	return nil
+}
+
+func (r *pluginRoom) Users() []string {
+	return r.s.users.GetUsernames()
+}
+
+func (r *pluginRoom) queue(fn func()) {
+	r.mu.Lock()
+	r.pending = append(r.pending, fn)
+	r.mu.Unlock()
+}
+
+func (r *pluginRoom) flush() {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+// runPlugins passes ev to the plugins and returns a veto, if any, together
+// with a flush function that delivers what the plugins sent. Callers flush
+// after handling the event themselves.
+func (s *Server) runPlugins(ev plugin.Event) (func(), error) {
+	if s.plugins.Len() == 0 {
+		return func() {}, nil
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	room := &pluginRoom{s: s}
+	err := s.plugins.Dispatch(room, ev)
+	return room.flush, err
+}
+
+// notifyPlugins is runPlugins for events that cannot be vetoed.
+func (s *Server) notifyPlugins(ev plugin.Event) {
+	flush, _ := s.runPlugins(ev)
+	flush()
+}
+
+// messageEvent describes a chat message for the plugins, decrypting public
+// messages with the room key.
+func (s *Server) messageEvent(msg *shared.Message) plugin.Event {
+	ev := plugin.Event{
+		Kind:     plugin.MessageReceived,
+		User:     msg.From,
+		To:       msg.To,
+		Private:  msg.Type == shared.TypePrivate,
+		ParentID: msg.ParentID,
+		Time:     msg.Timestamp,
+	}
+	if !ev.Private && s.plugins.Len() > 0 {
+		plain, err := shared.DecryptWithRoomKey(msg.EncryptedData, s.roomKey)
+		if err != nil {
+			log.Warn("Plugins get message without text", "id", msg.ID, "err", err)
+		} else {
+			ev.Text = string(plain)
+		}
+	}
+	return ev
+}
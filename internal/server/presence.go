@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+const presenceCheckInterval = 30 * time.Second
+
+// watchPresence periodically moves idle users to away.
+func (s *Server) watchPresence() {
+	ticker := time.NewTicker(presenceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if idle := s.presence.CheckIdle(now); len(idle) > 0 {
+				log.Info("Users now away", "users", idle)
+				s.broadcastPresence()
+			}
+		}
+	}
+}
+
+func (s *Server) broadcastPresence() {
+	msg := &shared.Message{
+		Type:      shared.TypePresence,
+		Presence:  s.presence.Snapshot(),
+		Timestamp: time.Now(),
+	}
+	s.broadcast(msg)
+}
+
+func (s *Server) handleStatus(user *shared.User, msg *shared.Message) error {
+	if err := s.presence.SetStatus(user.Username, msg.Status, msg.Content); err != nil {
+		s.sendErrorToConn(user.Conn, err.Error())
+		return fmt.Errorf("invalid status from %s: %v", user.Username, err)
+	}
+	s.broadcastPresence()
+	return nil
+}
+
+func (s *Server) handleNick(user *shared.User, msg *shared.Message) error {
+	nick := strings.TrimSpace(msg.Content)
+	if err := s.presence.SetNick(user.Username, nick); err != nil {
+		s.sendErrorToConn(user.Conn, err.Error())
+		return fmt.Errorf("invalid nickname from %s: %v", user.Username, err)
+	}
+
+	text := fmt.Sprintf("%s is now known as %s", user.Username, nick)
+	if nick == "" {
+		text = fmt.Sprintf("%s cleared their nickname", user.Username)
+	}
+	s.broadcast(&shared.Message{
+		Type:      shared.TypeInfo,
+		Content:   text,
+		Timestamp: time.Now(),
+	})
+	s.broadcastPresence()
+	return nil
+}
+
+// handleTyping forwards a typing notice to the room (empty To) or to a single
+// recipient, dropping notices that arrive faster than the rate limit allows.
+func (s *Server) handleTyping(user *shared.User, msg *shared.Message) error {
+	target := msg.To
+	if !s.presence.AllowTyping(user.Username, target, time.Now()) {
+		return nil
+	}
+
+	notice := &shared.Message{
+		Type:      shared.TypeTyping,
+		From:      user.Username,
+		To:        target,
+		Timestamp: time.Now(),
+	}
+
+	if target == "" {
+		return s.broadcastPublicMessage(notice)
+	}
+
+	targetUser, exists := s.users.GetByUsername(target)
+	if !exists {
+		return fmt.Errorf("typing target not found: %s", target)
+	}
+	return targetUser.WriteMessage(notice)
+}
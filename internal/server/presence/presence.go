@@ -0,0 +1,197 @@
+package presence
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+const (
+	maxStatusText = 64
+	maxNick       = 32
+)
+
+type entry struct {
+	status     shared.PresenceStatus // status chosen by the user
+	text       string
+	nick       string
+	lastActive time.Time
+	idle       bool                 // set when auto-away kicked in
+	lastTyping map[string]time.Time // target ("" = room) -> last forwarded typing notice
+}
+
+type Manager struct {
+	entries        map[string]*entry // username -> presence
+	idleTimeout    time.Duration
+	typingInterval time.Duration
+	mu             sync.Mutex
+}
+
+// New creates a presence manager. Users idle for longer than idleTimeout are
+// reported as away, and typing notices are forwarded at most once per
+// typingInterval for each user and target.
+func New(idleTimeout, typingInterval time.Duration) *Manager {
+	return &Manager{
+		entries:        make(map[string]*entry),
+		idleTimeout:    idleTimeout,
+		typingInterval: typingInterval,
+	}
+}
+
+func (m *Manager) Join(username string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[username] = &entry{
+		status:     shared.StatusOnline,
+		lastActive: time.Now(),
+		lastTyping: make(map[string]time.Time),
+	}
+}
+
+func (m *Manager) Leave(username string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, username)
+}
+
+// SetStatus stores the status chosen by the user along with an optional text.
+func (m *Manager) SetStatus(username string, status shared.PresenceStatus, text string) error {
+	if !status.IsValid() {
+		return fmt.Errorf("invalid status %q", status)
+	}
+	if len(text) > maxStatusText {
+		return fmt.Errorf("status text too long (max %d characters)", maxStatusText)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[username]
+	if !ok {
+		return fmt.Errorf("user %s not found", username)
+	}
+	e.status = status
+	e.text = text
+	e.lastActive = time.Now()
+	e.idle = false
+	return nil
+}
+
+// SetNick sets the display nickname of username. An empty nick clears it. A
+// nick may not match, ignoring case, the username or nick of another online
+// user.
+func (m *Manager) SetNick(username, nick string) error {
+	if len(nick) > maxNick {
+		return fmt.Errorf("nickname too long (max %d characters)", maxNick)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[username]
+	if !ok {
+		return fmt.Errorf("user %s not found", username)
+	}
+	if nick != "" {
+		for other, oe := range m.entries {
+			if other == username {
+				continue
+			}
+			if strings.EqualFold(nick, other) || strings.EqualFold(nick, oe.nick) {
+				return fmt.Errorf("nickname %s is already taken", nick)
+			}
+		}
+	}
+	e.nick = nick
+	return nil
+}
+
+// Touch records activity for username. It returns true when the user was
+// auto-away and is now back online.
+func (m *Manager) Touch(username string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[username]
+	if !ok {
+		return false
+	}
+	e.lastActive = time.Now()
+	if e.idle {
+		e.idle = false
+		return true
+	}
+	return false
+}
+
+// CheckIdle marks users idle since before now-idleTimeout as away and
+// returns their names.
+func (m *Manager) CheckIdle(now time.Time) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changed []string
+	for username, e := range m.entries {
+		if e.idle || e.status != shared.StatusOnline {
+			continue
+		}
+		if now.Sub(e.lastActive) >= m.idleTimeout {
+			e.idle = true
+			changed = append(changed, username)
+		}
+	}
+	return changed
+}
+
+// AllowTyping reports whether a typing notice from username to target should
+// be forwarded, and records it if so.
+func (m *Manager) AllowTyping(username, target string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[username]
+	if !ok {
+		return false
+	}
+	if last, ok := e.lastTyping[target]; ok && now.Sub(last) < m.typingInterval {
+		return false
+	}
+	e.lastTyping[target] = now
+	return true
+}
+
+func (m *Manager) Get(username string) (shared.Presence, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[username]
+	if !ok {
+		return shared.Presence{}, false
+	}
+	return e.presence(username), true
+}
+
+// Snapshot returns the presence of every tracked user, sorted by name.
+func (m *Manager) Snapshot() []shared.Presence {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]shared.Presence, 0, len(m.entries))
+	for username, e := range m.entries {
+		list = append(list, e.presence(username))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+func (e *entry) presence(username string) shared.Presence {
+	status := e.status
+	if e.idle {
+		status = shared.StatusAway
+	}
+	return shared.Presence{
+		Username:   username,
+		Status:     status,
+		StatusText: e.text,
+		Nick:       e.nick,
+	}
+}
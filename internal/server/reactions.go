@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"chatroom/internal/shared"
+)
+
+const (
+	maxReactionLen         = 32
+	maxReactionsPerMessage = 20 // distinct emoji on one message
+	maxReactionsPerUser    = 10 // emoji one user put on one message
+)
+
+// handleReaction adds or removes the user's reaction on a stored message and
+// sends the new totals to everyone who can see the message.
+func (s *Server) handleReaction(user *shared.User, msg *shared.Message) error {
+	emoji := strings.TrimSpace(msg.Content)
+	if emoji == "" || len(emoji) > maxReactionLen ||
+		msg.Type == shared.TypeReactionAdd && !isEmoji(emoji) {
+		s.sendErrorToConn(user.Conn, "Invalid reaction")
+		return fmt.Errorf("invalid reaction %q from %s", emoji, user.Username)
+	}
+
+	updated, err := s.history.Update(msg.RefID, func(original *shared.Message) error {
+		if original.Deleted {
+			return fmt.Errorf("message %s was deleted", original.ID)
+		}
+		if original.Type == shared.TypePrivate &&
+			original.From != user.Username && original.To != user.Username {
+			return fmt.Errorf("not allowed to react to message %s", original.ID)
+		}
+
+		if msg.Type == shared.TypeReactionAdd {
+			return addReaction(original, emoji, user.Username)
+		}
+		removeReaction(original, emoji, user.Username)
+		return nil
+	})
+	if err != nil {
+		s.sendErrorToConn(user.Conn, "Cannot react to message: "+err.Error())
+		return err
+	}
+
+	notice := &shared.Message{
+		Type:      shared.TypeReactions,
+		To:        updated.To,
+		RefID:     updated.ID,
+		Reactions: updated.Reactions,
+		Timestamp: time.Now(),
+	}
+	s.propagateUpdate(updated, notice)
+	return nil
+}
+
+func addReaction(msg *shared.Message, emoji, username string) error {
+	for _, name := range msg.Reactions[emoji] {
+		if name == username {
+			return nil
+		}
+	}
+	if _, ok := msg.Reactions[emoji]; !ok && len(msg.Reactions) >= maxReactionsPerMessage {
+		return fmt.Errorf("message %s already has %d different reactions", msg.ID, maxReactionsPerMessage)
+	}
+	mine := 0
+	for _, names := range msg.Reactions {
+		for _, name := range names {
+			if name == username {
+				mine++
+			}
+		}
+	}
+	if mine >= maxReactionsPerUser {
+		return fmt.Errorf("you already reacted %d times to message %s", maxReactionsPerUser, msg.ID)
+	}
+	if msg.Reactions == nil {
+		msg.Reactions = make(map[string][]string)
+	}
+	msg.Reactions[emoji] = append(msg.Reactions[emoji], username)
+	return nil
+}
+
+func removeReaction(msg *shared.Message, emoji, username string) {
+	users := msg.Reactions[emoji]
+	kept := users[:0]
+	for _, name := range users {
+		if name != username {
+			kept = append(kept, name)
+		}
+	}
+	if len(kept) == 0 {
+		delete(msg.Reactions, emoji)
+	} else {
+		msg.Reactions[emoji] = kept
+	}
+}
+
+// isEmoji reports whether s is a single emoji: one pictograph, flag or keycap,
+// optionally with variation selectors, skin tones and tags, or several
+// pictographs joined with zero width joiners into one.
+func isEmoji(s string) bool {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return false
+	}
+
+	switch r := runes[0]; {
+	case isRegionalIndicator(r):
+		// A flag is exactly two regional indicators.
+		return len(runes) == 2 && isRegionalIndicator(runes[1])
+	case r == '#' || r == '*' || r >= '0' && r <= '9':
+		// Keycaps: "1", an optional variation selector, then U+20E3.
+		rest := runes[1:]
+		if len(rest) > 0 && rest[0] == 0xFE0F {
+			rest = rest[1:]
+		}
+		return len(rest) == 1 && rest[0] == 0x20E3
+	case !unicode.Is(unicode.So, r):
+		return false
+	}
+
+	for i := 1; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == 0xFE0E || r == 0xFE0F: // variation selectors
+		case r >= 0x1F3FB && r <= 0x1F3FF: // skin tones
+		case r >= 0xE0020 && r <= 0xE007F: // tags, as in subdivision flags
+		case r == 0x200D: // zero width joiner, which must join two pictographs
+			if i+1 == len(runes) || !unicode.Is(unicode.So, runes[i+1]) {
+				return false
+			}
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
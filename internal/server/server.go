@@ -1,31 +1,63 @@
 package server
 
 import (
-	"context"
 	"encoding/base64"
 	"encoding/json"
-	"log"
-	"net"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
+	"chatroom/internal/server/audit"
 	"chatroom/internal/server/filetransfer"
+	"chatroom/internal/server/history"
+	"chatroom/internal/server/plugin"
+	"chatroom/internal/server/presence"
+	"chatroom/internal/server/session"
 	"chatroom/internal/server/users"
+	"chatroom/internal/server/webhook"
 	"chatroom/internal/shared"
+	"chatroom/internal/transport"
+	"chatroom/pkg/logger"
 )
 
+var log = logger.New("server")
+
 type Server struct {
-	listener     net.Listener
+	listener     transport.Listener
+	transport    transport.Transport
 	addr         string
 	users        *users.Manager
 	mu           sync.RWMutex
 	broadcastCh  chan *shared.Message
-	done         chan struct{}
+	done         chan struct{} // closed once handlers finished during shutdown
+	stopping     chan struct{} // closed when Shutdown starts
+	drained      chan struct{} // closed once the broadcast queue is empty after done
+	shutdownOnce sync.Once
 	connections  sync.WaitGroup
+	conns        map[transport.Conn]struct{} // every open client connection
+	connsMu      sync.Mutex
+	inflight     sync.WaitGroup // messages being handled
+	inflightMu   sync.Mutex     // orders inflight.Add against Shutdown
+	closing      bool           // guarded by inflightMu
 	roomKey      []byte
 	stateFile    string
 	fileTransfer *filetransfer.FileTransfer
+	presence     *presence.Manager
+	history      *history.Store
+	sessions     *session.Store
+	admins       map[string]bool
+	bans         map[string]string // username -> reason, saved with the state
+	plugins      *plugin.Manager
+	webhooks     *webhook.Dispatcher // nil unless EnableWebhooks was called
+	incoming     *IncomingConfig     // nil unless EnableIncomingWebhooks was called
+	wsAddr       string              // WebSocket listen address, empty if disabled
+	ircAddr      string              // IRC listen address, empty if disabled
+	adminAPI     *adminAPI           // nil unless EnableAdminAPI was called
+	metrics      *serverMetrics
+	metricsAddr  string // metrics listen address, empty if disabled
+	startedAt    time.Time
+	audit        *audit.Log
 }
 
 func New(addr string) *Server {
@@ -34,65 +66,81 @@ func New(addr string) *Server {
 
 	s := &Server{
 		addr:         addr,
+		transport:    transport.TCP,
 		users:        users.New(),
 		broadcastCh:  make(chan *shared.Message, 100),
 		done:         make(chan struct{}),
+		stopping:     make(chan struct{}),
+		drained:      make(chan struct{}),
+		conns:        make(map[transport.Conn]struct{}),
 		stateFile:    "server_state.json",
 		fileTransfer: filetransfer.New(uploadDir),
+		presence:     presence.New(5*time.Minute, 3*time.Second),
+		history:      history.New("history.json", 1000),
+		sessions:     session.New(sessionTTL),
+		admins:       make(map[string]bool),
+		bans:         make(map[string]string),
+		plugins:      plugin.New(),
+	}
+
+	s.metrics = newServerMetrics(s)
+
+	// A log that fails its check is evidence; leave it alone and run
+	// without one rather than refuse to start.
+	auditLog, err := audit.Open(auditFile)
+	if err != nil {
+		log.Error("Cannot open audit log, audit logging is off; check it with -verify-audit", "err", err)
+	} else {
+		s.audit = auditLog
 	}
 
 	s.loadOrGenerateRoomKey()
 
 	// Try loading saved state
 	if err := s.LoadState(); err == nil {
-		log.Println("[INFO] Server state loaded from file.")
+		log.Info("Server state loaded from file")
 	} else {
-		log.Println("[WARN] No previous state found, generating new room key.")
+		log.Warn("No previous state found, generating new room key")
+	}
+
+	if err := s.history.Load(); err == nil {
+		log.Info("Message history loaded from file")
 	}
 
 	return s
 }
 
 func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", s.addr)
+	listener, err := s.transport.Listen(s.addr)
 	if err != nil {
 		return err
 	}
 	s.listener = listener
+	s.startedAt = time.Now()
 
 	// Start broadcast handler
 	go s.handleBroadcasts()
-
-	return s.serve()
-}
-
-func (s *Server) Shutdown(ctx context.Context) error {
-
-	log.Println("[INFO] Saving server state before shutdown...")
-
-	if err := s.SaveState(); err != nil {
-		log.Printf("[ERROR] Failed to save server state: %v", err)
+	go s.watchPresence()
+	if s.webhooks != nil {
+		go s.webhooks.Run(s.done)
 	}
-
-	close(s.done)
-	s.users.Clear()
-
-	if err := s.listener.Close(); err != nil {
-		return err
+	if s.incoming != nil {
+		go s.serveIncoming(s.incoming)
 	}
-
-	done := make(chan struct{})
-	go func() {
-		s.connections.Wait()
-		close(done)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-done:
-		return nil
+	if s.wsAddr != "" {
+		go s.serveWebSocket(s.wsAddr)
+	}
+	if s.ircAddr != "" {
+		go s.serveIRC(s.ircAddr)
 	}
+	if s.adminAPI != nil {
+		go s.serveAdminAPI(s.adminAPI)
+	}
+	if s.metricsAddr != "" {
+		go s.serveMetrics(s.metricsAddr)
+	}
+
+	return s.serve()
 }
 
 func (s *Server) serve() error {
@@ -100,7 +148,7 @@ func (s *Server) serve() error {
 		conn, err := s.listener.Accept()
 		if err != nil {
 			select {
-			case <-s.done:
+			case <-s.stopping:
 				return nil // Normal shutdown
 			default:
 				return err // Unexpected error
@@ -108,7 +156,7 @@ func (s *Server) serve() error {
 		}
 
 		s.connections.Add(1)
-		go func(conn net.Conn) {
+		go func(conn transport.Conn) {
 			defer s.connections.Done()
 			s.handleConnection(conn)
 		}(conn)
@@ -122,31 +170,44 @@ func (s *Server) handleBroadcasts() {
 	for {
 		select {
 		case <-s.done:
-			wg.Wait() // Wait for all broadcasts to complete before shutting down
+			// Send what is still queued, then wait for all broadcasts to
+			// complete before the connections are closed.
+			for len(s.broadcastCh) > 0 {
+				s.deliverBroadcast(<-s.broadcastCh, &wg)
+			}
+			wg.Wait()
+			close(s.drained)
 			return
 		case msg := <-s.broadcastCh:
-			log.Printf("[BROADCAST] New message: %+v", msg)
-
-			s.mu.RLock()
-			users := s.users.GetAll()
-			s.mu.RUnlock()
-
-			// Start a broadcast batch
-			wg.Add(len(users))
-
-			for _, user := range users {
-				if user.Conn != nil {
-					go func(u *shared.User, m *shared.Message) {
-						defer wg.Done()
-						if err := shared.WriteMessage(u.Conn, m); err != nil {
-							log.Printf("Error broadcasting to %s: %v", u.Username, err)
-						}
-					}(user, msg)
-				} else {
-					wg.Done() // Don't forget to decrease counter for nil connections
-					log.Printf("[WARN] User %s has a nil connection, skipping broadcast", user.Username)
+			s.deliverBroadcast(msg, &wg)
+		}
+	}
+}
+
+// deliverBroadcast writes msg to every user, each in its own goroutine
+// tracked by wg.
+func (s *Server) deliverBroadcast(msg *shared.Message, wg *sync.WaitGroup) {
+	log.Debug("Broadcasting message", "type", msg.Type, "id", msg.ID, "from", msg.From)
+
+	s.mu.RLock()
+	users := s.users.GetAll()
+	s.mu.RUnlock()
+
+	// Start a broadcast batch
+	wg.Add(len(users))
+
+	for _, user := range users {
+		if user.Conn != nil {
+			go func(u *shared.User, m *shared.Message) {
+				defer wg.Done()
+				if err := shared.WriteMessage(u.Conn, m); err != nil {
+					s.metrics.deliveryErrors.Inc()
+					log.Error("Failed to broadcast", "user", u.Username, "err", err)
 				}
-			}
+			}(user, msg)
+		} else {
+			wg.Done() // Don't forget to decrease counter for nil connections
+			log.Warn("User has a nil connection, skipping broadcast", "user", user.Username)
 		}
 	}
 }
@@ -157,6 +218,7 @@ func (s *Server) SaveState() error {
 
 	state := map[string]interface{}{
 		"roomKey": base64.StdEncoding.EncodeToString(s.roomKey),
+		"bans":    s.bans,
 	}
 
 	data, err := json.MarshalIndent(state, "", "  ")
@@ -164,9 +226,43 @@ func (s *Server) SaveState() error {
 		return err
 	}
 
+	if err := s.history.Save(); err != nil {
+		log.Error("Failed to save message history", "err", err)
+	}
+	if s.webhooks != nil {
+		if err := s.webhooks.Save(); err != nil {
+			log.Error("Failed to save webhook queue", "err", err)
+		}
+	}
+
 	return os.WriteFile(s.stateFile, data, 0644)
 }
 
+// SetTransport replaces TCP with another transport, such as
+// transport.NewMemory in tests. Call it before Start.
+func (s *Server) SetTransport(t transport.Transport) {
+	s.transport = t
+}
+
+// SetAdmins sets the users allowed to moderate other users' messages.
+func (s *Server) SetAdmins(usernames []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.admins = make(map[string]bool)
+	for _, name := range usernames {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			s.admins[name] = true
+		}
+	}
+}
+
+func (s *Server) IsAdmin(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.admins[username]
+}
+
 func (s *Server) LoadState() error {
 	data, err := os.ReadFile(s.stateFile)
 	if err != nil {
@@ -181,17 +277,27 @@ func (s *Server) LoadState() error {
 	if rk, ok := state["roomKey"].(string); ok && rk != "" {
 		decoded, err := base64.StdEncoding.DecodeString(rk)
 		if err != nil {
-			log.Printf("[ERROR] Failed to decode room key: %v", err)
+			log.Error("Failed to decode room key, generating new one", "err", err)
 			s.roomKey = shared.GenerateRoomKey()
+			s.record(audit.RoomKeyGenerated, "", "", map[string]string{"reason": "invalid key in " + s.stateFile})
 		} else if len(decoded) == 0 {
-			log.Printf("[WARN] Loaded empty room key, regenerating new one")
+			log.Warn("Loaded empty room key, generating new one")
 			s.roomKey = shared.GenerateRoomKey()
+			s.record(audit.RoomKeyGenerated, "", "", map[string]string{"reason": "empty key in " + s.stateFile})
 		} else {
 			s.roomKey = decoded
+			s.record(audit.RoomKeyLoaded, "", "", map[string]string{"source": s.stateFile})
 		}
 	} else {
-		log.Printf("[WARN] No valid room key found in state, generating new one")
+		log.Warn("No valid room key found in state, generating new one")
 		s.roomKey = shared.GenerateRoomKey()
+		s.record(audit.RoomKeyGenerated, "", "", map[string]string{"reason": "no key in " + s.stateFile})
+	}
+
+	if bans, ok := state["bans"].(map[string]interface{}); ok {
+		for name, reason := range bans {
+			s.bans[name], _ = reason.(string)
+		}
 	}
 	return nil
 }
@@ -203,20 +309,26 @@ func (s *Server) loadOrGenerateRoomKey() {
 		decoded, err := base64.StdEncoding.DecodeString(str)
 		if err == nil && len(decoded) == 32 {
 			s.roomKey = decoded
-			log.Printf("[INFO] Loaded existing room key (len=%d)", len(s.roomKey))
+			log.Info("Loaded existing room key", "bytes", len(s.roomKey))
+			s.record(audit.RoomKeyLoaded, "", "", map[string]string{"source": "room.key"})
 			return
 		}
-		log.Printf("[WARN] Invalid room key format, regenerating new key: %v", err)
+		log.Warn("Invalid room key format, generating new one", "err", err)
 	}
 
 	// Generate new key
 	s.roomKey = shared.GenerateRoomKey()
 	if s.roomKey == nil {
-		log.Fatal("[FATAL] Failed to generate room key")
+		log.Fatal("Failed to generate room key")
 	}
 	encoded := base64.StdEncoding.EncodeToString(s.roomKey)
 	if err := os.WriteFile("room.key", []byte(encoded), 0600); err != nil {
-		log.Printf("[ERROR] Failed to save room key: %v", err)
+		log.Error("Failed to save room key", "err", err)
+	}
+	log.Info("Generated new room key", "bytes", len(s.roomKey))
+	reason := "no room.key"
+	if err == nil {
+		reason = "invalid room.key"
 	}
-	log.Printf("[INFO] Generated new room key (len=%d)", len(s.roomKey))
+	s.record(audit.RoomKeyGenerated, "", "", map[string]string{"reason": reason})
 }
@@ -0,0 +1,99 @@
+// Package session issues the tokens clients use to resume their login after
+// losing the connection.
+//
+// A token is issued at login and stays valid while the user is connected
+// and for a grace period after the connection ends. Presenting it on a new
+// connection logs the user back in, even while the server still holds the
+// old connection. Each user has at most one session.
+package session
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type session struct {
+	token    string
+	detached time.Time // zero while connected
+}
+
+// Store keeps the sessions of one server. It is safe for concurrent use.
+type Store struct {
+	ttl      time.Duration
+	sessions map[string]*session // username -> session
+	mu       sync.Mutex
+}
+
+// New creates a store whose sessions stay valid for ttl after their
+// connection ended.
+func New(ttl time.Duration) *Store {
+	return &Store{
+		ttl:      ttl,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Issue starts a session for username, replacing any earlier one, and
+// returns its token.
+func (s *Store) Issue(username string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[username] = &session{token: token}
+	return token, nil
+}
+
+// Resume reports whether token is a valid session of username and, if so,
+// marks it connected again.
+func (s *Store) Resume(username, token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(sess.token), []byte(token)) != 1 {
+		return false
+	}
+	if s.expired(sess, time.Now()) {
+		delete(s.sessions, username)
+		return false
+	}
+	sess.detached = time.Time{}
+	return true
+}
+
+// Detach starts the grace period of username's session once its connection
+// ended.
+func (s *Store) Detach(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if sess, ok := s.sessions[username]; ok {
+		sess.detached = now
+	}
+	for name, sess := range s.sessions {
+		if s.expired(sess, now) {
+			delete(s.sessions, name)
+		}
+	}
+}
+
+// Revoke ends username's session, for example after a kick or a clean
+// logout.
+func (s *Store) Revoke(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, username)
+}
+
+func (s *Store) expired(sess *session, now time.Time) bool {
+	return !sess.detached.IsZero() && now.Sub(sess.detached) > s.ttl
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"chatroom/internal/server/users"
+	"chatroom/internal/shared"
+	"chatroom/internal/transport"
+)
+
+// sessionTTL is how long a client may take to resume its session after
+// losing the connection.
+const sessionTTL = 2 * time.Minute
+
+// authenticate logs the sender of an auth message in on conn. A client that
+// presents a valid session token resumes its session, replacing the
+// connection the server may still hold for it; replaced reports that case.
+// Other clients log in as usual and get a new session. token is the session
+// token to hand to the client, empty if none could be issued.
+func (s *Server) authenticate(msg *shared.Message, conn transport.Conn) (user *shared.User, token string, replaced bool, err error) {
+	if s.reservedName(msg.From) {
+		return nil, "", false, fmt.Errorf("the name %s is reserved", users.Normalize(msg.From))
+	}
+	if msg.Session != "" && s.sessions.Resume(users.Normalize(msg.From), msg.Session) {
+		user, replaced = s.users.Resume(msg.From, conn)
+		return user, msg.Session, replaced, nil
+	}
+
+	user, err = s.users.AuthenticateUser(msg.From, conn)
+	if err != nil {
+		return nil, "", false, err
+	}
+	token, err = s.sessions.Issue(user.Username)
+	if err != nil {
+		log.Error("Failed to issue session token", "user", user.Username, "err", err)
+	}
+	return user, token, false, nil
+}
+
+// reservedName reports whether username is taken by the server itself, as
+// operatorName, a plugin or an incoming webhook integration, so no client
+// may log in as it and pass for that sender.
+func (s *Server) reservedName(username string) bool {
+	name := users.Normalize(username)
+	if name == operatorName || s.plugins.Has(name) {
+		return true
+	}
+	if s.incoming != nil {
+		for _, in := range s.incoming.Integrations {
+			if in.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replayMissed sends user the chat messages posted after lastSeen, the last
+// message its client received before reconnecting. Private messages are
+// only sent to their sender and recipient, and deleted ones are skipped.
+func (s *Server) replayMissed(user *shared.User, lastSeen string) {
+	missed, ok := s.history.Since(lastSeen)
+	if !ok {
+		log.Info("Cannot replay missed messages, last seen message is gone", "user", user.Username, "last_seen", lastSeen)
+		return
+	}
+
+	replayed := 0
+	for _, msg := range missed {
+		if msg.Deleted {
+			continue
+		}
+		switch msg.Type {
+		case shared.TypePublic:
+		case shared.TypePrivate:
+			if msg.To != user.Username && msg.From != user.Username {
+				continue
+			}
+		default:
+			continue
+		}
+		if err := user.WriteMessage(msg); err != nil {
+			log.Warn("Failed to replay missed messages", "user", user.Username, "err", err)
+			return
+		}
+		replayed++
+	}
+	if replayed > 0 {
+		log.Info("Replayed missed messages", "user", user.Username, "count", replayed)
+	}
+}
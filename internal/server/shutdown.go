@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"chatroom/internal/shared"
+	"chatroom/internal/transport"
+)
+
+// shutdownRetryAfter is how long clients are told to wait before
+// reconnecting after a shutdown notice.
+const shutdownRetryAfter = 10 * time.Second
+
+// Shutdown stops the server gracefully:
+//
+//  1. it stops accepting connections, logins and messages;
+//  2. it tells every user that the server is going down and when to
+//     reconnect;
+//  3. it waits for the messages being handled and for the broadcast queue
+//     to drain;
+//  4. it closes every client connection and waits for their handlers;
+//  5. it saves the server state and message history.
+//
+// If ctx ends first, the remaining steps run without waiting and ctx's error
+// is returned; the state is saved either way. Later calls do nothing.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() { err = s.shutdown(ctx) })
+	return err
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	log.Info("Shutting down")
+
+	s.inflightMu.Lock()
+	s.closing = true
+	s.inflightMu.Unlock()
+	close(s.stopping)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	s.notifyShutdown(ctx)
+
+	// Handlers still running may queue broadcasts, so the queue is drained
+	// only after they finished.
+	err := wait(ctx, s.inflight.Wait)
+	close(s.done)
+	if err == nil {
+		err = wait(ctx, func() { <-s.drained })
+	}
+
+	s.closeConnections()
+	if err == nil {
+		err = wait(ctx, s.connections.Wait)
+	}
+	s.users.Clear()
+
+	log.Info("Saving server state before shutdown")
+	if saveErr := s.SaveState(); saveErr != nil {
+		log.Error("Failed to save server state", "err", saveErr)
+	}
+	if err != nil {
+		log.Warn("Shutdown did not finish in time", "err", err)
+	}
+	return err
+}
+
+// wait runs fn and waits for it to return or ctx to end.
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notifyShutdown tells every logged-in user that the server is going down.
+// It writes directly rather than through the broadcast queue, so the notice
+// goes out before anything still queued.
+func (s *Server) notifyShutdown(ctx context.Context) {
+	msg := &shared.Message{
+		Type:       shared.TypeShutdown,
+		From:       "server",
+		Content:    "Server is shutting down",
+		RetryAfter: int(shutdownRetryAfter / time.Second),
+		Timestamp:  time.Now(),
+	}
+
+	var wg sync.WaitGroup
+	for _, user := range s.users.GetAll() {
+		wg.Add(1)
+		go func(u *shared.User) {
+			defer wg.Done()
+			if err := u.WriteMessage(msg); err != nil {
+				log.Warn("Failed to send shutdown notice", "user", u.Username, "err", err)
+			}
+		}(user)
+	}
+	wait(ctx, wg.Wait)
+}
+
+// trackConn adds conn to the connections closed on shutdown. It reports
+// false, and closes conn, if the server is already shutting down.
+func (s *Server) trackConn(conn transport.Conn) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	select {
+	case <-s.stopping:
+		conn.Close()
+		return false
+	default:
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(conn transport.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, conn)
+	s.connsMu.Unlock()
+}
+
+// closeConnections closes every client connection, including those that
+// have not logged in yet, so their handlers return.
+func (s *Server) closeConnections() {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// beginMessage registers a message about to be handled. It reports false
+// once the server is shutting down, when no new messages are handled.
+func (s *Server) beginMessage() bool {
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
+func (s *Server) shuttingDown() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"chatroom/internal/shared"
+)
+
+// resolveThreadParent checks the parent of a public reply. Replies to a reply
+// are attached to the root message so threads stay one level deep.
+func (s *Server) resolveThreadParent(msg *shared.Message) error {
+	if msg.ParentID == "" {
+		return nil
+	}
+
+	parent, ok := s.history.Get(msg.ParentID)
+	if !ok {
+		return fmt.Errorf("parent message %s not found", msg.ParentID)
+	}
+	if parent.Type != shared.TypePublic {
+		return fmt.Errorf("message %s cannot have replies", parent.ID)
+	}
+	if parent.ParentID != "" {
+		msg.ParentID = parent.ParentID
+	}
+	return nil
+}
+
+func (s *Server) broadcastThreadUpdate(parentID string) {
+	parent, ok := s.history.Get(parentID)
+	if !ok {
+		return
+	}
+	s.broadcast(&shared.Message{
+		Type:       shared.TypeThreadUpdate,
+		RefID:      parent.ID,
+		ReplyCount: parent.ReplyCount,
+		Timestamp:  time.Now(),
+	})
+}
+
+// handleThreadRequest sends the parent message followed by all its replies.
+func (s *Server) handleThreadRequest(user *shared.User, msg *shared.Message) error {
+	parent, replies, ok := s.history.Thread(msg.RefID)
+	if !ok || parent.Type != shared.TypePublic {
+		s.sendErrorToConn(user.Conn, "Thread not found")
+		return fmt.Errorf("thread %s not found", msg.RefID)
+	}
+
+	resp := &shared.Message{
+		Type:      shared.TypeThread,
+		RefID:     parent.ID,
+		Messages:  append([]*shared.Message{parent}, replies...),
+		Timestamp: time.Now(),
+	}
+	return user.WriteMessage(resp)
+}
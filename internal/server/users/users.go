@@ -3,14 +3,17 @@ package users
 import (
 	"crypto/rsa"
 	"fmt"
-	"net"
 	"strings"
 	"sync"
 	"time"
 
 	"chatroom/internal/shared"
+	"chatroom/internal/transport"
+	"chatroom/pkg/logger"
 )
 
+var log = logger.New("users")
+
 type Manager struct {
 	users map[string]*shared.User // username -> user
 	mu    sync.RWMutex
@@ -22,15 +25,20 @@ func New() *Manager {
 	}
 }
 
+// Normalize returns the form of a username the server stores and compares:
+// trimmed, without a leading @, lower case.
+func Normalize(username string) string {
+	norm := strings.TrimSpace(username)
+	norm = strings.TrimPrefix(norm, "@")
+	return strings.ToLower(norm)
+}
+
 // AuthenticateUser tries to authenticate a new user
-func (m *Manager) AuthenticateUser(username string, conn net.Conn) (*shared.User, error) {
+func (m *Manager) AuthenticateUser(username string, conn transport.Conn) (*shared.User, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Normalize username
-	norm := strings.TrimSpace(username)
-	norm = strings.TrimPrefix(norm, "@")
-	norm = strings.ToLower(norm)
+	norm := Normalize(username)
 
 	// Check for duplicate username
 	if _, exists := m.users[norm]; exists {
@@ -46,7 +54,7 @@ func (m *Manager) AuthenticateUser(username string, conn net.Conn) (*shared.User
 
 	// Add to active users
 	m.users[norm] = user
-	fmt.Print("User authenticated: ", norm, "\n")
+	log.Debug("User authenticated", "user", norm)
 	return user, nil
 }
 
@@ -56,6 +64,43 @@ func (m *Manager) Remove(username string) {
 	delete(m.users, username)
 }
 
+// RemoveUser removes u unless its name now belongs to another session, for
+// example after Resume replaced it. It reports whether u was removed.
+func (m *Manager) RemoveUser(u *shared.User) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.users[u.Username] != u {
+		return false
+	}
+	delete(m.users, u.Username)
+	return true
+}
+
+// Resume logs username back in on conn. If the user is still logged in on
+// an older connection, that connection is closed and replaced in one step,
+// keeping the user's public key; replaced reports whether that happened.
+func (m *Manager) Resume(username string, conn transport.Conn) (user *shared.User, replaced bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	norm := Normalize(username)
+	user = &shared.User{
+		Username: norm,
+		JoinedAt: time.Now(),
+		Conn:     conn,
+	}
+	old, replaced := m.users[norm]
+	if replaced {
+		user.JoinedAt = old.JoinedAt
+		user.PublicKey = old.PublicKey
+		user.PublicKeyPEM = old.PublicKeyPEM
+		old.Conn.Close()
+	}
+	m.users[norm] = user
+	log.Debug("User resumed", "user", norm, "replaced", replaced)
+	return user, replaced
+}
+
 func (m *Manager) GetByUsername(username string) (*shared.User, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -0,0 +1,434 @@
+// Package webhook POSTs room events to HTTP endpoints configured by the
+// operator. Deliveries are signed with HMAC-SHA256, retried with exponential
+// backoff and kept in a queue file so they survive a restart. Each endpoint
+// is delivered to by its own goroutine, so a slow or dead endpoint only
+// delays its own events. The file is
+// written in the background, so publishing an event never waits for the
+// disk.
+//
+// Chat messages are end-to-end encrypted for the room, so events carry
+// metadata only. An endpoint that names a bot account also receives public
+// messages in plaintext, but only while that bot is in the room: inviting the
+// bot is how the room agrees to share its messages with the integration.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"chatroom/internal/shared"
+	"chatroom/pkg/logger"
+)
+
+var log = logger.New("webhook")
+
+// Event types.
+const (
+	EventUserJoined    = "user.joined"
+	EventUserLeft      = "user.left"
+	EventFileAvailable = "file.available"
+	EventAdminAction   = "admin.action"
+	EventMessage       = "message.public" // only for endpoints with an invited bot
+)
+
+// Request headers.
+const (
+	HeaderEvent     = "X-Chatroom-Event"
+	HeaderDelivery  = "X-Chatroom-Delivery"
+	HeaderSignature = "X-Chatroom-Signature" // "sha256=" + hex HMAC of the body
+)
+
+const (
+	maxAttempts  = 10
+	baseBackoff  = 2 * time.Second
+	maxBackoff   = 10 * time.Minute
+	pollInterval = time.Second
+	maxQueue     = 1000 // pending deliveries per endpoint; the oldest is dropped beyond that
+)
+
+// Endpoint is one configured receiver.
+type Endpoint struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events,omitempty"` // empty means all but EventMessage
+	Bot    string   `json:"bot,omitempty"`    // bot account whose presence unlocks EventMessage
+}
+
+type Config struct {
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// LoadConfig reads a JSON file of the form {"endpoints": [...]}.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid webhook config %s: %v", path, err)
+	}
+	for i, ep := range cfg.Endpoints {
+		if ep.URL == "" || ep.Secret == "" {
+			return nil, fmt.Errorf("webhook endpoint %d needs a url and a secret", i+1)
+		}
+	}
+	return &cfg, nil
+}
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"event"`
+	Time     time.Time `json:"time"`
+	User     string    `json:"user,omitempty"`   // who joined, left, uploaded, acted or wrote
+	Target   string    `json:"target,omitempty"` // user or message an admin action applies to
+	Action   string    `json:"action,omitempty"` // kick, ban, unban, announce, edit or delete
+	Reason   string    `json:"reason,omitempty"`
+	Filename string    `json:"filename,omitempty"`
+	Private  bool      `json:"private,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Text     string    `json:"text,omitempty"` // EventMessage only
+}
+
+type delivery struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Event       string          `json:"event"`
+	Body        json.RawMessage `json:"body"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+
+	removed bool // no longer in the queue
+}
+
+// Dispatcher queues events and delivers them in the background.
+type Dispatcher struct {
+	endpoints []Endpoint
+	queuePath string
+	client    *http.Client
+	online    func(username string) bool
+
+	mu      sync.Mutex
+	queue   []*delivery
+	pending map[string]int // queued deliveries per endpoint URL
+	dirty   bool           // queue changed since it was last saved
+	wake    map[string]chan struct{}
+
+	saveMu sync.Mutex // one write of the queue file at a time
+}
+
+// New creates a dispatcher and loads the deliveries left over in queuePath.
+// online reports whether a user is in the room.
+func New(cfg *Config, queuePath string, online func(username string) bool) (*Dispatcher, error) {
+	d := &Dispatcher{
+		endpoints: cfg.Endpoints,
+		queuePath: queuePath,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		online:    online,
+		pending:   make(map[string]int),
+		wake:      make(map[string]chan struct{}),
+	}
+	for _, ep := range cfg.Endpoints {
+		d.wake[ep.URL] = make(chan struct{}, 1)
+	}
+
+	data, err := os.ReadFile(queuePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var queue []*delivery
+		if err := json.Unmarshal(data, &queue); err != nil {
+			return nil, fmt.Errorf("invalid webhook queue %s: %v", queuePath, err)
+		}
+		for _, dl := range queue {
+			if _, ok := d.wake[dl.URL]; !ok {
+				log.Warn("Dropping webhook, endpoint no longer configured", "id", dl.ID, "url", dl.URL)
+				d.dirty = true
+				continue
+			}
+			d.queue = append(d.queue, dl)
+			d.pending[dl.URL]++
+		}
+		log.Info("Loaded pending webhook deliveries", "count", len(d.queue))
+	}
+	return d, nil
+}
+
+// WantsMessages reports whether any endpoint would receive EventMessage
+// right now, so callers can skip decrypting messages otherwise.
+func (d *Dispatcher) WantsMessages() bool {
+	for _, ep := range d.endpoints {
+		if ep.Bot != "" && ep.subscribed(EventMessage) && d.online(ep.Bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish queues ev for every endpoint subscribed to its type.
+func (d *Dispatcher) Publish(ev Event) {
+	if ev.ID == "" {
+		ev.ID = shared.GenerateID()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Error("Failed to encode webhook event", "event", ev.Type, "err", err)
+		return
+	}
+
+	d.mu.Lock()
+	var queued []string
+	for _, ep := range d.endpoints {
+		if !ep.subscribed(ev.Type) {
+			continue
+		}
+		if ev.Type == EventMessage && (ep.Bot == "" || !d.online(ep.Bot)) {
+			continue
+		}
+		if d.pending[ep.URL] >= maxQueue {
+			d.dropOldestLocked(ep.URL)
+		}
+		d.queue = append(d.queue, &delivery{
+			ID:          ev.ID,
+			URL:         ep.URL,
+			Event:       ev.Type,
+			Body:        body,
+			NextAttempt: time.Now(),
+		})
+		d.pending[ep.URL]++
+		queued = append(queued, ep.URL)
+	}
+	if len(queued) > 0 {
+		d.dirty = true
+	}
+	d.mu.Unlock()
+
+	for _, url := range queued {
+		select {
+		case d.wake[url] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run delivers queued events until done is closed. Every endpoint gets its
+// own goroutine, which sends that endpoint's deliveries one at a time,
+// oldest first. The queue file is saved after each round of deliveries and
+// when Run returns.
+func (d *Dispatcher) Run(done <-chan struct{}) {
+	var wg sync.WaitGroup
+	for url := range d.wake {
+		ep, _ := d.endpoint(url)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runEndpoint(ep, done)
+		}()
+	}
+	wg.Wait()
+	d.save()
+}
+
+func (d *Dispatcher) runEndpoint(ep Endpoint, done <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for d.deliverNext(ep) {
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+		d.save()
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		case <-d.wake[ep.URL]:
+		}
+	}
+}
+
+// deliverNext sends the oldest due delivery for ep. It returns false when
+// nothing is due.
+func (d *Dispatcher) deliverNext(ep Endpoint) bool {
+	d.mu.Lock()
+	var next *delivery
+	now := time.Now()
+	for _, dl := range d.queue {
+		if dl.URL == ep.URL && !dl.NextAttempt.After(now) {
+			next = dl
+			break
+		}
+	}
+	d.mu.Unlock()
+	if next == nil {
+		return false
+	}
+
+	err := d.send(ep, next)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case next.removed:
+		// dropped by Publish while it was being sent
+	case err == nil:
+		d.removeLocked(next)
+	default:
+		next.Attempts++
+		if next.Attempts >= maxAttempts {
+			log.Error("Giving up on webhook", "id", next.ID, "event", next.Event, "url", next.URL,
+				"attempts", next.Attempts, "err", err)
+			d.removeLocked(next)
+		} else {
+			wait := backoff(next.Attempts)
+			next.NextAttempt = time.Now().Add(wait)
+			log.Warn("Webhook failed, retrying", "id", next.ID, "url", next.URL,
+				"attempt", next.Attempts, "wait", wait, "err", err)
+		}
+	}
+	d.dirty = true
+	return true
+}
+
+func (d *Dispatcher) send(ep Endpoint, dl *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.Event)
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set("X-Chatroom-Attempt", strconv.Itoa(dl.Attempts+1))
+	req.Header.Set(HeaderSignature, Sign(ep.Secret, dl.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body. Receivers compute the
+// same over the raw request body and compare with hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) endpoint(url string) (Endpoint, bool) {
+	for _, ep := range d.endpoints {
+		if ep.URL == url {
+			return ep, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+func (ep Endpoint) subscribed(event string) bool {
+	if len(ep.Events) == 0 {
+		return event != EventMessage
+	}
+	for _, e := range ep.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff doubles the wait after every failed attempt, with up to 20%
+// jitter so endpoints coming back up are not hit by every retry at once.
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff << (attempts - 1)
+	if wait <= 0 || wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait - time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+func (d *Dispatcher) removeLocked(dl *delivery) {
+	for i, q := range d.queue {
+		if q == dl {
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+			d.pending[dl.URL]--
+			dl.removed = true
+			return
+		}
+	}
+}
+
+// dropOldestLocked makes room in a full queue for url.
+func (d *Dispatcher) dropOldestLocked(url string) {
+	for _, dl := range d.queue {
+		if dl.URL == url {
+			log.Warn("Webhook queue full, dropping oldest event", "id", dl.ID, "event", dl.Event,
+				"url", url, "limit", maxQueue)
+			d.removeLocked(dl)
+			return
+		}
+	}
+}
+
+// Save writes the queue to disk if it changed since the last save. Run saves
+// by itself; the server also calls Save while shutting down, for the events
+// published after Run returned.
+func (d *Dispatcher) Save() error {
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+
+	d.mu.Lock()
+	if !d.dirty {
+		d.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(d.queue, "", "  ")
+	d.dirty = false
+	d.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook queue: %v", err)
+	}
+
+	tmp := d.queuePath + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, d.queuePath)
+	}
+	if err != nil {
+		d.mu.Lock()
+		d.dirty = true // try again next time
+		d.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (d *Dispatcher) save() {
+	if err := d.Save(); err != nil {
+		log.Error("Failed to save webhook queue", "err", err)
+	}
+}
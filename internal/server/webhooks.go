@@ -0,0 +1,49 @@
+package server
+
+import (
+	"chatroom/internal/server/webhook"
+	"chatroom/internal/shared"
+)
+
+const webhookQueueFile = "webhook_queue.json"
+
+// EnableWebhooks delivers room events to the endpoints in cfg. Call it
+// before Start. Undelivered events are kept in webhook_queue.json.
+func (s *Server) EnableWebhooks(cfg *webhook.Config) error {
+	d, err := webhook.New(cfg, webhookQueueFile, func(username string) bool {
+		_, ok := s.users.GetByUsername(username)
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+	s.webhooks = d
+	log.Info("Webhooks enabled", "endpoints", len(cfg.Endpoints))
+	return nil
+}
+
+func (s *Server) publishWebhook(ev webhook.Event) {
+	if s.webhooks != nil {
+		s.webhooks.Publish(ev)
+	}
+}
+
+// publishMessageWebhook sends a public message to the endpoints whose bot
+// has been invited to the room. Nothing is decrypted otherwise.
+func (s *Server) publishMessageWebhook(msg *shared.Message) {
+	if s.webhooks == nil || !s.webhooks.WantsMessages() {
+		return
+	}
+	plain, err := shared.DecryptWithRoomKey(msg.EncryptedData, s.roomKey)
+	if err != nil {
+		log.Warn("Cannot decrypt message for webhooks", "id", msg.ID, "err", err)
+		return
+	}
+	s.webhooks.Publish(webhook.Event{
+		Type:   webhook.EventMessage,
+		Time:   msg.Timestamp,
+		User:   msg.From,
+		Target: msg.ID,
+		Text:   string(plain),
+	})
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"chatroom/pkg/logger"
+
+	"golang.org/x/net/websocket"
+)
+
+var wsLog = logger.New("websocket")
+
+// maxWebSocketMessage limits one incoming WebSocket message. File transfers
+// are sent inline, so this is generous.
+const maxWebSocketMessage = 64 << 20
+
+// EnableWebSocket accepts WebSocket connections on addr (e.g. ":9001") in
+// addition to TCP once the server starts. Call it before Start.
+//
+// Each WebSocket text message carries one shared.Message as JSON, the same
+// JSON that TCP clients send as one line. WebSocket and TCP clients share the
+// room.
+func (s *Server) EnableWebSocket(addr string) {
+	s.wsAddr = addr
+}
+
+func (s *Server) serveWebSocket(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Server{
+		// Accept clients without an Origin header too: not every client is a
+		// browser, and the chat protocol has its own login.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   s.handleWebSocket,
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		wsLog.Error("WebSocket listener disabled", "err", err)
+		return
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-s.stopping
+		srv.Close()
+	}()
+
+	wsLog.Info("WebSocket listener started", "addr", ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		wsLog.Error("WebSocket listener stopped", "err", err)
+	}
+}
+
+func (s *Server) handleWebSocket(ws *websocket.Conn) {
+	ws.MaxPayloadBytes = maxWebSocketMessage
+	ws.PayloadType = websocket.TextFrame
+
+	s.connections.Add(1)
+	defer s.connections.Done()
+	s.handleConnection(newWSConn(ws))
+}
+
+// wsConn presents a WebSocket as the net.Conn that handleConnection and
+// shared.User work with: incoming messages are read as newline-terminated
+// JSON and every Write is sent as one message.
+type wsConn struct {
+	*inbox
+	ws     *websocket.Conn
+	remote net.Addr
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	c := &wsConn{
+		inbox:  newInbox(),
+		ws:     ws,
+		remote: wsAddr(ws.Request().RemoteAddr),
+		closed: make(chan struct{}),
+	}
+	go c.receive()
+	return c
+}
+
+// receive reads whole messages in its own goroutine, so read deadlines set
+// by the connection handler never interrupt a frame half way.
+func (c *wsConn) receive() {
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(c.ws, &data); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			c.stop(err)
+			return
+		}
+		if !c.put(data, c.closed) {
+			c.stop(net.ErrClosed)
+			return
+		}
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if err := websocket.Message.Send(c.ws, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.ws.Close()
+	})
+	return err
+}
+
+func (c *wsConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
+
+// wsAddr is the client address of a WebSocket connection, as reported by
+// net/http.
+type wsAddr string
+
+func (a wsAddr) Network() string { return "websocket" }
+func (a wsAddr) String() string  { return string(a) }
+
+var (
+	_ net.Conn  = (*wsConn)(nil)
+	_ net.Error = readTimeout{}
+)
@@ -13,8 +13,12 @@ import (
 	"fmt"
 	"io"
 	"strings"
+
+	"chatroom/pkg/logger"
 )
 
+var log = logger.New("crypto")
+
 func Encrypt(plain string, recipientPub *rsa.PublicKey) (string, string, error) {
 	// 1) Generate random AES-256 key
 	aesKey := make([]byte, 32)
@@ -134,7 +138,7 @@ func ParsePublicKeyFromPEM(pemBytes []byte) (*rsa.PublicKey, error) {
 func GenerateRoomKey() []byte {
 	key := make([]byte, 32) // AES-256
 	if _, err := io.ReadFull(rand.Reader, key); err != nil {
-		fmt.Println("Error generating room key:", err)
+		log.Error("Failed to generate room key", "err", err)
 		return nil
 	}
 	return key
@@ -142,20 +146,13 @@ func GenerateRoomKey() []byte {
 }
 
 func EncryptRoomKey(pub *rsa.PublicKey, roomKey []byte) (string, error) {
-	// Debug: show original roomKey (base64)
-	fmt.Printf("[DEBUG] EncryptRoomKey - original roomKey (b64): %s", base64.StdEncoding.EncodeToString(roomKey))
-
 	// RSA-OAEP encrypt directly on the raw bytes (don't convert to string)
 	encKeyBytes, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, roomKey, nil)
 	if err != nil {
 		return "", fmt.Errorf("rsa encrypt roomKey failed: %w", err)
 	}
 
-	encKeyB64 := base64.StdEncoding.EncodeToString(encKeyBytes)
-	// Debug: show base64 ciphertext being sent
-	fmt.Printf("[DEBUG] EncryptRoomKey - encKeyB64 len=%d: %s", len(encKeyB64), encKeyB64)
-
-	return encKeyB64, nil
+	return base64.StdEncoding.EncodeToString(encKeyBytes), nil
 }
 
 func EncryptWithRoomKey(plain string, roomKey []byte) (string, string, error) {
@@ -212,15 +209,13 @@ func DecryptRoomKey(encKeyB64 string, priv *rsa.PrivateKey) []byte {
 	encKeyB64 = strings.TrimSpace(encKeyB64)
 	encKeyBytes, err := base64.StdEncoding.DecodeString(encKeyB64)
 	if err != nil {
-		fmt.Println("Failed to decode base64 encKeyB64:", err)
+		log.Warn("Failed to decode encrypted room key", "err", err)
 		return nil
 	}
 	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, encKeyBytes, nil)
 	if err != nil {
-		fmt.Println("Failed to rsa.DecryptOAEP room key:", err)
+		log.Warn("Failed to decrypt room key", "err", err)
 		return nil
 	}
-	fmt.Printf("[DEBUG] Decrypted room key (b64): %s", base64.StdEncoding.EncodeToString(aesKey))
-	fmt.Printf("[DEBUG] Decrypted room key (hex): %x", aesKey)
 	return aesKey
 }
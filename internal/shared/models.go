@@ -2,9 +2,10 @@ package shared
 
 import (
 	"crypto/rsa"
-	"net"
 	"sync"
 	"time"
+
+	"chatroom/internal/transport"
 )
 
 type MessageType string
@@ -30,31 +31,80 @@ const (
 	TypePrivateFileTransfer          MessageType = "private_file_transfer"
 	TypePrivateFileTransferAvailable MessageType = "private_file_transfer_available"
 	TypePrivateFileDownload          MessageType = "private_file_download"
+	TypeTyping                       MessageType = "typing"   // Typing indicator
+	TypeStatus                       MessageType = "status"   // User-set presence status
+	TypePresence                     MessageType = "presence" // Presence updates
+	TypeDeliveredReceipt             MessageType = "delivered_receipt"
+	TypeReadReceipt                  MessageType = "read_receipt"
+	TypeEdit                         MessageType = "edit"   // Edit of an earlier message
+	TypeDelete                       MessageType = "delete" // Deletion of an earlier message
+	TypeReactionAdd                  MessageType = "reaction_add"
+	TypeReactionRemove               MessageType = "reaction_remove"
+	TypeReactions                    MessageType = "reactions" // Aggregated reactions of a message
+	TypeThreadRequest                MessageType = "thread_request"
+	TypeThread                       MessageType = "thread"        // Parent message and its replies
+	TypeThreadUpdate                 MessageType = "thread_update" // New reply count of a parent
+	TypeNick                         MessageType = "nick"          // Display nickname change
+	TypeKick                         MessageType = "kick"          // Admin removes a user
+	TypeAnnounce                     MessageType = "announce"      // Admin announcement to everyone
+	TypeShutdown                     MessageType = "shutdown"      // Server is going down; reconnect after RetryAfter
 )
 
+type PresenceStatus string
+
+const (
+	StatusOnline PresenceStatus = "online"
+	StatusAway   PresenceStatus = "away"
+	StatusBusy   PresenceStatus = "busy"
+)
+
+// IsValid reports whether s is one of the statuses a user may set.
+func (s PresenceStatus) IsValid() bool {
+	switch s {
+	case StatusOnline, StatusAway, StatusBusy:
+		return true
+	}
+	return false
+}
+
 type Message struct {
-	Type          MessageType `json:"type"`
-	From          string      `json:"from,omitempty"`
-	To            string      `json:"to,omitempty"`
-	Content       string      `json:"content"`
-	Timestamp     time.Time   `json:"timestamp"`
-	Users         []string    `json:"users,omitempty"`          // For user list updates
-	Success       bool        `json:"success,omitempty"`        // For auth responses
-	Error         string      `json:"error,omitempty"`          // For error messages
-	EncryptedKey  string      `json:"encrypted_key,omitempty"`  // base64 of RSA-encrypted AES key
-	EncryptedData string      `json:"encrypted_data,omitempty"` // base64 of AES-encrypted content
-	Filename      string      `json:"filename,omitempty"`       // Original filename of attached file
+	ID            string              `json:"id,omitempty"`
+	RefID         string              `json:"ref_id,omitempty"` // ID of the message this one refers to
+	Type          MessageType         `json:"type"`
+	From          string              `json:"from,omitempty"`
+	To            string              `json:"to,omitempty"`
+	Content       string              `json:"content"`
+	Timestamp     time.Time           `json:"timestamp"`
+	Users         []string            `json:"users,omitempty"`          // For user list updates
+	Success       bool                `json:"success,omitempty"`        // For auth responses
+	Error         string              `json:"error,omitempty"`          // For error messages
+	EncryptedKey  string              `json:"encrypted_key,omitempty"`  // base64 of RSA-encrypted AES key
+	EncryptedData string              `json:"encrypted_data,omitempty"` // base64 of AES-encrypted content
+	Filename      string              `json:"filename,omitempty"`       // Original filename of attached file
+	Status        PresenceStatus      `json:"status,omitempty"`         // For status changes
+	Presence      []Presence          `json:"presence,omitempty"`       // For presence updates
+	Admin         bool                `json:"admin,omitempty"`          // For auth responses
+	Edited        bool                `json:"edited,omitempty"`
+	Deleted       bool                `json:"deleted,omitempty"`
+	Reactions     map[string][]string `json:"reactions,omitempty"` // emoji -> usernames
+	ParentID      string              `json:"parent_id,omitempty"` // Thread parent of a reply
+	ReplyCount    int                 `json:"reply_count,omitempty"`
+	Messages      []*Message          `json:"messages,omitempty"`    // For thread responses
+	RetryAfter    int                 `json:"retry_after,omitempty"` // Seconds to wait before reconnecting, for shutdown notices
+	Session       string              `json:"session,omitempty"`     // Resumable session token, for auth messages and responses
 }
 
-type PendingFileTransfer struct {
-	Filename string
-	Target   string
+type Presence struct {
+	Username   string         `json:"username"`
+	Status     PresenceStatus `json:"status"`
+	StatusText string         `json:"status_text,omitempty"`
+	Nick       string         `json:"nick,omitempty"` // display nickname, if set
 }
 
 type User struct {
-	Username     string    `json:"username"`
-	JoinedAt     time.Time `json:"joinedAt"`
-	Conn         net.Conn  `json:"-"`
+	Username     string         `json:"username"`
+	JoinedAt     time.Time      `json:"joinedAt"`
+	Conn         transport.Conn `json:"-"`
 	writeMu      sync.Mutex
 	PublicKey    *rsa.PublicKey `json:"-"`
 	PublicKeyPEM string         `json:"publicKeyPEM"`
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Memory is an in-process transport for tests. Addresses are arbitrary
+// names; Dial connects to the listener registered under the same name
+// through a net.Pipe.
+type Memory struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+	conns     map[net.Conn]struct{} // server ends, for DropAll
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		listeners: make(map[string]*memoryListener),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+func (m *Memory) Listen(address string) (Listener, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, taken := m.listeners[address]; taken {
+		return nil, fmt.Errorf("memory transport: address %s already in use", address)
+	}
+	l := &memoryListener{
+		m:      m,
+		addr:   memoryAddr(address),
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	m.listeners[address] = l
+	return l, nil
+}
+
+func (m *Memory) Dial(ctx context.Context, address string) (Conn, error) {
+	m.mu.Lock()
+	l, ok := m.listeners[address]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("memory transport: connection refused: %s", address)
+	}
+
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		m.mu.Lock()
+		m.conns[server] = struct{}{}
+		m.mu.Unlock()
+		return client, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("memory transport: connection refused: %s", address)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Listening reports whether a listener is registered under address.
+func (m *Memory) Listening(address string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.listeners[address]
+	return ok
+}
+
+// DropAll closes every connection accepted so far, as if the network went
+// away. Listeners keep accepting new connections.
+func (m *Memory) DropAll() {
+	m.mu.Lock()
+	conns := m.conns
+	m.conns = make(map[net.Conn]struct{})
+	m.mu.Unlock()
+	for c := range conns {
+		c.Close()
+	}
+}
+
+type memoryListener struct {
+	m         *Memory
+	addr      memoryAddr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.m.mu.Lock()
+		delete(l.m.listeners, string(l.addr))
+		l.m.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr { return l.addr }
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
@@ -0,0 +1,58 @@
+// Package transport abstracts how clients and the server reach each other.
+// Production uses TCP; tests use Memory, which connects them through
+// in-memory pipes without opening sockets.
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// Conn is one client session's byte stream. The protocol on top of it is
+// newline-delimited JSON, whatever carries it.
+type Conn interface {
+	io.ReadWriteCloser
+	RemoteAddr() net.Addr
+	SetReadDeadline(t time.Time) error
+}
+
+// Listener accepts connections for the server.
+type Listener interface {
+	Accept() (Conn, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// Transport creates listeners for servers and connections for clients.
+type Transport interface {
+	Listen(address string) (Listener, error)
+	Dial(ctx context.Context, address string) (Conn, error)
+}
+
+// TCP is the production transport.
+var TCP Transport = tcpTransport{}
+
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(address string) (Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return tcpListener{ln}, nil
+}
+
+func (tcpTransport) Dial(ctx context.Context, address string) (Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", address)
+}
+
+type tcpListener struct {
+	net.Listener
+}
+
+func (l tcpListener) Accept() (Conn, error) {
+	return l.Listener.Accept()
+}
@@ -0,0 +1,401 @@
+// Package chatclient is a Go SDK for bots and integrations. It wraps the
+// desktop client's connection, so the login handshake and the encryption of
+// public (room key) and private (recipient's RSA key) messages work exactly
+// as they do in the GUI.
+//
+// A minimal bot:
+//
+//	c, err := chatclient.Dial(ctx, "localhost:9000", "echo-bot")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	for ev := range c.Subscribe(ctx) {
+//		if m, ok := ev.(chatclient.PublicMessageEvent); ok && !m.Message.Outgoing {
+//			c.Send("echo: " + m.Message.Content)
+//		}
+//	}
+package chatclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"chatroom/internal/client"
+)
+
+var (
+	// ErrAuthFailed is returned by Dial when the server rejects the username.
+	ErrAuthFailed = client.ErrAuthFailed
+	// ErrUnknownUser is returned when a private message or file is addressed
+	// to someone who is not online.
+	ErrUnknownUser = errors.New("unknown user")
+	// ErrClosed is returned by calls made after Close.
+	ErrClosed = errors.New("client closed")
+)
+
+// Events delivered by Subscribe. Handlers switch on the concrete type.
+type (
+	Event               = client.Event
+	Message             = client.ChatMessage
+	PublicMessageEvent  = client.PublicMessageEvent
+	PrivateMessageEvent = client.PrivateMessageEvent
+	MessageUpdatedEvent = client.MessageUpdatedEvent
+	ThreadEvent         = client.ThreadEvent
+	MentionEvent        = client.MentionEvent
+	UserListEvent       = client.UserListEvent
+	PresenceEvent       = client.PresenceEvent
+	TypingEvent         = client.TypingEvent
+	ReceiptEvent        = client.ReceiptEvent
+	UserJoinedEvent     = client.UserJoinedEvent
+	UserLeftEvent       = client.UserLeftEvent
+	FileAvailableEvent  = client.FileAvailableEvent
+	FileReceivedEvent   = client.FileReceivedEvent
+	InfoEvent           = client.InfoEvent
+	ErrorEvent          = client.ErrorEvent
+	KickedEvent         = client.KickedEvent
+	ServerShutdownEvent = client.ServerShutdownEvent
+	ConnectionEvent     = client.ConnectionEvent
+	SendStatusEvent     = client.SendStatusEvent
+)
+
+// ConnState is the connection state reported by ConnectionEvent.
+type ConnState = client.ConnState
+
+// SendStatus tells whether a message sent while offline has left the
+// outbox, as reported by SendStatusEvent.
+type SendStatus = client.SendStatus
+
+const (
+	SendSent    = client.SendSent
+	SendPending = client.SendPending
+	SendFailed  = client.SendFailed
+)
+
+const (
+	StateOffline      = client.StateOffline
+	StateConnected    = client.StateConnected
+	StateReconnecting = client.StateReconnecting
+)
+
+// subscriptionBuffer is how many events a subscriber may fall behind before
+// event delivery waits for it.
+const subscriptionBuffer = 64
+
+// Client is a logged in connection to the chat server. It is safe for
+// concurrent use.
+type Client struct {
+	c         *client.Client
+	mu        sync.Mutex
+	subs      map[*subscription]struct{}
+	closed    bool
+	usersOnce sync.Once
+	gotUsers  chan struct{} // closed once the first user list arrived
+}
+
+type subscription struct {
+	ch       chan Event
+	done     chan struct{}
+	doneOnce sync.Once
+	mu       sync.Mutex // held while sending on ch
+	closed   bool
+}
+
+// Dial connects to the server at address, logs in as username and waits
+// until the room key and the list of online users have arrived. It gives up
+// when ctx is done.
+func Dial(ctx context.Context, address, username string) (*Client, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("username cannot be empty")
+	}
+
+	cc := &Client{
+		c:        client.New(),
+		subs:     make(map[*subscription]struct{}),
+		gotUsers: make(chan struct{}),
+	}
+	cc.c.SetEventHandler(cc.dispatch)
+	if err := cc.c.Login(username); err != nil {
+		return nil, err
+	}
+	if err := cc.c.ConnectContext(ctx, address); err != nil {
+		return nil, err
+	}
+
+	for _, ready := range []<-chan struct{}{cc.c.RoomKeyReady(), cc.gotUsers} {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			cc.c.Disconnect()
+			return nil, ctx.Err()
+		}
+	}
+	return cc, nil
+}
+
+// Username returns the username as normalized by the server.
+func (c *Client) Username() string {
+	return c.c.GetUsername()
+}
+
+// Users returns the users currently online.
+func (c *Client) Users() []string {
+	return append([]string(nil), c.c.GetActiveUsers()...)
+}
+
+// Send posts text to the public room.
+func (c *Client) Send(text string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	return c.c.SendMessage(text)
+}
+
+// Reply posts text to the thread of the public message parentID.
+func (c *Client) Reply(parentID, text string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	return c.c.SendReply(parentID, text)
+}
+
+// SendPrivate sends text to one user, encrypted with their public key.
+func (c *Client) SendPrivate(to, text string) error {
+	if err := c.checkUser(to); err != nil {
+		return err
+	}
+	return c.c.SendPrivateMessage(to, text)
+}
+
+// Flush waits until every message and file queued so far has left the
+// outbox. Private ones wait there for the recipient's public key, so call
+// Flush before Close to make sure they went out. It returns why the first
+// of them could not be sent, if one could not.
+func (c *Client) Flush(ctx context.Context) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := c.Subscribe(ctx)
+
+	var failed error
+	handle := func(ev Event) {
+		if s, ok := ev.(SendStatusEvent); ok && s.Status == SendFailed && failed == nil {
+			failed = errors.New(s.Err)
+		}
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for c.c.Unsent() > 0 {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return ErrClosed
+			}
+			handle(ev)
+		case <-ticker.C:
+		}
+	}
+	// Items are reported before they leave the outbox, so any failure is
+	// already waiting in events.
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return failed
+			}
+			handle(ev)
+		default:
+			return failed
+		}
+	}
+}
+
+// SendFile uploads the file at path for everyone in the room.
+func (c *Client) SendFile(path string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	return c.c.SendFile(path)
+}
+
+// SendPrivateFile sends the file at path to one user.
+func (c *Client) SendPrivateFile(to, path string) error {
+	if err := c.checkUser(to); err != nil {
+		return err
+	}
+	return c.c.SendPrivateFile(path, to)
+}
+
+// Download fetches a file shared in the room and returns the path it was
+// saved to.
+func (c *Client) Download(ctx context.Context, filename string) (string, error) {
+	return c.download(ctx, filename, false, func() error {
+		return c.c.RequestFile(filename)
+	})
+}
+
+// DownloadPrivate fetches a file that from sent to us and returns the path
+// it was saved to.
+func (c *Client) DownloadPrivate(ctx context.Context, from, filename string) (string, error) {
+	return c.download(ctx, filename, true, func() error {
+		return c.c.RequestPrivateFile(filename, from)
+	})
+}
+
+func (c *Client) download(ctx context.Context, filename string, private bool, request func() error) (string, error) {
+	if err := c.check(); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := c.Subscribe(ctx)
+
+	if err := request(); err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(filename)
+	for ev := range events {
+		switch ev := ev.(type) {
+		case FileReceivedEvent:
+			if ev.Filename == name && ev.Private == private {
+				return ev.Path, nil
+			}
+		case ErrorEvent:
+			// The server quotes the filename in its "not found" and
+			// "failed to read" errors.
+			if strings.Contains(ev.Text, "'"+name+"'") {
+				return "", errors.New(ev.Text)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrClosed
+}
+
+// Subscribe returns a channel that receives every event from now on. The
+// channel is closed when ctx is done or the client is closed. Slow
+// subscribers hold up event delivery, so keep reading.
+func (c *Client) Subscribe(ctx context.Context) <-chan Event {
+	sub := &subscription{
+		ch:   make(chan Event, subscriptionBuffer),
+		done: make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		close(sub.ch)
+		return sub.ch
+	}
+	c.subs[sub] = struct{}{}
+	c.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+		c.unsubscribe(sub)
+	}()
+	return sub.ch
+}
+
+// Close logs out and closes all subscriptions.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	subs := c.subs
+	c.subs = make(map[*subscription]struct{})
+	c.mu.Unlock()
+
+	for sub := range subs {
+		sub.close()
+	}
+	return c.c.Disconnect()
+}
+
+func (c *Client) check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (c *Client) checkUser(name string) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	if !c.c.UserExists(strings.TrimSpace(name)) {
+		return fmt.Errorf("%w: %s", ErrUnknownUser, name)
+	}
+	return nil
+}
+
+func (c *Client) dispatch(ev Event) {
+	if _, ok := ev.(UserListEvent); ok {
+		c.usersOnce.Do(func() { close(c.gotUsers) })
+	}
+
+	c.mu.Lock()
+	subs := make([]*subscription, 0, len(c.subs))
+	for sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.send(ev)
+	}
+}
+
+func (c *Client) unsubscribe(sub *subscription) {
+	c.mu.Lock()
+	delete(c.subs, sub)
+	c.mu.Unlock()
+	sub.close()
+}
+
+func (s *subscription) send(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- ev:
+	case <-s.done:
+	}
+}
+
+func (s *subscription) close() {
+	s.doneOnce.Do(func() { close(s.done) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
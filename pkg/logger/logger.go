@@ -1,28 +1,193 @@
+// Package logger is the leveled, structured logger used by the server and
+// the clients. Every line carries a level and the component that wrote it,
+// and is written as logfmt or JSON:
+//
+//	time=2026-01-02T15:04:05.000Z level=INFO component=server msg="User joined" user=alice addr=127.0.0.1:51234
+//
+// Loggers are usually package variables created with New; the output, level
+// and format are process-wide and may be changed at any time with SetOutput,
+// SetLevel and SetFormat. The defaults are stderr, info and logfmt, or the
+// values of $CHATROOM_LOG_LEVEL and $CHATROOM_LOG_FORMAT.
+//
+// Key material and message text must never reach a log. Besides not passing
+// them in the first place, the logger redacts what slips through; see
+// redact.go.
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// Level is the severity of a log line.
+type Level = slog.Level
+
+const (
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+)
+
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(s string) (Level, error) {
+	var l Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return l, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", s)
+	}
+	return l, nil
+}
+
+// Format is how log lines are written.
+type Format int
+
+const (
+	FormatLogfmt Format = iota
+	FormatJSON
+)
+
+// ParseFormat parses "logfmt" (or "text") and "json".
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "logfmt", "text", "":
+		return FormatLogfmt, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatLogfmt, fmt.Errorf("unknown log format %q (use logfmt or json)", s)
+}
+
+// output is the process-wide configuration. Every change bumps gen so that
+// loggers rebuild their cached handler.
+type output struct {
+	gen     uint64
+	handler slog.Handler
+}
+
+var (
+	mu      sync.Mutex
+	out     io.Writer = os.Stderr
+	format            = FormatLogfmt
+	level             = new(slog.LevelVar)
+	current atomic.Pointer[output]
+)
+
+func init() {
+	if l, err := ParseLevel(os.Getenv("CHATROOM_LOG_LEVEL")); err == nil {
+		level.Set(l)
+	}
+	if f, err := ParseFormat(os.Getenv("CHATROOM_LOG_FORMAT")); err == nil {
+		format = f
+	}
+	rebuild()
+}
+
+// rebuild installs a handler for the current settings. mu must be held,
+// except during init.
+func rebuild() {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	var h slog.Handler
+	if format == FormatJSON {
+		h = slog.NewJSONHandler(out, opts)
+	} else {
+		h = slog.NewTextHandler(out, opts)
+	}
+	var gen uint64
+	if prev := current.Load(); prev != nil {
+		gen = prev.gen + 1
+	}
+	current.Store(&output{gen: gen, handler: h})
+}
+
+// SetOutput sets where every logger writes.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+	rebuild()
+}
+
+// SetLevel sets the lowest level that is written.
+func SetLevel(l Level) {
+	level.Set(l)
+}
+
+// SetFormat sets the format of every logger.
+func SetFormat(f Format) {
+	mu.Lock()
+	defer mu.Unlock()
+	format = f
+	rebuild()
+}
+
+// Logger writes lines tagged with a component and, optionally, fixed
+// attributes added with With.
 type Logger struct {
-	*log.Logger
+	component string
+	attrs     []any
+	cache     atomic.Pointer[cached]
 }
 
-func New(prefix string) *Logger {
+type cached struct {
+	gen    uint64
+	logger *slog.Logger
+}
+
+// New returns a logger for component, such as "server" or "client".
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// With returns a logger that adds the given key-value pairs to every line.
+func (l *Logger) With(args ...any) *Logger {
 	return &Logger{
-		Logger: log.New(os.Stdout, "["+prefix+"] ", log.LstdFlags),
+		component: l.component,
+		attrs:     append(append([]any(nil), l.attrs...), args...),
+	}
+}
+
+func (l *Logger) slog() *slog.Logger {
+	o := current.Load()
+	if c := l.cache.Load(); c != nil && c.gen == o.gen {
+		return c.logger
+	}
+	sl := slog.New(o.handler).With("component", l.component)
+	if len(l.attrs) > 0 {
+		sl = sl.With(l.attrs...)
 	}
+	l.cache.Store(&cached{gen: o.gen, logger: sl})
+	return sl
 }
 
-func (l *Logger) Error(v ...interface{}) {
-	l.Println("ERROR:", v)
+func (l *Logger) log(lvl Level, msg string, args []any) {
+	if !l.Enabled(lvl) {
+		return
+	}
+	l.slog().Log(context.Background(), lvl, msg, args...)
 }
 
-func (l *Logger) Info(v ...interface{}) {
-	l.Println("INFO:", v)
+// Enabled reports whether lines at lvl are written, for callers that would
+// otherwise do work to build them.
+func (l *Logger) Enabled(lvl Level) bool {
+	return lvl >= level.Level()
 }
 
-func (l *Logger) Debug(v ...interface{}) {
-	l.Println("DEBUG:", v)
+// Debug, Info, Warn and Error write msg with key-value pairs, e.g.
+//
+//	log.Info("User joined", "user", name, "addr", addr)
+func (l *Logger) Debug(msg string, args ...any) { l.log(LevelDebug, msg, args) }
+func (l *Logger) Info(msg string, args ...any)  { l.log(LevelInfo, msg, args) }
+func (l *Logger) Warn(msg string, args ...any)  { l.log(LevelWarn, msg, args) }
+func (l *Logger) Error(msg string, args ...any) { l.log(LevelError, msg, args) }
+
+// Fatal writes msg at error level and exits with status 1.
+func (l *Logger) Fatal(msg string, args ...any) {
+	l.log(LevelError, msg, args)
+	os.Exit(1)
 }
@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces anything the logger refuses to write.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never written, whatever
+// they hold. Keys ending in _key, _token or _secret are sensitive too.
+var sensitiveKeys = map[string]bool{
+	"key": true, "secret": true, "password": true, "token": true, "session": true,
+	"content": true, "text": true, "plaintext": true, "body": true, "data": true,
+	"ciphertext": true, "encrypted_data": true, "encrypted_key": true,
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	return sensitiveKeys[key] ||
+		strings.HasSuffix(key, "_key") || strings.HasSuffix(key, "_token") || strings.HasSuffix(key, "_secret")
+}
+
+var (
+	pemBlock = regexp.MustCompile(`-----BEGIN [A-Z ]+-----[\s\S]*?(-----END [A-Z ]+-----|$)`)
+	// base64Run and hexRun match encoded keys and ciphertext: a 32-byte key
+	// is 44 base64 or 64 hex characters.
+	base64Run = regexp.MustCompile(`[A-Za-z0-9+/]{40,}={0,2}`)
+	hexRun    = regexp.MustCompile(`\b[0-9a-fA-F]{64,}\b`)
+)
+
+// scrub removes PEM blocks and anything that looks like an encoded key from
+// s. It is a backstop for values built with fmt, such as error messages.
+func scrub(s string) string {
+	if len(s) < 40 {
+		return s
+	}
+	s = pemBlock.ReplaceAllString(s, Redacted)
+	s = hexRun.ReplaceAllString(s, Redacted)
+	return base64Run.ReplaceAllStringFunc(s, func(run string) string {
+		// Random base64 almost always mixes cases and digits; paths and
+		// words rarely do.
+		if strings.ContainsAny(run, "0123456789") &&
+			strings.ContainsAny(run, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") &&
+			strings.ContainsAny(run, "abcdefghijklmnopqrstuvwxyz") {
+			return Redacted
+		}
+		return run
+	})
+}
+
+// redactAttr is the ReplaceAttr hook of every handler, and also sees the
+// message. It redacts sensitive keys and byte slices, scrubs strings and
+// errors, and writes only the type of other values that are not plain
+// scalars: structs and maps can carry keys or message text, so log the
+// fields you need instead.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 {
+		switch a.Key {
+		case slog.TimeKey, slog.LevelKey:
+			return a
+		}
+	}
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, scrub(a.Value.String()))
+	case slog.KindAny:
+		return slog.Any(a.Key, safeValue(a.Value.Any()))
+	}
+	return a
+}
+
+func safeValue(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return Redacted
+	case error:
+		return scrub(v.Error())
+	case fmt.Stringer:
+		return scrub(v.String())
+	case []string:
+		safe := make([]string, len(v))
+		for i, s := range v {
+			safe[i] = scrub(s)
+		}
+		return safe
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.String:
+		return scrub(rv.String())
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return v
+	}
+	return fmt.Sprintf("[%T omitted]", v)
+}